@@ -0,0 +1,36 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// decodeBigDecimal turns the output of BigDecimal#_dump, such as "18:0.1e3",
+// into a *big.Rat, which holds any decimal exactly. The part before the colon
+// is the maximum precision, which a big.Rat doesn't need. NaN and the
+// infinities have no big.Rat, and come back as float64 instead.
+func decodeBigDecimal(u UserDef) (interface{}, error) {
+	data := string(u.Data)
+	i := strings.IndexByte(data, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid BigDecimal data %q", data)
+	}
+
+	switch s := data[i+1:]; s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity", "+Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	default:
+		x, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid BigDecimal data %q", data)
+		}
+
+		return x, nil
+	}
+}
@@ -0,0 +1,108 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestLoadBigDecimal(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    string
+		data   interface{}
+	}{
+		{
+			// BigDecimal("123.45")
+			"Decimal",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x0f, 0x42, 0x69,
+				0x67, 0x44, 0x65, 0x63, 0x69, 0x6d, 0x61, 0x6c,
+				0x11, 0x31, 0x38, 0x3a, 0x30, 0x2e, 0x31, 0x32,
+				0x33, 0x34, 0x35, 0x65, 0x33, 0x06, 0x3a, 0x06,
+				0x45, 0x46,
+			},
+			"",
+			big.NewRat(12345, 100),
+		},
+		{
+			// BigDecimal("-0.001"), dumped without the encoding
+			"Negative exponent",
+			[]byte{
+				0x04, 0x08, 0x75, 0x3a, 0x0f, 0x42, 0x69, 0x67,
+				0x44, 0x65, 0x63, 0x69, 0x6d, 0x61, 0x6c, 0x0e,
+				0x39, 0x3a, 0x2d, 0x30, 0x2e, 0x31, 0x65, 0x2d,
+				0x32,
+			},
+			"",
+			big.NewRat(-1, 1000),
+		},
+		{
+			// BigDecimal("NaN")
+			"NaN",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x0f, 0x42, 0x69,
+				0x67, 0x44, 0x65, 0x63, 0x69, 0x6d, 0x61, 0x6c,
+				0x0a, 0x39, 0x3a, 0x4e, 0x61, 0x4e, 0x06, 0x3a,
+				0x06, 0x45, 0x46,
+			},
+			"",
+			math.NaN(),
+		},
+		{
+			// BigDecimal("-Infinity")
+			"Negative infinity",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x0f, 0x42, 0x69,
+				0x67, 0x44, 0x65, 0x63, 0x69, 0x6d, 0x61, 0x6c,
+				0x10, 0x39, 0x3a, 0x2d, 0x49, 0x6e, 0x66, 0x69,
+				0x6e, 0x69, 0x74, 0x79, 0x06, 0x3a, 0x06, 0x45,
+				0x46,
+			},
+			"",
+			math.Inf(-1),
+		},
+		{
+			"Missing precision",
+			[]byte{
+				0x04, 0x08, 0x75, 0x3a, 0x0f, 0x42, 0x69, 0x67,
+				0x44, 0x65, 0x63, 0x69, 0x6d, 0x61, 0x6c, 0x08,
+				0x31, 0x32, 0x33,
+			},
+			`invalid BigDecimal data "123" at offset 19`,
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("error: got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+
+			switch want := c.data.(type) {
+			case *big.Rat:
+				if x, ok := data.(*big.Rat); !ok || x.Cmp(want) != 0 {
+					t.Errorf("data: got %v, want %v", data, want)
+				}
+			case float64:
+				f, ok := data.(float64)
+				if !ok || (math.IsNaN(want) != math.IsNaN(f)) || (!math.IsNaN(want) && f != want) {
+					t.Errorf("data: got %v, want %v", data, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package rbmarshal
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func bigPow2(n uint, sign int) *big.Int {
+	x := new(big.Int).Lsh(big.NewInt(1), n)
+	if sign < 0 {
+		x.Neg(x).Sub(x, big.NewInt(1))
+	}
+
+	return x
+}
+
+// compatCases are the values testdata/compat/generate.rb dumps, by the name
+// of their fixture, as Load decodes them.
+var compatCases = map[string]interface{}{
+	"fixnum_zero":             0,
+	"fixnum_small":            122,
+	"fixnum_one_byte":         123,
+	"fixnum_negative":         -124,
+	"fixnum_two_bytes":        256,
+	"fixnum_max":              1<<30 - 1,
+	"fixnum_min":              -(1 << 30),
+	"bignum_2_30":             1 << 30,
+	"bignum_2_64":             bigPow2(64, 1),
+	"bignum_negative":         bigPow2(64, -1),
+	"bignum_2_100":            bigPow2(100, 1),
+	"float_one_and_a_half":    1.5,
+	"float_third":             1.0 / 3,
+	"float_big":               1e100,
+	"float_tiny":              math.SmallestNonzeroFloat64,
+	"float_negative_zero":     math.Copysign(0, -1),
+	"float_infinity":          math.Inf(1),
+	"float_negative_infinity": math.Inf(-1),
+	"float_nan":               math.NaN(),
+	"string_utf8":             "héllo",
+	"string_binary":           "abc",
+	"symbol":                  Symbol("sym"),
+	"symbols_repeated":        makeSlice(Symbol("a"), Symbol("a")),
+	"string_shared":           makeSlice("x", "x"),
+	"array_nested":            makeSlice(1, makeSlice(2, makeSlice(3))),
+	"hash_mixed_keys":         map[string]interface{}{"a": 1, "b": 2},
+	"struct":                  RStruct{"CompatPoint", []StructMember{{"x", 1}, {"y", 2}}},
+	"object":                  RObject{"CompatObject", map[string]interface{}{"@name": "obj", "@count": 3}},
+	"regexp":                  regexp.MustCompile("(?im)a+b"),
+	"time_nsec":               time.Unix(1700000000, 123456789).UTC(),
+}
+
+// compatEngines are the values of RUBY_ENGINE that must have a directory of
+// fixtures.
+var compatEngines = []string{"jruby", "truffleruby"}
+
+// TestCompatFixtures decodes the dumps captured by generate.rb, one directory
+// per Ruby implementation and version.
+func TestCompatFixtures(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "compat", "*", "VERSION"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range compatEngines {
+		captured := false
+		for _, version := range dirs {
+			if strings.HasPrefix(filepath.Base(filepath.Dir(version)), engine+"-") {
+				captured = true
+			}
+		}
+		if !captured {
+			t.Errorf("no fixtures captured with %s, see testdata/compat/README.md", engine)
+		}
+	}
+
+	opts := cmp.Options{
+		cmp.Comparer(equalRegexps),
+		cmp.Comparer(func(x, y *big.Int) bool {
+			return x.Cmp(y) == 0
+		}),
+		cmp.Comparer(func(x, y float64) bool {
+			return math.Float64bits(x) == math.Float64bits(y) || math.IsNaN(x) && math.IsNaN(y)
+		}),
+	}
+
+	for _, version := range dirs {
+		dir := filepath.Dir(version)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			files, err := filepath.Glob(filepath.Join(dir, "*.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := map[string]bool{}
+			for _, file := range files {
+				name := strings.TrimSuffix(filepath.Base(file), ".bin")
+				found[name] = true
+
+				want, ok := compatCases[name]
+				if !ok {
+					t.Errorf("%s: no expected value", name)
+					continue
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := LoadBytes(data)
+				if err != nil {
+					t.Errorf("%s: unexpected error: '%q'", name, err)
+					continue
+				}
+				if !cmp.Equal(got, want, opts) {
+					t.Errorf("%s: got %v, want %v", name, got, want)
+				}
+			}
+			for name := range compatCases {
+				if !found[name] {
+					t.Errorf("%s: no fixture", name)
+				}
+			}
+		})
+	}
+}
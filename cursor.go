@@ -0,0 +1,99 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"io"
+)
+
+// byteReader is what the decoder reads from: a *bufio.Reader, or a cursor
+// over a payload that is in memory already.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+}
+
+var (
+	_ byteReader = (*bufio.Reader)(nil)
+	_ byteReader = (*cursor)(nil)
+)
+
+// A cursor reads from a byte slice without copying it into a buffer first.
+type cursor struct {
+	data []byte
+	pos  int
+}
+
+func (c *cursor) Read(p []byte) (int, error) {
+	if c.pos >= len(c.data) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, c.data[c.pos:])
+	c.pos += n
+
+	return n, nil
+}
+
+func (c *cursor) ReadByte() (byte, error) {
+	if c.pos >= len(c.data) {
+		return 0, io.EOF
+	}
+
+	b := c.data[c.pos]
+	c.pos++
+
+	return b, nil
+}
+
+func (c *cursor) Peek(n int) ([]byte, error) {
+	if rest := len(c.data) - c.pos; n > rest {
+		return c.data[c.pos:], io.EOF
+	}
+
+	return c.data[c.pos : c.pos+n], nil
+}
+
+func (c *cursor) Discard(n int) (int, error) {
+	if rest := len(c.data) - c.pos; n > rest {
+		c.pos = len(c.data)
+		return rest, io.EOF
+	}
+	c.pos += n
+
+	return n, nil
+}
+
+// next returns the next n bytes, which share memory with the payload.
+func (c *cursor) next(n int) ([]byte, error) {
+	if rest := len(c.data) - c.pos; n > rest {
+		c.pos = len(c.data)
+		if rest == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := c.data[c.pos : c.pos+n : c.pos+n]
+	c.pos += n
+
+	return b, nil
+}
+
+// LoadBytes decodes a single dump from data, the way Load does, but reads
+// data in place rather than through a buffer. Byte slices in the result,
+// like the Data of a UserDef, share memory with data, which must not change
+// while they are in use.
+func LoadBytes(data []byte) (interface{}, error) {
+	return LoadBytesWith(data, new(LoadArg))
+}
+
+// LoadBytesWith is like LoadBytes but decodes according to the options set on
+// arg, like LoadWith.
+func LoadBytesWith(data []byte, arg *LoadArg) (interface{}, error) {
+	return load(&cursor{data: data}, arg)
+}
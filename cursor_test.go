@@ -0,0 +1,93 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestLoadBytes(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+	}{
+		// [1, "Hi", :a, :a]
+		{"Array", []byte{
+			0x04, 0x08, 0x5b, 0x09, 0x69, 0x06, 0x49, 0x22,
+			0x07, 0x48, 0x69, 0x06, 0x3a, 0x06, 0x45, 0x54,
+			0x3a, 0x06, 0x61, 0x3b, 0x06,
+		}},
+		// {"a"=>[1.5]}
+		{"Hash", []byte{
+			0x04, 0x08, 0x7b, 0x06, 0x49, 0x22, 0x06, 0x61,
+			0x06, 0x3a, 0x06, 0x45, 0x54, 0x5b, 0x06, 0x66,
+			0x08, 0x31, 0x2e, 0x35,
+		}},
+		// s = "x"; [s, s]
+		{"Link", []byte{
+			0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x78,
+			0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06,
+		}},
+	}
+
+	for _, c := range cases {
+		want, err := Load(bytes.NewReader(c.stream))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: '%q'", c.desc, err)
+		}
+		got, err := LoadBytes(c.stream)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: '%q'", c.desc, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", c.desc, got, want)
+		}
+	}
+}
+
+func TestLoadBytesSharesData(t *testing.T) {
+	// Money._load("12")
+	stream := []byte{
+		0x04, 0x08, 0x75, 0x3a, 0x0a, 0x4d, 0x6f, 0x6e,
+		0x65, 0x79, 0x07, 0x31, 0x32,
+	}
+
+	data, err := LoadBytes(stream)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	u, ok := data.(UserDef)
+	if !ok || string(u.Data) != "12" {
+		t.Fatalf("got %v, want Money with 12", data)
+	}
+	if &u.Data[0] != &stream[11] {
+		t.Errorf("the data of the user type was copied")
+	}
+	// Appending must not overwrite the rest of the payload.
+	if cap(u.Data) != len(u.Data) {
+		t.Errorf("got a capacity of %d, want %d", cap(u.Data), len(u.Data))
+	}
+}
+
+func TestLoadBytesErrors(t *testing.T) {
+	cases := []struct {
+		desc     string
+		stream   []byte
+		maxBytes int64
+		err      error
+	}{
+		{"Empty", []byte{}, 0, io.EOF},
+		{"Truncated string", []byte{0x04, 0x08, 0x22, 0x08, 0x61}, 0, io.ErrUnexpectedEOF},
+		{"Missing string", []byte{0x04, 0x08, 0x22, 0x08}, 0, io.ErrUnexpectedEOF},
+		{"Over budget", []byte{0x04, 0x08, 0x22, 0x08, 0x61, 0x62, 0x63}, 6, ErrBudgetExceeded},
+	}
+
+	for _, c := range cases {
+		_, err := LoadBytesWith(c.stream, &LoadArg{MaxBytes: c.maxBytes})
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.desc, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// The Julian day number of 1970-01-01.
+	unixEpochJD = 2440588
+
+	// Date counts days past this period in a separate number, nth, the
+	// way date_core.c defines it.
+	cmPeriod = 0xfffffff / 71149239 * 71149239
+)
+
+// decodeDate turns the output of Date#marshal_dump, which DateTime shares,
+// into a time.Time. The data is [nth, jd, df, sf, of, sg]: the Julian day
+// number and the seconds and nanoseconds into that day, all in UTC, the UTC
+// offset in seconds and the day of the calendar reform, which Go doesn't need
+// as it always uses the proleptic Gregorian calendar.
+func decodeDate(u UserMarshal) (interface{}, error) {
+	a, ok := u.Data.([]interface{})
+	if !ok || len(a) != 6 {
+		return nil, fmt.Errorf("unsupported %s data %v", u.Class, u.Data)
+	}
+
+	var n [5]int
+	for i := range n {
+		if n[i], ok = intOf(a[i]); !ok {
+			return nil, fmt.Errorf("invalid %s data %v", u.Class, u.Data)
+		}
+	}
+	nth, jd, df, sf, of := n[0], n[1], n[2], n[3], n[4]
+
+	days := int64(nth)*cmPeriod + int64(jd) - unixEpochJD
+	t := time.Unix(days*24*60*60+int64(df), int64(sf)).UTC()
+	if of != 0 {
+		t = t.In(time.FixedZone("", of))
+	}
+
+	return t, nil
+}
@@ -0,0 +1,88 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLoadDate(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    string
+		time   time.Time
+		offset int
+	}{
+		{
+			// Date.new(2020, 5, 17)
+			"Date",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x09, 0x44, 0x61, 0x74,
+				0x65, 0x5b, 0x0b, 0x69, 0x00, 0x69, 0x03, 0x6b,
+				0x85, 0x25, 0x69, 0x00, 0x69, 0x00, 0x69, 0x00,
+				0x66, 0x0c, 0x32, 0x32, 0x39, 0x39, 0x31, 0x36,
+				0x31,
+			},
+			"",
+			time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC),
+			0,
+		},
+		{
+			// DateTime.new(2020, 5, 17, 12, 34, 56, "+09:00")
+			"DateTime",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x44, 0x61, 0x74,
+				0x65, 0x54, 0x69, 0x6d, 0x65, 0x5b, 0x0b, 0x69,
+				0x00, 0x69, 0x03, 0x6b, 0x85, 0x25, 0x69, 0x02,
+				0x60, 0x32, 0x69, 0x00, 0x69, 0x02, 0x90, 0x7e,
+				0x66, 0x0c, 0x32, 0x32, 0x39, 0x39, 0x31, 0x36,
+				0x31,
+			},
+			"",
+			time.Date(2020, 5, 17, 3, 34, 56, 0, time.UTC),
+			9 * 60 * 60,
+		},
+		{
+			// A Date dumped by Ruby 1.8, [ajd, of, sg]
+			"Old format",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x09, 0x44, 0x61, 0x74,
+				0x65, 0x5b, 0x08, 0x69, 0x00, 0x69, 0x00, 0x69,
+				0x00,
+			},
+			"unsupported Date data [0 0 0] at offset 17",
+			time.Time{},
+			0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("error: got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+
+			tm, ok := data.(time.Time)
+			if !ok {
+				t.Fatalf("data: got %v, want a time.Time", data)
+			}
+			if !tm.Equal(c.time) {
+				t.Errorf("time: got %v, want %v", tm, c.time)
+			}
+			if _, offset := tm.Zone(); offset != c.offset {
+				t.Errorf("offset: got %d, want %d", offset, c.offset)
+			}
+		})
+	}
+}
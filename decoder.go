@@ -0,0 +1,278 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// A Decoder reads and decodes Marshal data from an input stream. The stream
+// may contain several dumps written back to back, each one starting with its
+// own version header.
+//
+// The options of the embedded LoadArg, like FlattenSymbols, OrderedHashes,
+// MaxBytes or UserDefDecoders, apply to every dump the decoder reads. MaxBytes
+// caps each dump rather than the whole stream. DisallowUnknownTypes is set by
+// NewDecoder.
+type Decoder struct {
+	r   *bufio.Reader
+	buf *bufio.Reader // unlike r, never belongs to the caller
+	src io.Reader     // what r reads from, for DecodeContext
+
+	// The state of Token, which may stop in the middle of a dump.
+	frames []tokenFrame
+	inDump bool
+
+	// How many bytes the dumps read before the current one took.
+	consumed int64
+
+	LoadArg
+
+	// OnObject, if set, is called with every object Decode reads. An error
+	// returned by OnObject aborts decoding and is returned by Decode.
+	OnObject func(interface{}) error
+}
+
+// NewDecoder returns a new decoder that reads from r. If r is not a
+// *bufio.Reader already, it gets wrapped into one.
+//
+// The decoder treats the end of r as the end of the stream, so a reader of a
+// single archive entry, such as *tar.Reader or the reader returned by
+// zip.File.Open, can be decoded directly.
+func NewDecoder(r io.Reader) *Decoder {
+	d := new(Decoder)
+	d.DisallowUnknownTypes = true
+	d.Reset(r)
+
+	return d
+}
+
+// Reset discards the state of the decoder and makes it read from r, reusing
+// the buffers allocated so far.
+func (d *Decoder) Reset(r io.Reader) {
+	d.frames = d.frames[:0]
+	d.inDump = false
+	d.consumed = 0
+	d.offset = 0
+	d.src = r
+
+	if br, ok := r.(*bufio.Reader); ok {
+		d.r = br
+		return
+	}
+
+	if d.buf == nil {
+		d.buf = bufio.NewReader(r)
+	} else {
+		d.buf.Reset(r)
+	}
+	d.r = d.buf
+}
+
+// Decode reads the next dump from the stream and stores the result in the
+// value pointed to by v, converting it the way Unmarshal does, or passes it to
+// UnmarshalRuby if v implements Unmarshaler. After a call to Token, Decode
+// reads the next value of the dump being streamed instead. At the end of the
+// stream Decode returns io.EOF. A stream that ends in the middle of a dump
+// yields a DecodeError for io.ErrUnexpectedEOF.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Decode expects a non-nil pointer")
+	}
+
+	var data interface{}
+	var err error
+	if d.inDump {
+		data, err = d.decodeValue()
+	} else {
+		if _, err = d.r.Peek(1); err != nil {
+			return err
+		}
+		d.nextDump()
+		data, err = LoadWith(d.r, &d.LoadArg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.OnObject != nil {
+		if err = d.OnObject(data); err != nil {
+			return err
+		}
+	}
+
+	return assign(rv.Elem(), data, &d.LoadArg)
+}
+
+// BytesConsumed returns how many bytes of the stream the decoder has read so
+// far, through the end of the last value it returned. The *bufio.Reader it
+// reads from may have buffered more.
+func (d *Decoder) BytesConsumed() int64 {
+	return d.consumed + d.offset
+}
+
+// nextDump accounts for the bytes of the last dump, before the next one
+// resets the offset.
+func (d *Decoder) nextDump() {
+	d.consumed += d.offset
+	d.offset = 0
+}
+
+// RegisterClass makes the decoder decode plain objects of class with fn,
+// before the decoders registered with the package-level RegisterClass.
+// Registering nil removes the decoder of class.
+func (d *Decoder) RegisterClass(class string, fn ObjectDecoder) {
+	if fn == nil {
+		delete(d.ObjectDecoders, class)
+		return
+	}
+
+	if d.ObjectDecoders == nil {
+		d.ObjectDecoders = make(map[string]ObjectDecoder)
+	}
+	d.ObjectDecoders[class] = fn
+}
+
+// Skip reads past the next dump of the stream or, after a call to Token, the
+// next value of the dump being streamed, without decoding it. Symbols are
+// still kept, for the symlinks that follow them, but no string, array, hash or
+// object is built. Like streamed values, skipped ones can't be linked to.
+func (d *Decoder) Skip() (err error) {
+	defer recoverPanic(&err, &d.LoadArg)
+
+	if d.inDump {
+		err = d.skipValue()
+	} else {
+		if _, err = d.r.Peek(1); err != nil {
+			return err
+		}
+		d.nextDump()
+		resetLoadArg(&d.LoadArg)
+		if err = validateVersion(d.r, &d.LoadArg); err == nil {
+			err = skipValue(d.r, &d.LoadArg)
+		}
+	}
+
+	return decodeError(err, &d.LoadArg)
+}
+
+// More reports whether there is another value in the array, hash or object
+// being streamed with Token, or else another dump in the stream. It lets a
+// stream of back to back dumps be read in a loop:
+//
+//	for d.More() {
+//		if err := d.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//
+// An error reading the stream makes More return false; the next call to
+// Decode or Token returns it.
+func (d *Decoder) More() bool {
+	if n := len(d.frames); n > 0 {
+		return d.frames[n-1].left > 0
+	}
+	if d.inDump {
+		return true
+	}
+
+	_, err := d.r.Peek(1)
+	return err == nil
+}
+
+// DecodeContext is like Decode but gives up as soon as ctx is done, returning
+// ctx.Err() unless the value was decoded already. Decoding stops at the next value, and a read that is waiting for
+// data is interrupted if the reader passed to NewDecoder has a
+// SetReadDeadline method, like a net.Conn does. The deadline is then left in
+// the past, and the stream can't be read further without resetting it.
+func (d *Decoder) DecodeContext(ctx context.Context, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if conn, ok := d.src.(interface{ SetReadDeadline(time.Time) error }); ok {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetReadDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
+
+	d.ctx = ctx
+	err := d.Decode(v)
+	d.ctx = nil
+
+	// A failure once ctx is done is most likely down to ctx, like a read
+	// cut short by the deadline. A value decoded in full is kept all the
+	// same.
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// DecodeAll decodes every dump in r and returns them in the order they were
+// read. If the stream ends with a partial dump, DecodeAll returns the
+// successfully decoded objects along with the error.
+func DecodeAll(r io.Reader) ([]interface{}, error) {
+	d := NewDecoder(r)
+	objs := make([]interface{}, 0)
+	for {
+		var v interface{}
+		err := d.Decode(&v)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return objs, err
+		}
+		objs = append(objs, v)
+	}
+}
+
+// DecodeEach decodes every dump in r and passes it to fn without keeping it
+// around. It stops at the first error, including one returned by fn.
+func DecodeEach(r io.Reader, fn func(interface{}) error) error {
+	d := NewDecoder(r)
+	d.OnObject = fn
+	for {
+		var v interface{}
+		err := d.Decode(&v)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func assign(dst reflect.Value, data interface{}, arg *LoadArg) error {
+	if dst.CanAddr() {
+		if u, ok := dst.Addr().Interface().(Unmarshaler); ok {
+			return u.UnmarshalRuby(data)
+		}
+	}
+
+	if data == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	src := reflect.ValueOf(data)
+	if !src.Type().AssignableTo(dst.Type()) {
+		return convert(dst, data, arg)
+	}
+	dst.Set(src)
+
+	return nil
+}
@@ -0,0 +1,487 @@
+package rbmarshal
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeAll(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    error
+		data   []interface{}
+	}{
+		{
+			"Empty stream",
+			[]byte{},
+			nil,
+			makeSlice(),
+		},
+		{
+			"Three concatenated dumps",
+			[]byte{
+				// true
+				0x04, 0x08, 0x54,
+				// 123
+				0x04, 0x08, 0x69, 0x01, 0x7B,
+				// "Hi"
+				0x04, 0x08, 0x49, 0x22, 0x07, 0x48, 0x69, 0x06,
+				0x3A, 0x06, 0x45, 0x54,
+			},
+			nil,
+			makeSlice(true, 123, "Hi"),
+		},
+		{
+			"Trailing partial dump",
+			[]byte{
+				0x04, 0x08, 0x54,
+				0x04, 0x08, 0x69, 0x01, 0x7B,
+				0x04, 0x08, 0x5b, 0x07, 0x69, 0x06,
+			},
+			io.ErrUnexpectedEOF,
+			makeSlice(true, 123),
+		},
+		{
+			"Trailing partial header",
+			[]byte{0x04, 0x08, 0x54, 0x04},
+			io.ErrUnexpectedEOF,
+			makeSlice(true),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := DecodeAll(bytes.NewReader(c.stream))
+			if !errors.Is(err, c.err) {
+				t.Fatalf("error: got %v, want %v", err, c.err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestDecoderDecode(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{
+		0x04, 0x08, 0x69, 0x01, 0x7B,
+		0x04, 0x08, 0x49, 0x22, 0x07, 0x48, 0x69, 0x06,
+		0x3A, 0x06, 0x45, 0x54,
+	}))
+
+	var n int
+	if err := d.Decode(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 123 {
+		t.Errorf("data: got %d, want %d", n, 123)
+	}
+
+	var s int
+	if err := d.Decode(&s); err == nil {
+		t.Errorf("expected an error decoding a string into an int")
+	}
+
+	if err := d.Decode(&s); err != io.EOF {
+		t.Errorf("error: got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestDecodeEach(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 100; i++ {
+		// i
+		stream = append(stream, 0x04, 0x08, 0x69, byte(i+5))
+	}
+
+	t.Run("All objects", func(t *testing.T) {
+		var sum int
+		err := DecodeEach(bytes.NewReader(stream), func(v interface{}) error {
+			sum += v.(int)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sum != 4950 {
+			t.Errorf("sum: got %d, want %d", sum, 4950)
+		}
+	})
+
+	t.Run("Early abort", func(t *testing.T) {
+		stop := errors.New("stop")
+
+		var seen int
+		err := DecodeEach(bytes.NewReader(stream), func(v interface{}) error {
+			seen++
+			if v.(int) == 9 {
+				return stop
+			}
+			return nil
+		})
+		if err != stop {
+			t.Fatalf("error: got %v, want %v", err, stop)
+		}
+		if seen != 10 {
+			t.Errorf("objects seen: got %d, want %d", seen, 10)
+		}
+	})
+}
+
+func TestDecoderTarEntry(t *testing.T) {
+	entries := []struct {
+		name string
+		body []byte
+	}{
+		// Marshal.dump([1, "Hi"])
+		{
+			"array.dump",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x49, 0x22,
+				0x07, 0x48, 0x69, 0x06, 0x3A, 0x06, 0x45, 0x54,
+			},
+		},
+		// The same dump cut short.
+		{
+			"truncated.dump",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06},
+		},
+		{"README", []byte("not marshal data")},
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0600, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&archive)
+
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := DecodeAll(tr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := makeSlice(makeSlice(1, "Hi"))
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+
+	if _, err = tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err = NewDecoder(tr).Decode(&v); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("error: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecoderAllowVersions(t *testing.T) {
+	// A 4.7 dump of [1, :a], then a 4.6 one of nil.
+	stream := []byte{
+		0x04, 0x07, 0x5b, 0x07, 0x69, 0x06, 0x3a, 0x06,
+		0x61, 0x04, 0x06, 0x30,
+	}
+
+	var v interface{}
+	err := NewDecoder(bytes.NewReader(stream)).Decode(&v)
+	if err == nil || err.Error() != "unsupported marshal version [4 7], wanted [4 8] at offset 0" {
+		t.Fatalf("error: got %v", err)
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	d.AllowVersions = [][2]byte{{4, 6}, {4, 7}}
+	if err = d.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := makeSlice(1, Symbol("a")); !reflect.DeepEqual(v, want) {
+		t.Errorf("data: got %v, want %v", v, want)
+	}
+	if err = d.Decode(&v); err != nil || v != nil {
+		t.Errorf("got %v, %v, want nil", v, err)
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	// A dump of {a: :b}, then one of Money._load("12").
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x3a,
+		0x06, 0x62, 0x04, 0x08, 0x75, 0x3a, 0x0a, 0x4d,
+		0x6f, 0x6e, 0x65, 0x79, 0x07, 0x31, 0x32,
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	d.FlattenSymbols = true
+	d.AnyKeys = true
+	d.UserDefDecoders = map[string]UserDefDecoder{
+		"Money": func(u UserDef) (interface{}, error) {
+			return "$" + string(u.Data), nil
+		},
+	}
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[interface{}]interface{}{"a": "b"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("data: got %#v, want %#v", v, want)
+	}
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v != "$12" {
+		t.Errorf("data: got %v, want $12", v)
+	}
+
+	// The budget applies to each dump.
+	d = NewDecoder(bytes.NewReader(stream))
+	d.MaxBytes = 12
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.Decode(&v); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("got %v, want %v", err, ErrBudgetExceeded)
+	}
+}
+
+func TestDecoderMore(t *testing.T) {
+	// 1, then [2, 3], then {a: 4}.
+	stream := []byte{
+		0x04, 0x08, 0x69, 0x06,
+		0x04, 0x08, 0x5b, 0x07, 0x69, 0x07, 0x69, 0x08,
+		0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x69,
+		0x09,
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	var data []interface{}
+	for d.More() {
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data = append(data, v)
+	}
+	want := makeSlice(1, makeSlice(2, 3), map[string]interface{}{"a": 4})
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+
+	// Inside a streamed array, More tells whether it has elements left.
+	d = NewDecoder(bytes.NewReader(stream[4:]))
+	if tok, err := d.Token(); err != nil || tok != (ArrayStart{2}) {
+		t.Fatalf("got %v and %v, want ArrayStart{2}", tok, err)
+	}
+	var sum int
+	for d.More() {
+		var n int
+		if err := d.Decode(&n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sum += n
+	}
+	if sum != 5 {
+		t.Errorf("sum: got %d, want 5", sum)
+	}
+	if tok, err := d.Token(); err != nil || tok != (End{}) {
+		t.Errorf("got %v and %v, want End{}", tok, err)
+	}
+	if !d.More() {
+		t.Error("More: got false after the first dump, want true")
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	// ["ab", "ab"] with the same string twice, then 7.
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x07, 0x61,
+		0x62, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06,
+		0x04, 0x08, 0x69, 0x0c,
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	if err := d.Skip(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var n int
+	if err := d.Decode(&n); err != nil || n != 7 {
+		t.Fatalf("got %v and %v, want 7", n, err)
+	}
+	if err := d.Skip(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+
+	// Skipping builds nothing but the symbols. This is an array of two
+	// binary strings.
+	binary := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x22, 0x07, 0x61, 0x62,
+		0x22, 0x07, 0x63, 0x64,
+	}
+	r := bytes.NewReader(binary)
+	allocs := testing.AllocsPerRun(10, func() {
+		r.Reset(binary)
+		d.Reset(r)
+		if err := d.Skip(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations, want 0", allocs)
+	}
+
+	// Point.new(1, [2]), with @y ignored.
+	d = NewDecoder(bytes.NewReader([]byte{
+		0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+		0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40, 0x78, 0x69,
+		0x06, 0x3a, 0x07, 0x40, 0x79, 0x5b, 0x06, 0x69,
+		0x07,
+	}))
+	ivars := map[string]int{}
+	if _, err := d.Token(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for d.More() {
+		var name string
+		if err := d.Decode(&name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "@x" {
+			if err := d.Skip(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			continue
+		}
+		var x int
+		if err := d.Decode(&x); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ivars[name] = x
+	}
+	if want := map[string]int{"@x": 1}; !reflect.DeepEqual(ivars, want) {
+		t.Errorf("got %v, want %v", ivars, want)
+	}
+	if err := d.Skip(); err == nil {
+		t.Error("skipping past the end of the object didn't fail")
+	}
+}
+
+// cancelingReader cancels a context once it has been read from.
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p)
+}
+
+func TestDecoderDecodeContext(t *testing.T) {
+	// [1, 2, 3]
+	stream := []byte{0x04, 0x08, 0x5b, 0x08, 0x69, 0x06, 0x69, 0x07, 0x69, 0x08}
+
+	var v interface{}
+	d := NewDecoder(bytes.NewReader(stream))
+	if err := d.DecodeContext(context.Background(), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := makeSlice(1, 2, 3); !reflect.DeepEqual(v, want) {
+		t.Errorf("data: got %v, want %v", v, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d = NewDecoder(bytes.NewReader(stream))
+	if err := d.DecodeContext(ctx, &v); err != context.Canceled {
+		t.Errorf("canceled context: got %v, want %v", err, context.Canceled)
+	}
+
+	// Canceled in the middle of the dump.
+	ctx, cancel = context.WithCancel(context.Background())
+	d = NewDecoder(&cancelingReader{bytes.NewReader(stream), cancel})
+	if err := d.DecodeContext(ctx, &v); err != context.Canceled {
+		t.Errorf("canceled while decoding: got %v, want %v", err, context.Canceled)
+	}
+
+	// Canceled once the value is decoded, which is kept.
+	ctx, cancel = context.WithCancel(context.Background())
+	d = NewDecoder(bytes.NewReader([]byte{0x04, 0x08, 0x69, 0x06}))
+	d.OnObject = func(interface{}) error {
+		cancel()
+		return nil
+	}
+	var n int
+	if err := d.DecodeContext(ctx, &n); err != nil || n != 1 {
+		t.Errorf("canceled after decoding: got %v, %v, want 1, nil", n, err)
+	}
+
+	// Canceled while waiting for the rest of the dump.
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go server.Write(stream[:6])
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	d = NewDecoder(client)
+	if err := d.DecodeContext(ctx, &v); err != context.DeadlineExceeded {
+		t.Errorf("blocked read: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDecoderBytesConsumed(t *testing.T) {
+	// [1, 2], then "abc".
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x69, 0x07,
+		0x04, 0x08, 0x22, 0x08, 0x61, 0x62, 0x63,
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	if got := d.BytesConsumed(); got != 0 {
+		t.Errorf("at the start: got %d, want 0", got)
+	}
+
+	steps := []struct {
+		desc string
+		step func() error
+		want int64
+	}{
+		{"Token", func() error { _, err := d.Token(); return err }, 4},
+		{"Decode", func() error { var n int; return d.Decode(&n) }, 6},
+		{"Skip", d.Skip, 8},
+		{"End", func() error { _, err := d.Token(); return err }, 8},
+		{"Decode", func() error { var s string; return d.Decode(&s) }, 15},
+	}
+	for _, s := range steps {
+		if err := s.step(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", s.desc, err)
+		}
+		if got := d.BytesConsumed(); got != s.want {
+			t.Errorf("after %s: got %d, want %d", s.desc, got, s.want)
+		}
+	}
+
+	d.Reset(bytes.NewReader(stream))
+	if got := d.BytesConsumed(); got != 0 {
+		t.Errorf("after Reset: got %d, want 0", got)
+	}
+}
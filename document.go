@@ -0,0 +1,493 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+// A Node is a record of a Marshal stream, as read with LoadArg.Document.
+// Nodes keep everything that the plain values lose, like the order of ivars
+// and of hash pairs, the encoding of strings and which objects are shared, so
+// dumping a tree of nodes writes back the bytes it was read from. A payload
+// can be edited in place and passed on unchanged otherwise.
+//
+// Object links are resolved to the node they point to, and the node is
+// linked to again when it is dumped a second time. Symlinks are resolved to
+// the name of the symbol.
+type Node struct {
+	// Type is the type byte of the record, like '"' for a string or '['
+	// for an array. It's never an ivar wrapper nor a link.
+	Type byte
+
+	// Class is the name of the class of an object, a struct, a user type or
+	// a data object, the name of the module an extended value is extended
+	// with, or the name of the user class of a value of a core class.
+	Class string
+
+	// Int is the value of a fixnum, and Big the one of a bignum.
+	Int int
+	Big *big.Int
+
+	// Bytes holds the bytes of a string, the source of a regexp, the name
+	// of a symbol, a class or a module, the text of a float, or the output
+	// of _dump.
+	Bytes []byte
+
+	// Options holds the option bits of a regexp.
+	Options byte
+
+	// Elems holds the elements of an array.
+	Elems []*Node
+
+	// Pairs holds the pairs of a hash, in order, and Default its default
+	// value, if the type is '}'.
+	Pairs   []NodePair
+	Default *Node
+
+	// Ivars holds the ivars of an object or the members of a struct. For
+	// the other types, it holds the ivars the record is wrapped with, the
+	// encoding of strings and symbols included.
+	Ivars []NodeIvar
+
+	// Data is the value a record wraps: the result of marshal_dump, the
+	// one of _dump_data, the value that is extended or the value of a user
+	// class.
+	Data *Node
+}
+
+// NodePair is a key and a value of a hash node.
+type NodePair struct {
+	Key   *Node
+	Value *Node
+}
+
+// NodeIvar is an ivar of a node, or a member of a struct.
+type NodeIvar struct {
+	Name  string
+	Value *Node
+}
+
+// readNode reads the next record as a node.
+func readNode(r byteReader, arg *LoadArg) (*Node, error) {
+	if err := enterValue(arg); err != nil {
+		return nil, err
+	}
+	defer leaveValue(arg)
+
+	t, err := readByte(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return readNodeOf(r, arg, t)
+}
+
+func readNodeOf(r byteReader, arg *LoadArg, t byte) (*Node, error) {
+	switch t {
+	case typeIvar:
+		return readWrappedNode(r, arg)
+	case typeObjlink:
+		i, err := readFixnum(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := nodeAt(arg.Objects, i)
+		if !ok {
+			return nil, fmt.Errorf("%w %d", ErrBadLink, i)
+		}
+		return n, nil
+	}
+
+	n, inner, err := readNodeBody(r, arg, t)
+	if err == nil && inner != nil && registersLate(inner.Type) {
+		arg.Objects = append(arg.Objects, inner)
+	}
+
+	return n, err
+}
+
+// nodeAt returns the node in slot i of the object table, if there is one.
+func nodeAt(objects []interface{}, i int) (*Node, bool) {
+	if i < 0 || i >= len(objects) {
+		return nil, false
+	}
+	n, ok := objects[i].(*Node)
+
+	return n, ok
+}
+
+// readWrappedNode reads a record wrapped with ivars.
+func readWrappedNode(r byteReader, arg *LoadArg) (*Node, error) {
+	t, err := readByte(r, arg)
+	if err != nil {
+		return nil, err
+	}
+	if t == typeSymbol {
+		// Symbols take no slot, so there is no inner node to register.
+		name, err := readSymbol(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		n := &Node{Type: typeSymbol, Bytes: []byte(name)}
+		if n.Ivars, err = readNodeIvars(r, arg); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+
+	n, inner, err := readNodeBody(r, arg, t)
+	if err != nil {
+		return nil, err
+	}
+	if n.Ivars, err = readNodeIvars(r, arg); err != nil {
+		return nil, err
+	}
+	if inner != nil && registersLate(inner.Type) {
+		arg.Objects = append(arg.Objects, inner)
+	}
+
+	return n, nil
+}
+
+// registersLate reports whether Ruby registers records of type t after their
+// ivars, rather than before their contents.
+func registersLate(t byte) bool {
+	return t == typeUserdef
+}
+
+// readNodeBody reads a record of type t, but its ivars. It returns the node
+// and the one inside the wrappers, if the record is extended or of a user
+// class, which is the one that takes a slot.
+func readNodeBody(r byteReader, arg *LoadArg, t byte) (*Node, *Node, error) {
+	n := &Node{Type: t}
+	register := func() {
+		arg.Objects = append(arg.Objects, n)
+	}
+
+	var err error
+	switch t {
+	case typeNil, typeTrue, typeFalse:
+		return n, nil, nil
+	case typeFixnum:
+		n.Int, err = readFixnum(r, arg)
+		return n, nil, err
+	case typeSymbol:
+		var name string
+		name, err = readSymbol(r, arg)
+		n.Bytes = []byte(name)
+		return n, nil, err
+	case typeSymlink:
+		var name string
+		name, err = readSymlink(r, arg)
+		n.Type = typeSymbol
+		n.Bytes = []byte(name)
+		return n, nil, err
+
+	case typeBignum:
+		var v interface{}
+		if v, err = readBignum(r, arg); err != nil {
+			return nil, nil, err
+		}
+		// readBignum registered the number itself.
+		arg.Objects[len(arg.Objects)-1] = n
+		if i, ok := v.(int); ok {
+			n.Big = big.NewInt(int64(i))
+		} else {
+			n.Big = v.(*big.Int)
+		}
+		return n, n, nil
+
+	case typeFloat, typeString, typeClass, typeModule, typeModuleOld:
+		register()
+		n.Bytes, err = readBytes(r, arg)
+	case typeRegexp:
+		register()
+		if n.Bytes, err = readBytes(r, arg); err == nil {
+			n.Options, err = readByte(r, arg)
+		}
+	case typeUserdef:
+		if n.Class, err = readName(r, arg); err == nil {
+			n.Bytes, err = readBytes(r, arg)
+		}
+
+	case typeArray:
+		register()
+		var size int
+		if size, err = readCollectionLen(r, arg); err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < size; i++ {
+			var e *Node
+			if e, err = readNode(r, arg); err != nil {
+				return nil, nil, inPath(err, indexStep(i))
+			}
+			n.Elems = append(n.Elems, e)
+		}
+	case typeHash, typeHashDef:
+		register()
+		var size int
+		if size, err = readCollectionLen(r, arg); err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < size; i++ {
+			var p NodePair
+			if p.Key, err = readNode(r, arg); err != nil {
+				return nil, nil, inPath(err, hashKeyStep(i))
+			}
+			if p.Value, err = readNode(r, arg); err != nil {
+				return nil, nil, inPath(err, nodeKeyStep(p.Key))
+			}
+			n.Pairs = append(n.Pairs, p)
+		}
+		if t == typeHashDef {
+			n.Default, err = readNode(r, arg)
+		}
+	case typeObject, typeStruct:
+		register()
+		if n.Class, err = readName(r, arg); err != nil {
+			return nil, nil, err
+		}
+		n.Ivars, err = readNodeIvars(r, arg)
+	case typeData, typeUsrmarshal:
+		register()
+		if n.Class, err = readName(r, arg); err == nil {
+			n.Data, err = readNode(r, arg)
+		}
+
+	case typeExtended, typeUclass:
+		if n.Class, err = readName(r, arg); err != nil {
+			return nil, nil, err
+		}
+		var inner *Node
+		var b byte
+		if b, err = readByte(r, arg); err != nil {
+			return nil, nil, err
+		}
+		if b == typeIvar {
+			n.Data, err = readWrappedNode(r, arg)
+		} else {
+			n.Data, inner, err = readNodeBody(r, arg, b)
+		}
+		return n, inner, err
+
+	default:
+		return nil, nil, &UnsupportedTypeError{TypeByte: t, Offset: arg.offset - 1}
+	}
+
+	return n, n, err
+}
+
+// readNodeIvars reads a count and as many pairs of names and nodes.
+func readNodeIvars(r byteReader, arg *LoadArg) ([]NodeIvar, error) {
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var ivars []NodeIvar
+	for i := 0; i < size; i++ {
+		var iv NodeIvar
+		if iv.Name, err = readName(r, arg); err != nil {
+			return nil, err
+		}
+		if iv.Value, err = readNode(r, arg); err != nil {
+			return nil, inPath(err, ivarStep(iv.Name))
+		}
+		ivars = append(ivars, iv)
+	}
+
+	return ivars, nil
+}
+
+// dumpNode writes n back as the record it was read from, or as a link to it
+// if it was written already.
+func dumpNode(w *bufio.Writer, arg *dumpArg, n *Node) error {
+	if n == nil {
+		return w.WriteByte(typeNil)
+	}
+	if i, ok := arg.links[nodeKey(n)]; ok {
+		if err := w.WriteByte(typeObjlink); err != nil {
+			return err
+		}
+		return writeFixnum(w, i)
+	}
+
+	// Symbols write their ivars themselves, since a link to one has none.
+	wrapped := len(n.Ivars) > 0 && n.Type != typeObject && n.Type != typeStruct &&
+		n.Type != typeSymbol
+	if wrapped {
+		if err := w.WriteByte(typeIvar); err != nil {
+			return err
+		}
+	}
+	inner, err := dumpNodeBody(w, arg, n)
+	if err != nil {
+		return err
+	}
+	if wrapped {
+		if err := dumpNodeIvars(w, arg, n.Ivars); err != nil {
+			return err
+		}
+	}
+	if inner != nil && registersLate(inner.Type) {
+		arg.links[nodeKey(inner)] = arg.objects
+		arg.objects++
+	}
+
+	return nil
+}
+
+func nodeKey(n *Node) objKey {
+	return objKey{reflect.TypeOf(n), reflect.ValueOf(n).Pointer(), 0}
+}
+
+// dumpNodeBody writes n but its ivars, and returns the node that takes a slot,
+// like readNodeBody.
+func dumpNodeBody(w *bufio.Writer, arg *dumpArg, n *Node) (*Node, error) {
+	register := func() {
+		arg.links[nodeKey(n)] = arg.objects
+		arg.objects++
+	}
+
+	switch n.Type {
+	case typeNil, typeTrue, typeFalse:
+		return nil, w.WriteByte(n.Type)
+	case typeFixnum:
+		if err := w.WriteByte(typeFixnum); err != nil {
+			return nil, err
+		}
+		return nil, writeFixnum(w, n.Int)
+	case typeSymbol:
+		return nil, dumpNodeSymbol(w, arg, n)
+	case typeBignum:
+		if n.Big == nil {
+			return nil, fmt.Errorf("bignum node without a value")
+		}
+		arg.links[nodeKey(n)] = arg.objects
+		return n, dumpBignum(w, arg, n.Big)
+	}
+
+	if err := w.WriteByte(n.Type); err != nil {
+		return nil, err
+	}
+
+	switch n.Type {
+	case typeFloat, typeString, typeClass, typeModule, typeModuleOld:
+		register()
+		return n, dumpBytes(w, string(n.Bytes))
+	case typeRegexp:
+		register()
+		if err := dumpBytes(w, string(n.Bytes)); err != nil {
+			return nil, err
+		}
+		return n, w.WriteByte(n.Options)
+	case typeUserdef:
+		if err := dumpSymbol(w, arg, n.Class); err != nil {
+			return nil, err
+		}
+		return n, dumpBytes(w, string(n.Bytes))
+
+	case typeArray:
+		register()
+		if err := writeFixnum(w, len(n.Elems)); err != nil {
+			return nil, err
+		}
+		for _, e := range n.Elems {
+			if err := dumpNode(w, arg, e); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	case typeHash, typeHashDef:
+		register()
+		if err := writeFixnum(w, len(n.Pairs)); err != nil {
+			return nil, err
+		}
+		for _, p := range n.Pairs {
+			if err := dumpNode(w, arg, p.Key); err != nil {
+				return nil, err
+			}
+			if err := dumpNode(w, arg, p.Value); err != nil {
+				return nil, err
+			}
+		}
+		if n.Type == typeHashDef {
+			return n, dumpNode(w, arg, n.Default)
+		}
+		return n, nil
+	case typeObject, typeStruct:
+		register()
+		if err := dumpSymbol(w, arg, n.Class); err != nil {
+			return nil, err
+		}
+		return n, dumpNodeIvars(w, arg, n.Ivars)
+	case typeData, typeUsrmarshal:
+		register()
+		if err := dumpSymbol(w, arg, n.Class); err != nil {
+			return nil, err
+		}
+		return n, dumpNode(w, arg, n.Data)
+
+	case typeExtended, typeUclass:
+		if err := dumpSymbol(w, arg, n.Class); err != nil {
+			return nil, err
+		}
+		if n.Data == nil {
+			return nil, fmt.Errorf("%q node without a value", n.Type)
+		}
+		if len(n.Data.Ivars) > 0 && n.Data.Type != typeObject && n.Data.Type != typeStruct {
+			return nil, dumpNode(w, arg, n.Data)
+		}
+		return dumpNodeBody(w, arg, n.Data)
+	}
+
+	return nil, fmt.Errorf("unsupported node type %q", n.Type)
+}
+
+// dumpNodeSymbol writes a symbol with the ivars it was read with, rather than
+// with the encoding dumpSymbol gives it, or as a link to it.
+func dumpNodeSymbol(w *bufio.Writer, arg *dumpArg, n *Node) error {
+	name := string(n.Bytes)
+	if i, ok := arg.symbols[name]; ok {
+		if err := w.WriteByte(typeSymlink); err != nil {
+			return err
+		}
+		return writeFixnum(w, i)
+	}
+	arg.symbols[name] = len(arg.symbols)
+
+	if len(n.Ivars) > 0 {
+		if err := w.WriteByte(typeIvar); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteByte(typeSymbol); err != nil {
+		return err
+	}
+	if err := dumpBytes(w, name); err != nil {
+		return err
+	}
+	if len(n.Ivars) > 0 {
+		return dumpNodeIvars(w, arg, n.Ivars)
+	}
+
+	return nil
+}
+
+func dumpNodeIvars(w *bufio.Writer, arg *dumpArg, ivars []NodeIvar) error {
+	if err := writeFixnum(w, len(ivars)); err != nil {
+		return err
+	}
+	for _, iv := range ivars {
+		if err := dumpSymbol(w, arg, iv.Name); err != nil {
+			return err
+		}
+		if err := dumpNode(w, arg, iv.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func loadDocument(t *testing.T, stream []byte) *Node {
+	t.Helper()
+
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), &LoadArg{Document: true})
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	n, ok := data.(*Node)
+	if !ok {
+		t.Fatalf("got %T, want *Node", data)
+	}
+
+	return n
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+	}{
+		{
+			// s = "x"; User.new with @name = s, @age = 30, @alias = s
+			"Object with a shared ivar",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x09, 0x55, 0x73, 0x65,
+				0x72, 0x08, 0x3a, 0x0a, 0x40, 0x6e, 0x61, 0x6d,
+				0x65, 0x49, 0x22, 0x06, 0x78, 0x06, 0x3a, 0x06,
+				0x45, 0x54, 0x3a, 0x09, 0x40, 0x61, 0x67, 0x65,
+				0x69, 0x23, 0x3a, 0x0b, 0x40, 0x61, 0x6c, 0x69,
+				0x61, 0x73, 0x40, 0x06,
+			},
+		},
+		{
+			// h = Hash.new(0); h["b"] = 1; h[:a] = 2; h
+			"Hash with a default",
+			[]byte{
+				0x04, 0x08, 0x7d, 0x07, 0x49, 0x22, 0x06, 0x62,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x69, 0x06, 0x3a,
+				0x06, 0x61, 0x69, 0x07, 0x69, 0x00,
+			},
+		},
+		{
+			// ["a", "b"].map { |s| s.force_encoding("Shift_JIS") }
+			"Encoding names",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63, 0x6f, 0x64,
+				0x69, 0x6e, 0x67, 0x22, 0x0e, 0x53, 0x68, 0x69,
+				0x66, 0x74, 0x5f, 0x4a, 0x49, 0x53, 0x49, 0x22,
+				0x06, 0x62, 0x06, 0x3b, 0x00, 0x40, 0x07,
+			},
+		},
+		{
+			// [MyStr.new("ab"), Obj.new.extend(Mod)]
+			"User class and extended object",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x43, 0x3a, 0x0a,
+				0x4d, 0x79, 0x53, 0x74, 0x72, 0x22, 0x07, 0x61,
+				0x62, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x65, 0x3a,
+				0x08, 0x4d, 0x6f, 0x64, 0x6f, 0x3a, 0x08, 0x4f,
+				0x62, 0x6a, 0x00,
+			},
+		},
+		{
+			// m = Money.new("1") with @note = "n"; [m, m]
+			"User type that takes its slot after its ivars",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x75, 0x3a, 0x0a,
+				0x4d, 0x6f, 0x6e, 0x65, 0x79, 0x06, 0x31, 0x06,
+				0x3a, 0x0a, 0x40, 0x6e, 0x6f, 0x74, 0x65, 0x49,
+				0x22, 0x06, 0x6e, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x40, 0x07,
+			},
+		},
+		{
+			// r = /a/e; [r, r]
+			"Regexp that takes its slot before its ivars",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x2f, 0x06, 0x61,
+				0x10, 0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63, 0x6f,
+				0x64, 0x69, 0x6e, 0x67, 0x22, 0x0b, 0x45, 0x55,
+				0x43, 0x2d, 0x4a, 0x50, 0x40, 0x06,
+			},
+		},
+		{
+			// [:"\xff".b, "\x82\xa0".force_encoding("Shift_JIS").to_sym]
+			"Symbols with their encodings",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0xff, 0x49,
+				0x3a, 0x07, 0x82, 0xa0, 0x06, 0x3a, 0x0d, 0x65,
+				0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x22,
+				0x0e, 0x53, 0x68, 0x69, 0x66, 0x74, 0x5f, 0x4a,
+				0x49, 0x53,
+			},
+		},
+		{
+			// [nil, true, false, -1, -(2**40), 1.5, /a/im, Point.new(1),
+			//  :x, :Point, Object, Kernel, Rational(1, 2), :é, :Rational, []]
+			"Everything else",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x15, 0x30, 0x54, 0x46, 0x69,
+				0xfa, 0x6c, 0x2d, 0x08, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x01, 0x66, 0x08, 0x31, 0x2e, 0x35, 0x49,
+				0x2f, 0x06, 0x61, 0x05, 0x06, 0x3a, 0x06, 0x45,
+				0x46, 0x53, 0x3a, 0x0a, 0x50, 0x6f, 0x69, 0x6e,
+				0x74, 0x06, 0x3a, 0x06, 0x78, 0x69, 0x06, 0x3b,
+				0x07, 0x3b, 0x06, 0x63, 0x0b, 0x4f, 0x62, 0x6a,
+				0x65, 0x63, 0x74, 0x6d, 0x0b, 0x4b, 0x65, 0x72,
+				0x6e, 0x65, 0x6c, 0x55, 0x3a, 0x0d, 0x52, 0x61,
+				0x74, 0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07,
+				0x69, 0x06, 0x69, 0x07, 0x49, 0x3a, 0x07, 0xc3,
+				0xa9, 0x06, 0x3b, 0x00, 0x54, 0x3b, 0x08, 0x5b,
+				0x00,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			n := loadDocument(t, c.stream)
+
+			var buf bytes.Buffer
+			if err := Dump(&buf, n); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+func TestDocumentRegexpLink(t *testing.T) {
+	// r = /a/e; [r, r]
+	n := loadDocument(t, []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x49, 0x2f, 0x06, 0x61,
+		0x10, 0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63, 0x6f,
+		0x64, 0x69, 0x6e, 0x67, 0x22, 0x0b, 0x45, 0x55,
+		0x43, 0x2d, 0x4a, 0x50, 0x40, 0x06,
+	})
+	if len(n.Elems) != 2 || n.Elems[1] != n.Elems[0] {
+		t.Errorf("got %+v, want the regexp twice", n.Elems)
+	}
+}
+
+func TestDocumentEdit(t *testing.T) {
+	// s = "x"; User.new with @name = s, @age = 30, @alias = s
+	n := loadDocument(t, []byte{
+		0x04, 0x08, 0x6f, 0x3a, 0x09, 0x55, 0x73, 0x65,
+		0x72, 0x08, 0x3a, 0x0a, 0x40, 0x6e, 0x61, 0x6d,
+		0x65, 0x49, 0x22, 0x06, 0x78, 0x06, 0x3a, 0x06,
+		0x45, 0x54, 0x3a, 0x09, 0x40, 0x61, 0x67, 0x65,
+		0x69, 0x23, 0x3a, 0x0b, 0x40, 0x61, 0x6c, 0x69,
+		0x61, 0x73, 0x40, 0x06,
+	})
+	if n.Ivars[0].Value != n.Ivars[2].Value {
+		t.Fatalf("the shared string was read as two nodes")
+	}
+	n.Ivars[0].Value.Bytes = []byte("Ann")
+	n.Ivars[1].Value.Int = 31
+
+	// s = "Ann"; User.new with @name = s, @age = 31, @alias = s
+	want := []byte{
+		0x04, 0x08, 0x6f, 0x3a, 0x09, 0x55, 0x73, 0x65,
+		0x72, 0x08, 0x3a, 0x0a, 0x40, 0x6e, 0x61, 0x6d,
+		0x65, 0x49, 0x22, 0x08, 0x41, 0x6e, 0x6e, 0x06,
+		0x3a, 0x06, 0x45, 0x54, 0x3a, 0x09, 0x40, 0x61,
+		0x67, 0x65, 0x69, 0x24, 0x3a, 0x0b, 0x40, 0x61,
+		0x6c, 0x69, 0x61, 0x73, 0x40, 0x06,
+	}
+	var buf bytes.Buffer
+	if err := Dump(&buf, n); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+	}{
+		{"Unknown type", []byte{0x04, 0x08, 0x21}},
+		{"Link out of range", []byte{0x04, 0x08, 0x5b, 0x06, 0x40, 0x07}},
+		{"Truncated", []byte{0x04, 0x08, 0x5b, 0x07, 0x30}},
+	}
+
+	for _, c := range cases {
+		arg := &LoadArg{Document: true}
+		if _, err := LoadWith(bufio.NewReader(bytes.NewReader(c.stream)), arg); err == nil {
+			t.Errorf("%s: expected an error", c.desc)
+		}
+	}
+}
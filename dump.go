@@ -0,0 +1,1016 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fixnums take at most four bytes in the stream, but Ruby dumps anything that
+// doesn't fit in 31 bits as a bignum.
+const (
+	maxFixnum = 1<<30 - 1
+	minFixnum = -1 << 30
+)
+
+// Dump writes v to w in the Marshal format, header included, so that Ruby's
+// Marshal.load can read it. It handles nil, booleans, integers, *big.Int,
+// Number, floats, *big.Rat and complex numbers, which become Rational and
+// Complex, strings, RString, Symbol, RObject, RStruct, UserDef, UserMarshal,
+// time.Time, OrderedHash, *regexp.Regexp, RRegexp, slices, arrays, maps and
+// structs, as well as types that implement Marshaler, trees of *Node and
+// RawValue. Integers that don't fit in a fixnum are dumped as bignums.
+// Strings are dumped as UTF-8, unless they aren't valid UTF-8, and byte
+// slices as ASCII-8BIT.
+//
+// A struct is written as an object of the class its RubyClassName method
+// returns, or else the one in the tag of its blank field, like
+// `_ struct{} rbmarshal:"User"`, or else the name of its type. Its exported
+// fields become ivars named after their tag, like `rbmarshal:"@first_name"`,
+// or else after the field, in snake case. Fields tagged "-" are left out, and
+// the fields of embedded structs are written as if they were fields of the
+// outer one.
+//
+// A map, slice or pointer that is reached more than once is written the first
+// time and linked to after that, the way Ruby writes an object it has seen,
+// so values with cycles can be dumped too.
+//
+// The pairs of a map are written in the random order Go iterates over them,
+// unless Encoder.SortKeys is set. Those of an OrderedHash are written in the
+// order they are in.
+func Dump(w io.Writer, v interface{}) error {
+	return NewEncoder(w).Encode(v)
+}
+
+// dumpArg is the state of a single dump.
+type dumpArg struct {
+	// The index of every symbol written so far, for symlinks.
+	symbols map[string]int
+
+	// How many objects have been written so far. Like in Ruby, floats,
+	// bignums and strings take a slot, along with containers, but only
+	// values with an identity, like maps and slices, can be linked to.
+	objects int
+	links   map[objKey]int
+
+	// The slot of the name of every encoding, other than UTF-8 and
+	// US-ASCII, written so far. Ruby writes every name once per dump.
+	encodings map[string]int
+
+	// Whether the output must be the one of MRI, byte for byte, and whether
+	// map keys are sorted. See Encoder.Exact and Encoder.SortKeys.
+	exact    bool
+	sortKeys bool
+
+	// The slot of every float written so far that Ruby keeps as a flonum,
+	// in exact mode. Flonums are immediates, so Ruby links to the first.
+	flonums map[uint64]int
+
+	// The last user type written, by how many objects had been written
+	// when it started, and the slot it took, which comes after its ivars.
+	lastUserDef struct{ start, slot int }
+}
+
+// objKey identifies a Go value that Ruby sees as one object: a map, a slice
+// with the same backing array and length, or what a pointer points to.
+type objKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+func resetDumpArg(arg *dumpArg) {
+	if arg.symbols == nil {
+		arg.symbols = make(map[string]int)
+		arg.links = make(map[objKey]int)
+		arg.encodings = make(map[string]int)
+		arg.flonums = make(map[uint64]int)
+	}
+	for k := range arg.symbols {
+		delete(arg.symbols, k)
+	}
+	for k := range arg.links {
+		delete(arg.links, k)
+	}
+	for k := range arg.encodings {
+		delete(arg.encodings, k)
+	}
+	for k := range arg.flonums {
+		delete(arg.flonums, k)
+	}
+	arg.objects = 0
+	arg.lastUserDef.start, arg.lastUserDef.slot = 0, 0
+}
+
+// dumpLink writes a link if the value behind key has been written already.
+// Otherwise it makes the next object written the one key stands for.
+func dumpLink(w *bufio.Writer, arg *dumpArg, key objKey) (bool, error) {
+	if i, ok := arg.links[key]; ok {
+		if err := w.WriteByte(typeObjlink); err != nil {
+			return true, err
+		}
+		return true, writeFixnum(w, i)
+	}
+	arg.links[key] = arg.objects
+
+	return false, nil
+}
+
+// dumpContentsLink is dumpLink for v, a value such as an RObject whose
+// identity is the one of its contents: a map or a pointer, or a slice that
+// isn't empty. Other contents can't be shared, so v is never linked to.
+func dumpContentsLink(w *bufio.Writer, arg *dumpArg, v, contents interface{}) (bool, error) {
+	rv := reflect.ValueOf(contents)
+	key := objKey{reflect.TypeOf(v), 0, 0}
+	switch rv.Kind() {
+	case reflect.Map, reflect.Ptr:
+		if rv.IsNil() {
+			return false, nil
+		}
+		key.ptr = rv.Pointer()
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return false, nil
+		}
+		key.ptr, key.len = rv.Pointer(), rv.Len()
+	default:
+		return false, nil
+	}
+
+	return dumpLink(w, arg, key)
+}
+
+func dump(w *bufio.Writer, arg *dumpArg, v interface{}) error {
+	if m, ok := v.(Marshaler); ok {
+		sub, err := m.MarshalRuby()
+		if err != nil {
+			return err
+		}
+		return dump(w, arg, sub)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return w.WriteByte(typeNil)
+	case bool:
+		if v {
+			return w.WriteByte(typeTrue)
+		}
+		return w.WriteByte(typeFalse)
+	case string:
+		return dumpString(w, arg, v, stringEncoding(v), nil)
+	case []byte:
+		return dumpString(w, arg, string(v), "", nil)
+	case RString:
+		return dumpString(w, arg, v.Value, v.Encoding, v.Ivars)
+	case Symbol:
+		return dumpSymbol(w, arg, string(v))
+	case RObject:
+		if linked, err := dumpContentsLink(w, arg, v, v.Ivars); linked || err != nil {
+			return err
+		}
+		arg.objects++
+		return dumpObject(w, arg, v.Class, v.Ivars)
+	case time.Time:
+		return dumpTime(w, arg, v)
+	case OrderedHash:
+		return dumpOrderedHash(w, arg, v)
+	case RStruct:
+		if linked, err := dumpContentsLink(w, arg, v, v.Members); linked || err != nil {
+			return err
+		}
+		return dumpRStruct(w, arg, v)
+	case *Node:
+		return dumpNode(w, arg, v)
+	case RawValue:
+		if v == nil {
+			return w.WriteByte(typeNil)
+		}
+		n, err := LoadBytesWith(v, &LoadArg{Document: true})
+		if err != nil {
+			return err
+		}
+		return dumpNode(w, arg, n.(*Node))
+	case *regexp.Regexp:
+		if v == nil {
+			return w.WriteByte(typeNil)
+		}
+		if linked, err := dumpLink(w, arg, objKey{reflect.TypeOf(v), reflect.ValueOf(v).Pointer(), 0}); linked || err != nil {
+			return err
+		}
+		src, options := untranslateRegexp(v.String())
+		return dumpRegexp(w, arg, src, options)
+	case RRegexp:
+		return dumpRegexp(w, arg, v.Source, v.Options)
+	case UserDef:
+		if err := checkIvarOrder(arg, v.Ivars); err != nil {
+			return err
+		}
+		var ivars []dumpIvar
+		for _, name := range sortedNames(v.Ivars) {
+			ivars = append(ivars, dumpIvar{name, v.Ivars[name]})
+		}
+		return dumpUserDef(w, arg, v.Class, v.Data, ivars)
+	case UserMarshal:
+		if linked, err := dumpContentsLink(w, arg, v, v.Data); linked || err != nil {
+			return err
+		}
+		arg.objects++
+		if err := w.WriteByte(typeUsrmarshal); err != nil {
+			return err
+		}
+		if err := dumpSymbol(w, arg, v.Class); err != nil {
+			return err
+		}
+		return dump(w, arg, v.Data)
+	case float64:
+		return dumpFloatObject(w, arg, v)
+	case float32:
+		return dumpFloatObject(w, arg, float64(v))
+	case *big.Rat:
+		if v == nil {
+			return w.WriteByte(typeNil)
+		}
+		return dump(w, arg, rationalUserMarshal(v))
+	case complex128:
+		return dump(w, arg, complexUserMarshal(v))
+	case complex64:
+		return dump(w, arg, complexUserMarshal(complex128(v)))
+	case Number:
+		n, err := v.BigInt()
+		if err != nil {
+			return err
+		}
+		return dump(w, arg, n)
+	case *big.Int:
+		if v == nil {
+			return w.WriteByte(typeNil)
+		}
+		if v.IsInt64() {
+			return dumpInt(w, arg, v.Int64())
+		}
+		key := objKey{reflect.TypeOf(v), reflect.ValueOf(v).Pointer(), 0}
+		linked, err := dumpLink(w, arg, key)
+		if linked || err != nil {
+			return err
+		}
+		return dumpBignum(w, arg, v)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return dumpInt(w, arg, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > maxFixnum {
+			return dumpBignum(w, arg, new(big.Int).SetUint64(rv.Uint()))
+		}
+		return dumpInt(w, arg, int64(rv.Uint()))
+	case reflect.String:
+		return dumpString(w, arg, rv.String(), stringEncoding(rv.String()), nil)
+	case reflect.Slice, reflect.Array:
+		return dumpArray(w, arg, rv)
+	case reflect.Map:
+		return dumpMap(w, arg, rv)
+	case reflect.Ptr:
+		return dumpPtr(w, arg, rv)
+	case reflect.Struct:
+		arg.objects++
+		return dumpStruct(w, arg, rv, v)
+	}
+
+	return fmt.Errorf("cannot dump %T", v)
+}
+
+func dumpInt(w *bufio.Writer, arg *dumpArg, n int64) error {
+	if n < minFixnum || n > maxFixnum {
+		return dumpBignum(w, arg, big.NewInt(n))
+	}
+	if err := w.WriteByte(typeFixnum); err != nil {
+		return err
+	}
+
+	return writeFixnum(w, int(n))
+}
+
+// writeFixnum writes n the way w_long in marshal.c does: small numbers fit in
+// the type byte with an offset, larger ones take as few little-endian bytes as
+// possible after a byte with their count, negated for negative numbers.
+func writeFixnum(w *bufio.Writer, n int) error {
+	switch {
+	case n == 0:
+		return w.WriteByte(0)
+	case 0 < n && n < 123:
+		return w.WriteByte(byte(n + fixnumOffset))
+	case -124 < n && n < 0:
+		return w.WriteByte(byte(n - fixnumOffset))
+	}
+
+	var buf [5]byte
+	x := n
+	for i := 1; i < len(buf); i++ {
+		buf[i] = byte(x)
+		x >>= 8
+		if x == 0 {
+			buf[0] = byte(i)
+			_, err := w.Write(buf[:i+1])
+			return err
+		}
+		if x == -1 {
+			buf[0] = byte(-i)
+			_, err := w.Write(buf[:i+1])
+			return err
+		}
+	}
+
+	return fmt.Errorf("cannot dump %d, out of fixnum range", n)
+}
+
+// dumpBignum writes n as a sign and the fewest 16 bit words that hold its
+// magnitude, least significant byte first.
+func dumpBignum(w *bufio.Writer, arg *dumpArg, n *big.Int) error {
+	arg.objects++
+
+	sign := byte(bignumPos)
+	if n.Sign() < 0 {
+		sign = bignumNeg
+	}
+	b := new(big.Int).Abs(n).Bytes()
+	if len(b)%2 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	if _, err := w.Write([]byte{typeBignum, sign}); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, len(b)/2); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+
+	return err
+}
+
+func dumpBytes(w *bufio.Writer, s string) error {
+	if err := writeFixnum(w, len(s)); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+
+	return err
+}
+
+func stringEncoding(s string) string {
+	if utf8.ValidString(s) {
+		return "UTF-8"
+	}
+
+	return ""
+}
+
+// dumpString writes s in the encoding enc, which is the name of a Ruby
+// encoding. An empty name means ASCII-8BIT. The encoding is written as an
+// ivar, before any other ivars s has.
+func dumpString(w *bufio.Writer, arg *dumpArg, s string, enc string, ivars map[string]interface{}) error {
+	arg.objects++
+
+	switch enc {
+	case "ASCII-8BIT", "BINARY":
+		enc = ""
+	}
+
+	n := len(ivars)
+	if enc != "" {
+		n++
+	}
+	if n > 0 {
+		if err := w.WriteByte(typeIvar); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteByte(typeString); err != nil {
+		return err
+	}
+	if err := dumpBytes(w, s); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if err := writeFixnum(w, n); err != nil {
+		return err
+	}
+	if err := dumpEncoding(w, arg, enc); err != nil {
+		return err
+	}
+
+	return dumpIvars(w, arg, ivars)
+}
+
+// dumpEncoding writes the encoding ivar of a string, which for UTF-8 and
+// US-ASCII is a short E, true or false.
+func dumpEncoding(w *bufio.Writer, arg *dumpArg, enc string) error {
+	var err error
+	switch enc {
+	case "":
+		return nil
+	case "UTF-8":
+		if err = dumpSymbol(w, arg, "E"); err == nil {
+			err = w.WriteByte(typeTrue)
+		}
+	case "US-ASCII":
+		if err = dumpSymbol(w, arg, "E"); err == nil {
+			err = w.WriteByte(typeFalse)
+		}
+	default:
+		if err = dumpSymbol(w, arg, "encoding"); err != nil {
+			return err
+		}
+		if i, ok := arg.encodings[enc]; ok {
+			if err = w.WriteByte(typeObjlink); err == nil {
+				err = writeFixnum(w, i)
+			}
+			return err
+		}
+		arg.encodings[enc] = arg.objects
+		err = dumpString(w, arg, enc, "", nil)
+	}
+
+	return err
+}
+
+// dumpIvars writes the pairs of ivars, sorted by name so that the output
+// doesn't change from one dump to another.
+func dumpIvars(w *bufio.Writer, arg *dumpArg, ivars map[string]interface{}) error {
+	if err := checkIvarOrder(arg, ivars); err != nil {
+		return err
+	}
+	for _, name := range sortedNames(ivars) {
+		if err := dumpSymbol(w, arg, name); err != nil {
+			return err
+		}
+		if err := dump(w, arg, ivars[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkIvarOrder fails in exact mode if there is more than one ivar, since
+// the order Ruby would write them in, which is the one they were set in, is
+// lost in a map.
+func checkIvarOrder(arg *dumpArg, ivars map[string]interface{}) error {
+	if arg.exact && len(ivars) > 1 {
+		return fmt.Errorf("cannot dump %d ivars exactly: their order is unknown", len(ivars))
+	}
+
+	return nil
+}
+
+func sortedNames(ivars map[string]interface{}) []string {
+	names := make([]string, 0, len(ivars))
+	for name := range ivars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// dumpSymbol writes name as a symbol the first time, and as a symlink to it
+// after that. A name that isn't US-ASCII is wrapped with its encoding, which
+// comes after the symbol in the symbol table.
+func dumpSymbol(w *bufio.Writer, arg *dumpArg, name string) error {
+	if i, ok := arg.symbols[name]; ok {
+		if err := w.WriteByte(typeSymlink); err != nil {
+			return err
+		}
+		return writeFixnum(w, i)
+	}
+	arg.symbols[name] = len(arg.symbols)
+
+	ascii := true
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+
+	if !ascii {
+		if err := w.WriteByte(typeIvar); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteByte(typeSymbol); err != nil {
+		return err
+	}
+	if err := dumpBytes(w, name); err != nil {
+		return err
+	}
+	if ascii {
+		return nil
+	}
+
+	if err := writeFixnum(w, 1); err != nil {
+		return err
+	}
+
+	return dumpEncoding(w, arg, "UTF-8")
+}
+
+func dumpArray(w *bufio.Writer, arg *dumpArg, rv reflect.Value) error {
+	if rv.Kind() == reflect.Slice {
+		if rv.IsNil() {
+			return w.WriteByte(typeNil)
+		}
+		// Empty slices may all share the same pointer.
+		if rv.Len() > 0 {
+			key := objKey{rv.Type(), rv.Pointer(), rv.Len()}
+			if linked, err := dumpLink(w, arg, key); linked || err != nil {
+				return err
+			}
+		}
+	}
+	arg.objects++
+
+	if err := w.WriteByte(typeArray); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, rv.Len()); err != nil {
+		return err
+	}
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := dump(w, arg, rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dumpMap(w *bufio.Writer, arg *dumpArg, rv reflect.Value) error {
+	if rv.IsNil() {
+		return w.WriteByte(typeNil)
+	}
+	if linked, err := dumpLink(w, arg, objKey{rv.Type(), rv.Pointer(), 0}); linked || err != nil {
+		return err
+	}
+	if arg.exact && rv.Len() > 1 {
+		return fmt.Errorf("cannot dump %v exactly: its order is unknown", rv.Type())
+	}
+	arg.objects++
+
+	if err := w.WriteByte(typeHash); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, rv.Len()); err != nil {
+		return err
+	}
+
+	if arg.sortKeys {
+		for _, k := range sortedKeys(rv) {
+			if err := dump(w, arg, k.Interface()); err != nil {
+				return err
+			}
+			if err := dump(w, arg, rv.MapIndex(k).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		if err := dump(w, arg, iter.Key().Interface()); err != nil {
+			return err
+		}
+		if err := dump(w, arg, iter.Value().Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a map in a stable order: numbers by value,
+// strings and symbols by their bytes, and keys of different types by the
+// name of their type.
+func sortedKeys(rv reflect.Value) []reflect.Value {
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keyLess(keys[i], keys[j])
+	})
+
+	return keys
+}
+
+func keyLess(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	// A nil key comes first.
+	if !a.IsValid() || !b.IsValid() {
+		return !a.IsValid() && b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return a.Type().String() < b.Type().String()
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+// dumpOrderedHash writes the pairs of h in the order they are in.
+func dumpOrderedHash(w *bufio.Writer, arg *dumpArg, h OrderedHash) error {
+	arg.objects++
+	if err := w.WriteByte(typeHash); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, len(h.Pairs)); err != nil {
+		return err
+	}
+
+	for _, p := range h.Pairs {
+		if err := dump(w, arg, p.Key); err != nil {
+			return err
+		}
+		if err := dump(w, arg, p.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dumpPtr writes what rv points to, or a link to it if it has been written
+// already, which makes cycles through pointers safe.
+func dumpPtr(w *bufio.Writer, arg *dumpArg, rv reflect.Value) error {
+	if rv.IsNil() {
+		return w.WriteByte(typeNil)
+	}
+
+	key := objKey{rv.Type(), rv.Pointer(), 0}
+	linked, err := dumpLink(w, arg, key)
+	if linked || err != nil {
+		return err
+	}
+
+	n := arg.objects
+	if _, ok := rv.Interface().(RubyClassNamer); ok && rv.Elem().Kind() == reflect.Struct {
+		// RubyClassName may have a pointer receiver.
+		arg.objects++
+		return dumpStruct(w, arg, rv.Elem(), rv.Interface())
+	}
+	err = dump(w, arg, rv.Elem().Interface())
+	switch {
+	case arg.objects == n:
+		// Values that take no slot, like fixnums, can't be linked to.
+		delete(arg.links, key)
+	case arg.lastUserDef.start == n:
+		// The value is a user type, like a Time, which takes its slot
+		// after its ivars. A user type inside another value starts
+		// after n, and one inside its ivars is written before it.
+		arg.links[key] = arg.lastUserDef.slot
+	}
+
+	return err
+}
+
+// A RubyClassNamer tells the class of the object a Go struct is dumped as.
+type RubyClassNamer interface {
+	RubyClassName() string
+}
+
+// dumpStruct writes a struct as an object. v is the struct, or the pointer
+// to it, on which RubyClassName is looked up.
+func dumpStruct(w *bufio.Writer, arg *dumpArg, rv reflect.Value, v interface{}) error {
+	var class string
+	if n, ok := v.(RubyClassNamer); ok {
+		class = n.RubyClassName()
+	} else if class = structClass(rv.Type()); class == "" {
+		class = rv.Type().Name()
+	}
+	if class == "" {
+		return fmt.Errorf("cannot dump %v without a class name", rv.Type())
+	}
+
+	var names []string
+	var values []reflect.Value
+	collectIvars(rv, &names, &values)
+
+	if err := w.WriteByte(typeObject); err != nil {
+		return err
+	}
+	if err := dumpSymbol(w, arg, class); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, len(names)); err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := dumpSymbol(w, arg, name); err != nil {
+			return err
+		}
+		if err := dump(w, arg, values[i].Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// structClass returns the class name from the tag of the blank field of t, if
+// there is one.
+func structClass(t reflect.Type) string {
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Name == "_" {
+			if class := f.Tag.Get("rbmarshal"); class != "" {
+				return class
+			}
+		}
+	}
+
+	return ""
+}
+
+func collectIvars(rv reflect.Value, names *[]string, values *[]reflect.Value) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("rbmarshal")
+		if tag == "-" || f.Name == "_" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			collectIvars(rv.Field(i), names, values)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = "@" + snakeCase(f.Name)
+		}
+		*names = append(*names, name)
+		*values = append(*values, rv.Field(i))
+	}
+}
+
+// snakeCase turns a Go name, like UserID, into a Ruby one, like user_id.
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && next) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+
+	return sb.String()
+}
+
+// A dumpIvar is an ivar of a value that is written with its ivars in a set
+// order.
+type dumpIvar struct {
+	name  string
+	value interface{}
+}
+
+// dumpUserDef writes an object of class with the data its _dump method would
+// return, wrapped with ivars if there are any. Like in Ruby, the object takes
+// its slot after the ivars.
+func dumpUserDef(w *bufio.Writer, arg *dumpArg, class string, data []byte, ivars []dumpIvar) error {
+	start := arg.objects
+	if len(ivars) > 0 {
+		if err := w.WriteByte(typeIvar); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteByte(typeUserdef); err != nil {
+		return err
+	}
+	if err := dumpSymbol(w, arg, class); err != nil {
+		return err
+	}
+	if err := dumpBytes(w, string(data)); err != nil {
+		return err
+	}
+
+	if len(ivars) > 0 {
+		if err := writeFixnum(w, len(ivars)); err != nil {
+			return err
+		}
+		for _, iv := range ivars {
+			if err := dumpSymbol(w, arg, iv.name); err != nil {
+				return err
+			}
+			if err := dump(w, arg, iv.value); err != nil {
+				return err
+			}
+		}
+	}
+	arg.lastUserDef.start, arg.lastUserDef.slot = start, arg.objects
+	arg.objects++
+
+	return nil
+}
+
+// dumpObject writes an object of class with ivars, sorted by name.
+func dumpObject(w *bufio.Writer, arg *dumpArg, class string, ivars map[string]interface{}) error {
+	if err := w.WriteByte(typeObject); err != nil {
+		return err
+	}
+	if err := dumpSymbol(w, arg, class); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, len(ivars)); err != nil {
+		return err
+	}
+
+	return dumpIvars(w, arg, ivars)
+}
+
+// dumpRegexp writes a regexp with its encoding, which is UTF-8 if the source
+// isn't ASCII and US-ASCII otherwise, like in Ruby.
+func dumpRegexp(w *bufio.Writer, arg *dumpArg, src string, options byte) error {
+	arg.objects++
+
+	enc := "US-ASCII"
+	for i := 0; i < len(src); i++ {
+		if src[i] >= utf8.RuneSelf {
+			enc = "UTF-8"
+			break
+		}
+	}
+
+	if err := w.WriteByte(typeIvar); err != nil {
+		return err
+	}
+	if err := w.WriteByte(typeRegexp); err != nil {
+		return err
+	}
+	if err := dumpBytes(w, src); err != nil {
+		return err
+	}
+	if err := w.WriteByte(options); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, 1); err != nil {
+		return err
+	}
+
+	return dumpEncoding(w, arg, enc)
+}
+
+// dumpRStruct writes an instance of a Struct class, with its members in the
+// order they are in.
+func dumpRStruct(w *bufio.Writer, arg *dumpArg, s RStruct) error {
+	arg.objects++
+	if err := w.WriteByte(typeStruct); err != nil {
+		return err
+	}
+	if err := dumpSymbol(w, arg, s.Class); err != nil {
+		return err
+	}
+	if err := writeFixnum(w, len(s.Members)); err != nil {
+		return err
+	}
+
+	for _, m := range s.Members {
+		if err := dumpSymbol(w, arg, m.Name); err != nil {
+			return err
+		}
+		if err := dump(w, arg, m.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dumpFloatObject writes f as a float that takes a slot. In exact mode, a
+// float Ruby keeps as a flonum is linked to if it was written already.
+func dumpFloatObject(w *bufio.Writer, arg *dumpArg, f float64) error {
+	if arg.exact && isFlonum(f) {
+		bits := math.Float64bits(f)
+		if i, ok := arg.flonums[bits]; ok {
+			if err := w.WriteByte(typeObjlink); err != nil {
+				return err
+			}
+			return writeFixnum(w, i)
+		}
+		arg.flonums[bits] = arg.objects
+	}
+	arg.objects++
+
+	return dumpFloat(w, f)
+}
+
+// isFlonum reports whether 64-bit Ruby stores f in the VALUE itself, which
+// it does for positive zero and for floats with an exponent that fits in the
+// bits left after the tag.
+func isFlonum(f float64) bool {
+	bits := math.Float64bits(f)
+	if bits == 0 {
+		return true
+	}
+	b := bits >> 60 & 7
+
+	return bits != 0x3000000000000000 && (b == 3 || b == 4)
+}
+
+// dumpFloat writes f as a float record. The string form of the number must
+// match the one of Ruby byte for byte, otherwise payloads produced in Go and
+// in Ruby won't compare equal.
+func dumpFloat(w *bufio.Writer, f float64) error {
+	s := floatString(f)
+
+	if err := w.WriteByte(typeFloat); err != nil {
+		return err
+	}
+
+	return dumpBytes(w, s)
+}
+
+// floatString formats f like w_float in marshal.c: the shortest digits that
+// round-trip, in the exponent form when the decimal point would fall more than
+// three places left of the digits or anywhere to the right of them.
+func floatString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	case f == 0 && math.Signbit(f):
+		return "-0"
+	case f == 0:
+		return "0"
+	}
+
+	digits, decpt, neg := floatDigits(f)
+
+	var s string
+	switch {
+	case decpt < -3 || decpt > len(digits):
+		s = digits[:1]
+		if len(digits) > 1 {
+			s += "." + digits[1:]
+		}
+		s += "e" + strconv.Itoa(decpt-1)
+	case decpt > 0:
+		s = digits[:decpt]
+		if len(digits) > decpt {
+			s += "." + digits[decpt:]
+		}
+	default:
+		s = "0." + strings.Repeat("0", -decpt) + digits
+	}
+
+	if neg {
+		s = "-" + s
+	}
+
+	return s
+}
@@ -0,0 +1,686 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"math/big"
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+func TestDumpFloat(t *testing.T) {
+	cases := []struct {
+		desc   string
+		f      float64
+		stream []byte
+	}{
+		{
+			"Zero",
+			0,
+			[]byte{0x04, 0x08, 0x66, 0x06, 0x30},
+		},
+		{
+			"Negative zero",
+			math.Copysign(0, -1),
+			[]byte{0x04, 0x08, 0x66, 0x07, 0x2d, 0x30},
+		},
+		{
+			"One",
+			1.0,
+			[]byte{0x04, 0x08, 0x66, 0x06, 0x31},
+		},
+		{
+			"One tenth",
+			0.1,
+			[]byte{0x04, 0x08, 0x66, 0x08, 0x30, 0x2e, 0x31},
+		},
+		{
+			"Hundred",
+			100.0,
+			[]byte{0x04, 0x08, 0x66, 0x08, 0x31, 0x65, 0x32},
+		},
+		{
+			"Googol",
+			1e100,
+			[]byte{
+				0x04, 0x08, 0x66, 0x0a, 0x31, 0x65, 0x31, 0x30,
+				0x30,
+			},
+		},
+		{
+			"Small number",
+			0.0001,
+			[]byte{
+				0x04, 0x08, 0x66, 0x0b, 0x30, 0x2e, 0x30, 0x30,
+				0x30, 0x31,
+			},
+		},
+		{
+			"Smaller number",
+			-1.5e-5,
+			[]byte{
+				0x04, 0x08, 0x66, 0x0c, 0x2d, 0x31, 0x2e, 0x35,
+				0x65, 0x2d, 0x35,
+			},
+		},
+		{
+			"Pi",
+			math.Pi,
+			[]byte{
+				0x04, 0x08, 0x66, 0x16, 0x33, 0x2e, 0x31, 0x34,
+				0x31, 0x35, 0x39, 0x32, 0x36, 0x35, 0x33, 0x35,
+				0x38, 0x39, 0x37, 0x39, 0x33,
+			},
+		},
+		{
+			"Float with mantissa",
+			10.999999999999998,
+			[]byte{
+				0x04, 0x08, 0x66, 0x17, 0x31, 0x30, 0x2e, 0x39,
+				0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39,
+				0x39, 0x39, 0x39, 0x39, 0x39, 0x38,
+			},
+		},
+		{
+			"Large integral float",
+			123456789012345680,
+			[]byte{
+				0x04, 0x08, 0x66, 0x1a, 0x31, 0x2e, 0x32, 0x33,
+				0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x30, 0x31,
+				0x32, 0x33, 0x34, 0x35, 0x36, 0x38, 0x65, 0x31,
+				0x37,
+			},
+		},
+		{
+			"Largest float",
+			math.MaxFloat64,
+			[]byte{
+				0x04, 0x08, 0x66, 0x1b, 0x31, 0x2e, 0x37, 0x39,
+				0x37, 0x36, 0x39, 0x33, 0x31, 0x33, 0x34, 0x38,
+				0x36, 0x32, 0x33, 0x31, 0x35, 0x37, 0x65, 0x33,
+				0x30, 0x38,
+			},
+		},
+		{
+			"Smallest float",
+			math.SmallestNonzeroFloat64,
+			[]byte{
+				0x04, 0x08, 0x66, 0x0b, 0x35, 0x65, 0x2d, 0x33,
+				0x32, 0x34,
+			},
+		},
+		{
+			"Positive infinity",
+			math.Inf(1),
+			[]byte{0x04, 0x08, 0x66, 0x08, 0x69, 0x6e, 0x66},
+		},
+		{
+			"Negative infinity",
+			math.Inf(-1),
+			[]byte{0x04, 0x08, 0x66, 0x09, 0x2d, 0x69, 0x6e, 0x66},
+		},
+		{
+			"NaN",
+			math.NaN(),
+			[]byte{0x04, 0x08, 0x66, 0x08, 0x6e, 0x61, 0x6e},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			w.Write(marshalVersion[:])
+			if err := dumpFloat(w, c.f); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			w.Flush()
+
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("stream: got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+func TestDumpHashRoundTrip(t *testing.T) {
+	// {:a=>1, "b"=>2, 3=>4}
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x08, 0x3a, 0x06, 0x61, 0x69,
+		0x06, 0x49, 0x22, 0x06, 0x62, 0x06, 0x3a, 0x06,
+		0x45, 0x54, 0x69, 0x07, 0x69, 0x08, 0x69, 0x09,
+	}
+
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), &LoadArg{OrderedHashes: true})
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	var buf bytes.Buffer
+	if err := Dump(&buf, data); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(buf.Bytes(), stream) {
+		t.Errorf("got %x, want %x", buf.Bytes(), stream)
+	}
+}
+
+func TestDumpCyclicRoundTrip(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+	}{
+		{
+			// o = Foo.new; o.instance_variable_set(:@me, o); o
+			"Object",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x06, 0x3a, 0x08, 0x40, 0x6d, 0x65, 0x40, 0x00,
+			},
+		},
+		{
+			// Foo = Struct.new(:me); s = Foo.new; s.me = s; s
+			"Struct",
+			[]byte{
+				0x04, 0x08, 0x53, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x06, 0x3a, 0x07, 0x6d, 0x65, 0x40, 0x00,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := LoadBytes(c.stream)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			var buf bytes.Buffer
+			if err := Dump(&buf, data); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+func TestDumpFloatRoundTrip(t *testing.T) {
+	floats := []float64{
+		math.Copysign(0, -1),
+		math.MaxFloat64,
+		-math.MaxFloat64,
+		math.SmallestNonzeroFloat64,
+		1e21,
+		1e-7,
+		123456789012345680,
+		1.0 / 3,
+		float64(float32(0.1)),
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		f := math.Float64frombits(rnd.Uint64())
+		if !math.IsNaN(f) {
+			floats = append(floats, f)
+		}
+	}
+
+	for _, f := range floats {
+		var buf bytes.Buffer
+		if err := Dump(&buf, f); err != nil {
+			t.Fatalf("%v: unexpected error: '%q'", f, err)
+		}
+
+		data, err := Load(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%v: unexpected error: '%q'", f, err)
+		}
+		got, ok := data.(float64)
+		if !ok || math.Float64bits(got) != math.Float64bits(f) {
+			t.Errorf("%v (%s): read back %v", f, floatString(f), data)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	re := regexp.MustCompile("é")
+	cases := []struct {
+		desc   string
+		v      interface{}
+		stream []byte
+	}{
+		{"Nil", nil, []byte{0x04, 0x08, 0x30}},
+		{"True", true, []byte{0x04, 0x08, 0x54}},
+		{"False", false, []byte{0x04, 0x08, 0x46}},
+		{"Fixnum 0", 0, []byte{0x04, 0x08, 0x69, 0x00}},
+		{"Fixnum 122", int8(122), []byte{0x04, 0x08, 0x69, 0x7f}},
+		{"Fixnum -123", int64(-123), []byte{0x04, 0x08, 0x69, 0x80}},
+		{"Fixnum 300", uint16(300), []byte{0x04, 0x08, 0x69, 0x02, 0x2c, 0x01}},
+		{
+			"Bignum 2**30",
+			1 << 30,
+			[]byte{0x04, 0x08, 0x6c, 0x2b, 0x07, 0x00, 0x00, 0x00, 0x40},
+		},
+		{
+			"Bignum -(2**30 + 1)",
+			-1<<30 - 1,
+			[]byte{0x04, 0x08, 0x6c, 0x2d, 0x07, 0x01, 0x00, 0x00, 0x40},
+		},
+		{
+			"Bignum 2**63",
+			uint64(1 << 63),
+			[]byte{
+				0x04, 0x08, 0x6c, 0x2b, 0x09, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x80,
+			},
+		},
+		{
+			"Bignum 2**64 from *big.Int",
+			new(big.Int).Lsh(big.NewInt(1), 64),
+			[]byte{
+				0x04, 0x08, 0x6c, 0x2b, 0x0a, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+			},
+		},
+		{"Small *big.Int", big.NewInt(5), []byte{0x04, 0x08, 0x69, 0x0a}},
+		{"Float", 1.5, []byte{0x04, 0x08, 0x66, 0x08, 0x31, 0x2e, 0x35}},
+		{
+			"String",
+			"Hi",
+			[]byte{
+				0x04, 0x08, 0x49, 0x22, 0x07, 0x48, 0x69, 0x06,
+				0x3a, 0x06, 0x45, 0x54,
+			},
+		},
+		{"Invalid UTF-8", "\xff", []byte{0x04, 0x08, 0x22, 0x06, 0xff}},
+		{"Bytes", []byte{0xff}, []byte{0x04, 0x08, 0x22, 0x06, 0xff}},
+		{
+			// ["a".force_encoding("US-ASCII"), "\x82\xa0".force_encoding("Shift_JIS"),
+			//  "b".b, "c".encode("Shift_JIS")]
+			//
+			// The name of an encoding is written once, and linked to after.
+			"Strings with encodings",
+			makeSlice(
+				RString{"a", "US-ASCII", nil},
+				RString{"\x82\xa0", "Shift_JIS", nil},
+				RString{"b", "ASCII-8BIT", nil},
+				RString{"c", "Shift_JIS", nil},
+			),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x09, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3a, 0x06, 0x45, 0x46, 0x49, 0x22, 0x07,
+				0x82, 0xa0, 0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63,
+				0x6f, 0x64, 0x69, 0x6e, 0x67, 0x22, 0x0e, 0x53,
+				0x68, 0x69, 0x66, 0x74, 0x5f, 0x4a, 0x49, 0x53,
+				0x22, 0x06, 0x62, 0x49, 0x22, 0x06, 0x63, 0x06,
+				0x3b, 0x06, 0x40, 0x08,
+			},
+		},
+		{
+			// s = "x"; s.instance_variable_set(:@a, 1)
+			"String with ivars",
+			RString{"x", "UTF-8", map[string]interface{}{"@a": 1}},
+			[]byte{
+				0x04, 0x08, 0x49, 0x22, 0x06, 0x78, 0x07, 0x3a,
+				0x06, 0x45, 0x54, 0x3a, 0x07, 0x40, 0x61, 0x69,
+				0x06,
+			},
+		},
+		{
+			// [:a, :b, :a, {:b=>"E"}, :"名前", :"名前", :E]
+			"Symbols",
+			makeSlice(
+				Symbol("a"), Symbol("b"), Symbol("a"),
+				map[Symbol]string{"b": "E"},
+				Symbol("名前"), Symbol("名前"), Symbol("E"),
+			),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x0c, 0x3a, 0x06, 0x61, 0x3a,
+				0x06, 0x62, 0x3b, 0x00, 0x7b, 0x06, 0x3b, 0x06,
+				0x49, 0x22, 0x06, 0x45, 0x06, 0x3a, 0x06, 0x45,
+				0x54, 0x49, 0x3a, 0x0b, 0xe5, 0x90, 0x8d, 0xe5,
+				0x89, 0x8d, 0x06, 0x3b, 0x07, 0x54, 0x3b, 0x08,
+				0x3b, 0x07,
+			},
+		},
+		{
+			"UserDef",
+			UserDef{"Foo", []byte{0x00, 0xff}, nil},
+			[]byte{
+				0x04, 0x08, 0x75, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x07, 0x00, 0xff,
+			},
+		},
+		{
+			// [1, [nil, "a"]]
+			"Nested arrays",
+			makeSlice(1, [2]interface{}{nil, "a"}),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x5b, 0x07,
+				0x30, 0x49, 0x22, 0x06, 0x61, 0x06, 0x3a, 0x06,
+				0x45, 0x54,
+			},
+		},
+		{
+			// {"a"=>[true]}
+			"Hash",
+			map[string][]bool{"a": {true}},
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x5b, 0x06, 0x54,
+			},
+		},
+		{
+			// Point = Struct.new(:x, :y); [Point.new(1, 2), Point.new(3, nil)]
+			"Structs",
+			makeSlice(
+				RStruct{"Point", []StructMember{{"x", 1}, {"y", 2}}},
+				RStruct{"Point", []StructMember{{"x", 3}, {"y", nil}}},
+			),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x53, 0x3a, 0x0a, 0x50,
+				0x6f, 0x69, 0x6e, 0x74, 0x07, 0x3a, 0x06, 0x78,
+				0x69, 0x06, 0x3a, 0x06, 0x79, 0x69, 0x07, 0x53,
+				0x3b, 0x00, 0x07, 0x3b, 0x06, 0x69, 0x08, 0x3b,
+				0x07, 0x30,
+			},
+		},
+		{
+			// /a/i
+			"Regexp",
+			RRegexp{Source: "a", Options: regexpIgnoreCase},
+			[]byte{
+				0x04, 0x08, 0x49, 0x2f, 0x06, 0x61, 0x01, 0x06,
+				0x3a, 0x06, 0x45, 0x46,
+			},
+		},
+		{
+			// r = /é/; [r, r]
+			"Shared Go regexp",
+			makeSlice(re, re),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x2f, 0x07, 0xc3,
+				0xa9, 0x10, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x40,
+				0x06,
+			},
+		},
+		{
+			// {1=>:x, 2=>:y, 10=>:z}
+			"Ordered hash",
+			&OrderedHash{Pairs: []HashPair{
+				{1, Symbol("x")}, {2, Symbol("y")}, {10, Symbol("z")},
+			}},
+			[]byte{
+				0x04, 0x08, 0x7b, 0x08, 0x69, 0x06, 0x3a, 0x06,
+				0x78, 0x69, 0x07, 0x3a, 0x06, 0x79, 0x69, 0x0f,
+				0x3a, 0x06, 0x7a,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Dump(&buf, c.v); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("stream: got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	cases := []struct {
+		desc string
+		v    interface{}
+		err  string
+	}{
+		{"Unsupported type", makeSlice(make(chan int)), "cannot dump chan int"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			err := Dump(new(bytes.Buffer), c.v)
+			if err == nil || err.Error() != c.err {
+				t.Errorf("error: got %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestWriteFixnum(t *testing.T) {
+	cases := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x06}},
+		{122, []byte{0x7f}},
+		{123, []byte{0x01, 0x7b}},
+		{255, []byte{0x01, 0xff}},
+		{256, []byte{0x02, 0x00, 0x01}},
+		{65535, []byte{0x02, 0xff, 0xff}},
+		{65536, []byte{0x03, 0x00, 0x00, 0x01}},
+		{1<<24 - 1, []byte{0x03, 0xff, 0xff, 0xff}},
+		{1 << 24, []byte{0x04, 0x00, 0x00, 0x00, 0x01}},
+		{maxFixnum, []byte{0x04, 0xff, 0xff, 0xff, 0x3f}},
+		{-1, []byte{0xfa}},
+		{-123, []byte{0x80}},
+		{-124, []byte{0xff, 0x84}},
+		{-255, []byte{0xff, 0x01}},
+		{-256, []byte{0xff, 0x00}},
+		{-257, []byte{0xfe, 0xff, 0xfe}},
+		{-65536, []byte{0xfe, 0x00, 0x00}},
+		{-65537, []byte{0xfd, 0xff, 0xff, 0xfe}},
+		{-1 << 24, []byte{0xfd, 0x00, 0x00, 0x00}},
+		{-1<<24 - 1, []byte{0xfc, 0xff, 0xff, 0xff, 0xfe}},
+		{minFixnum, []byte{0xfc, 0x00, 0x00, 0x00, 0xc0}},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		if err := writeFixnum(w, c.n); err != nil {
+			t.Fatalf("%d: unexpected error: '%q'", c.n, err)
+		}
+		w.Flush()
+
+		if !bytes.Equal(buf.Bytes(), c.want) {
+			t.Errorf("%d: got %x, want %x", c.n, buf.Bytes(), c.want)
+		}
+
+		n, err := readFixnum(bufio.NewReader(&buf), new(LoadArg))
+		if err != nil || n != c.n {
+			t.Errorf("%d: read back %d, %v", c.n, n, err)
+		}
+	}
+}
+
+func TestDumpLinks(t *testing.T) {
+	shared := []int{1}
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = cyclic
+	str := RString{"x", "", nil}
+	n := 5
+	data := makeSlice(nil)
+	data[0] = UserMarshal{Class: "Foo", Data: data}
+	money := &UserDef{Class: "Money", Data: []byte("1"), Ivars: map[string]interface{}{"@note": "n"}}
+
+	cases := []struct {
+		desc   string
+		v      interface{}
+		stream []byte
+	}{
+		{
+			// a = [1]; [a, a, 1.5, a]
+			"Shared slice",
+			makeSlice(shared, shared, 1.5, shared),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x09, 0x5b, 0x06, 0x69, 0x06,
+				0x40, 0x06, 0x66, 0x08, 0x31, 0x2e, 0x35, 0x40,
+				0x06,
+			},
+		},
+		{
+			// h = {}; h["self"] = h
+			"Cyclic map",
+			cyclic,
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x49, 0x22, 0x09, 0x73,
+				0x65, 0x6c, 0x66, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x40, 0x00,
+			},
+		},
+		{
+			// s = "x".b; [s, s]
+			"Shared pointer",
+			makeSlice(&str, &str),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x22, 0x06, 0x78, 0x40,
+				0x06,
+			},
+		},
+		{
+			// u = Foo.new, whose marshal_dump is [u]
+			"Cyclic user type",
+			data[0],
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x5b, 0x06, 0x40, 0x00,
+			},
+		},
+		{
+			// m = Money.new("1") with @note = "n"; [m, m]
+			"Shared user type with ivars",
+			makeSlice(money, money),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x75, 0x3a, 0x0a,
+				0x4d, 0x6f, 0x6e, 0x65, 0x79, 0x06, 0x31, 0x06,
+				0x3a, 0x0a, 0x40, 0x6e, 0x6f, 0x74, 0x65, 0x49,
+				0x22, 0x06, 0x6e, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x40, 0x07,
+			},
+		},
+		{
+			"Pointer to a fixnum",
+			makeSlice(&n, &n),
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x0a, 0x69, 0x0a},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Dump(&buf, c.v); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("stream: got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+type dumpTimestamps struct {
+	CreatedAt int
+}
+
+type dumpUser struct {
+	_      struct{} `rbmarshal:"User"`
+	Name   string   `rbmarshal:"@name"`
+	UserID int
+	Skip   int `rbmarshal:"-"`
+	secret int
+	dumpTimestamps
+}
+
+type dumpAccount struct {
+	ID int
+}
+
+func (*dumpAccount) RubyClassName() string {
+	return "Billing::Account"
+}
+
+func TestDumpStruct(t *testing.T) {
+	account := &dumpAccount{1}
+
+	cases := []struct {
+		desc   string
+		v      interface{}
+		stream []byte
+	}{
+		{
+			// User.new(name: "Ann", user_id: 7, created_at: 1)
+			"Tags and field names",
+			dumpUser{Name: "Ann", UserID: 7, Skip: 1, secret: 2, dumpTimestamps: dumpTimestamps{1}},
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x09, 0x55, 0x73, 0x65,
+				0x72, 0x08, 0x3a, 0x0a, 0x40, 0x6e, 0x61, 0x6d,
+				0x65, 0x49, 0x22, 0x08, 0x41, 0x6e, 0x6e, 0x06,
+				0x3a, 0x06, 0x45, 0x54, 0x3a, 0x0d, 0x40, 0x75,
+				0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x69, 0x0c,
+				0x3a, 0x10, 0x40, 0x63, 0x72, 0x65, 0x61, 0x74,
+				0x65, 0x64, 0x5f, 0x61, 0x74, 0x69, 0x06,
+			},
+		},
+		{
+			// a = Billing::Account.new(id: 1); [a, a]
+			"RubyClassName",
+			makeSlice(account, account),
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x6f, 0x3a, 0x15, 0x42,
+				0x69, 0x6c, 0x6c, 0x69, 0x6e, 0x67, 0x3a, 0x3a,
+				0x41, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x06,
+				0x3a, 0x08, 0x40, 0x69, 0x64, 0x69, 0x06, 0x40,
+				0x06,
+			},
+		},
+		{
+			"RObject",
+			RObject{"Point", map[string]interface{}{"@y": Symbol("a"), "@x": 1}},
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40, 0x78, 0x69,
+				0x06, 0x3a, 0x07, 0x40, 0x79, 0x3a, 0x06, 0x61,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Dump(&buf, c.v); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("stream: got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+
+	t.Run("Anonymous struct", func(t *testing.T) {
+		err := Dump(new(bytes.Buffer), struct{ A int }{1})
+		want := "cannot dump struct { A int } without a class name"
+		if err == nil || err.Error() != want {
+			t.Errorf("error: got %v, want %q", err, want)
+		}
+	})
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"Name":       "name",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+		"Base64Data": "base64_data",
+		"X":          "x",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"io"
+)
+
+// An Encoder writes Marshal data to an output stream. Every value encoded is
+// a dump of its own, with its own version header, so a stream of them can be
+// read back with a Decoder.
+type Encoder struct {
+	// Exact, if set, makes Encode write what MRI's Marshal.dump would for
+	// the same value, byte for byte, so that signed payloads can be made in
+	// Go. Repeated floats are linked the way Ruby links flonums, and values
+	// whose order Ruby would know but Go doesn't, like maps with more than
+	// one pair or RObject with more than one ivar, fail to encode. Structs
+	// keep the order of their fields.
+	Exact bool
+
+	// SortKeys, if set, makes Encode write the pairs of maps sorted by key,
+	// so that the same value always encodes to the same bytes. See
+	// OrderedHash for hashes that need an order of their own.
+	SortKeys bool
+
+	// Version, if set, is written in the header of every dump instead of
+	// 4.8, for peers that want a specific minor version, like {4, 7}. The
+	// format itself doesn't change.
+	Version [2]byte
+
+	w   *bufio.Writer
+	buf *bufio.Writer // unlike w, never belongs to the caller
+	arg dumpArg
+}
+
+// NewEncoder returns a new encoder that writes to w. If w is not a
+// *bufio.Writer already, it gets wrapped into one.
+func NewEncoder(w io.Writer) *Encoder {
+	e := new(Encoder)
+	e.Reset(w)
+
+	return e
+}
+
+// Reset makes the encoder write to w, keeping its options. The buffer and
+// the tables of symbols and objects allocated so far are reused, so an
+// encoder that is reset for every message doesn't allocate them again.
+func (e *Encoder) Reset(w io.Writer) {
+	if bw, ok := w.(*bufio.Writer); ok {
+		e.w = bw
+		return
+	}
+
+	if e.buf == nil {
+		e.buf = bufio.NewWriter(w)
+	} else {
+		e.buf.Reset(w)
+	}
+	e.w = e.buf
+}
+
+// Encode writes the dump of v to the stream and flushes it, so that the peer
+// can load it without waiting for the next one. See Dump for the values that
+// can be encoded.
+func (e *Encoder) Encode(v interface{}) error {
+	version := marshalVersion[:]
+	if e.Version != [2]byte{} {
+		version = e.Version[:]
+	}
+	if _, err := e.w.Write(version); err != nil {
+		return err
+	}
+	resetDumpArg(&e.arg)
+	e.arg.exact = e.Exact
+	e.arg.sortKeys = e.SortKeys
+	if err := dump(e.w, &e.arg, v); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
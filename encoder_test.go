@@ -0,0 +1,249 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderEncode(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+
+	values := makeSlice(true, 123, "Hi", makeSlice(1, nil))
+	for i, v := range values {
+		n := buf.Len()
+		if err := e.Encode(v); err != nil {
+			t.Fatalf("unexpected error: '%q'", err)
+		}
+		// Every dump is flushed as soon as it is encoded.
+		if buf.Len() == n {
+			t.Errorf("dump %d wasn't flushed", i)
+		}
+	}
+
+	want := []byte{
+		0x04, 0x08, 0x54,
+		0x04, 0x08, 0x69, 0x01, 0x7b,
+		0x04, 0x08, 0x49, 0x22, 0x07, 0x48, 0x69, 0x06,
+		0x3a, 0x06, 0x45, 0x54,
+		0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x30,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("stream: got %x, want %x", buf.Bytes(), want)
+	}
+
+	data, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !reflect.DeepEqual(data, values) {
+		t.Errorf("data: got %v, want %v", data, values)
+	}
+}
+
+func TestEncoderExact(t *testing.T) {
+	type user struct {
+		_     struct{} `rbmarshal:"User"`
+		Name  string
+		Admin bool
+	}
+
+	// [1, -129, 2**40, "héllo", "bin".b, :sym, :sym, 1.5, nil, true,
+	//  User.new("Ann", false), {k: :sym}, 1.5]
+	v := makeSlice(
+		1, -129, int64(1<<40), "héllo", []byte("bin"), Symbol("sym"),
+		Symbol("sym"), 1.5, nil, true, user{Name: "Ann"},
+		map[Symbol]Symbol{"k": "sym"}, 1.5,
+	)
+	want := []byte{
+		0x04, 0x08, 0x5b, 0x12, 0x69, 0x06, 0x69, 0xff,
+		0x7f, 0x6c, 0x2b, 0x08, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x49, 0x22, 0x0b, 0x68, 0xc3, 0xa9,
+		0x6c, 0x6c, 0x6f, 0x06, 0x3a, 0x06, 0x45, 0x54,
+		0x22, 0x08, 0x62, 0x69, 0x6e, 0x3a, 0x08, 0x73,
+		0x79, 0x6d, 0x3b, 0x06, 0x66, 0x08, 0x31, 0x2e,
+		0x35, 0x30, 0x54, 0x6f, 0x3a, 0x09, 0x55, 0x73,
+		0x65, 0x72, 0x07, 0x3a, 0x0a, 0x40, 0x6e, 0x61,
+		0x6d, 0x65, 0x49, 0x22, 0x08, 0x41, 0x6e, 0x6e,
+		0x06, 0x3b, 0x00, 0x54, 0x3a, 0x0b, 0x40, 0x61,
+		0x64, 0x6d, 0x69, 0x6e, 0x46, 0x7b, 0x06, 0x3a,
+		0x06, 0x6b, 0x3b, 0x06, 0x40, 0x09,
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Exact = true
+	if err := e.Encode(v); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestEncoderExactFloats(t *testing.T) {
+	cases := []struct {
+		desc string
+		v    float64
+		want []byte
+	}{
+		// a = 0.5; [a, a]
+		{"Flonum", 0.5, []byte{
+			0x04, 0x08, 0x5b, 0x07, 0x66, 0x08, 0x30, 0x2e,
+			0x35, 0x40, 0x06,
+		}},
+		// a = 1e300; [a, a]
+		{"Heap float", 1e300, []byte{
+			0x04, 0x08, 0x5b, 0x07, 0x66, 0x0a, 0x31, 0x65,
+			0x33, 0x30, 0x30, 0x66, 0x0a, 0x31, 0x65, 0x33,
+			0x30, 0x30,
+		}},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.Exact = true
+		if err := e.Encode(makeSlice(c.v, c.v)); err != nil {
+			t.Fatalf("%s: unexpected error: '%q'", c.desc, err)
+		}
+		if !bytes.Equal(buf.Bytes(), c.want) {
+			t.Errorf("%s: got %x, want %x", c.desc, buf.Bytes(), c.want)
+		}
+	}
+}
+
+func TestEncoderExactErrors(t *testing.T) {
+	cases := []struct {
+		desc string
+		v    interface{}
+	}{
+		{"Map", map[string]int{"a": 1, "b": 2}},
+		{"Object", RObject{"User", map[string]interface{}{"@a": 1, "@b": 2}}},
+		{"String", RString{"a", "UTF-8", map[string]interface{}{"@a": 1, "@b": 2}}},
+		{"User type", UserDef{"Money", []byte("1"), map[string]interface{}{"@a": 1, "@b": 2}}},
+	}
+
+	for _, c := range cases {
+		e := NewEncoder(&bytes.Buffer{})
+		e.Exact = true
+		if err := e.Encode(c.v); err == nil {
+			t.Errorf("%s: expected an error", c.desc)
+		}
+		// The same value is fine outside of exact mode.
+		e.Exact = false
+		if err := e.Encode(c.v); err != nil {
+			t.Errorf("%s: unexpected error: '%q'", c.desc, err)
+		}
+	}
+}
+
+func TestEncoderSortKeys(t *testing.T) {
+	cases := []struct {
+		desc   string
+		v      interface{}
+		stream []byte
+	}{
+		{
+			// {1=>:x, 2=>:y, 10=>:z}
+			"Integer keys",
+			map[int]Symbol{10: "z", 2: "y", 1: "x"},
+			[]byte{
+				0x04, 0x08, 0x7b, 0x08, 0x69, 0x06, 0x3a, 0x06,
+				0x78, 0x69, 0x07, 0x3a, 0x06, 0x79, 0x69, 0x0f,
+				0x3a, 0x06, 0x7a,
+			},
+		},
+		{
+			// {"a"=>1, "b"=>2, "c"=>3}
+			"String keys",
+			map[string]int{"c": 3, "b": 2, "a": 1},
+			[]byte{
+				0x04, 0x08, 0x7b, 0x08, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x69, 0x06, 0x49,
+				0x22, 0x06, 0x62, 0x06, 0x3b, 0x00, 0x54, 0x69,
+				0x07, 0x49, 0x22, 0x06, 0x63, 0x06, 0x3b, 0x00,
+				0x54, 0x69, 0x08,
+			},
+		},
+		{
+			// {nil=>1, 2=>3, :a=>4}
+			"Mixed keys",
+			map[interface{}]int{Symbol("a"): 4, 2: 3, nil: 1},
+			[]byte{
+				0x04, 0x08, 0x7b, 0x08, 0x30, 0x69, 0x06, 0x69,
+				0x07, 0x69, 0x08, 0x3a, 0x06, 0x61, 0x69, 0x09,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		// Go iterates over maps in a new order every time.
+		for i := 0; i < 10; i++ {
+			var buf bytes.Buffer
+			e := NewEncoder(&buf)
+			e.SortKeys = true
+			if err := e.Encode(c.v); err != nil {
+				t.Fatalf("%s: unexpected error: '%q'", c.desc, err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Fatalf("%s: got %x, want %x", c.desc, buf.Bytes(), c.stream)
+			}
+		}
+	}
+}
+
+func TestEncoderVersion(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Version = [2]byte{4, 7}
+	if err := e.Encode(true); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if want := []byte{0x04, 0x07, 0x54}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+
+	d := NewDecoder(&buf)
+	d.AllowVersions = [][2]byte{{4, 7}}
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if v != true {
+		t.Errorf("got %v, want true", v)
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	var a, b bytes.Buffer
+	e := NewEncoder(&a)
+	e.SortKeys = true
+	v := makeSlice(Symbol("a"), map[string]int{"b": 2, "a": 1})
+	if err := e.Encode(v); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	// The options stay, and nothing of the first writer is left.
+	e.Reset(&b)
+	if err := e.Encode(v); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Errorf("got %x, want %x", b.Bytes(), a.Bytes())
+	}
+
+	// Once the tables have grown, encoding allocates nothing.
+	var msg interface{} = makeSlice(Symbol("a"), Symbol("a"), "b", true)
+	allocs := testing.AllocsPerRun(100, func() {
+		b.Reset()
+		e.Reset(&b)
+		if err := e.Encode(msg); err != nil {
+			t.Fatalf("unexpected error: '%q'", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per message, want none", allocs)
+	}
+}
@@ -0,0 +1,6 @@
+package rbmarshal
+
+// Encodings dump their name with _dump, and decode to it.
+func decodeEncoding(u UserDef) (interface{}, error) {
+	return string(u.Data), nil
+}
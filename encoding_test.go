@@ -0,0 +1,26 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadEncoding(t *testing.T) {
+	// [Encoding::UTF_8, Encoding::UTF_8]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x49, 0x75, 0x3a, 0x0d,
+		0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67,
+		0x0a, 0x55, 0x54, 0x46, 0x2d, 0x38, 0x06, 0x3a,
+		0x06, 0x45, 0x46, 0x40, 0x06,
+	}
+
+	data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if want := makeSlice("UTF-8", "UTF-8"); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+}
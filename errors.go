@@ -0,0 +1,233 @@
+package rbmarshal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The errors that decoding fails with, wrapped in a DecodeError and usually
+// with details, so they're best told apart with errors.Is.
+var (
+	// ErrBadVersion is returned for a dump of a Marshal version that isn't
+	// 4.8 nor one of LoadArg.AllowVersions.
+	ErrBadVersion = errors.New("unsupported marshal version")
+
+	// ErrUnsupportedType is returned for a type byte that the decoder
+	// doesn't know, as an UnsupportedTypeError.
+	ErrUnsupportedType = errors.New("unsupported type byte")
+
+	// ErrTruncated is returned for a dump that ends too early. It is
+	// io.ErrUnexpectedEOF, so that checking for either one works.
+	ErrTruncated = io.ErrUnexpectedEOF
+
+	// ErrBadSymlink is returned for a symlink to a symbol that wasn't read,
+	// and ErrBadLink for a link to an object that wasn't.
+	ErrBadSymlink = errors.New("invalid symlink")
+	ErrBadLink    = errors.New("invalid object link")
+
+	// ErrBadLength is returned for a negative length of a string, an array, a
+	// hash or a bignum, and ErrLengthExceeded for one over
+	// LoadArg.MaxStringLen or LoadArg.MaxCollectionLen.
+	ErrBadLength      = errors.New("invalid length")
+	ErrLengthExceeded = errors.New("length limit exceeded")
+
+	// ErrBadBignum is returned for a bignum whose sign is neither '+' nor '-'.
+	ErrBadBignum = errors.New("invalid bignum sign")
+
+	// ErrExpectedSymbol is returned when the stream has something else where
+	// a symbol, such as a class name, must be.
+	ErrExpectedSymbol = errors.New("expected a symbol")
+
+	// ErrDepthExceeded is returned for values nested more deeply than
+	// LoadArg.MaxDepth allows.
+	ErrDepthExceeded = errors.New("depth limit exceeded")
+
+	// ErrBudgetExceeded is returned when the stream is longer than
+	// LoadArg.MaxBytes allows.
+	ErrBudgetExceeded = errors.New("byte budget exceeded")
+
+	// ErrTrailingData is returned by Validate for data after the dump, and
+	// when LoadArg.DisallowTrailingData is set.
+	ErrTrailingData = errors.New("trailing data")
+)
+
+// UnsupportedTypeError is returned for a type byte that the decoder doesn't
+// know. Offset is the position of the byte in the stream.
+type UnsupportedTypeError struct {
+	TypeByte byte
+	Offset   int64
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("%v %q", ErrUnsupportedType, e.TypeByte)
+}
+
+// Is makes an UnsupportedTypeError match ErrUnsupportedType.
+func (e *UnsupportedTypeError) Is(target error) bool {
+	return target == ErrUnsupportedType
+}
+
+// DecodeError is the error that decoding a dump returns, whatever went wrong.
+// Offset is the position in the dump where it did, counting from the start of
+// its header. Path leads to the value that failed, in the form Extract takes,
+// such as "users[3].created_at", but for the keys of hashes, which get a step
+// like "{2}" for the key of the third pair. It is empty for a failure at the
+// top of the dump, or when the values along the way aren't built, as with
+// Validate and Decoder.Skip.
+//
+// Err is the underlying error, so errors.Is and errors.As see through a
+// DecodeError, to io.ErrUnexpectedEOF for a truncated dump, for example.
+type DecodeError struct {
+	Offset int64
+	Path   string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%v at offset %d, path %s", e.Err, e.Offset, e.Path)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps err into a DecodeError, once decoding has stopped. An
+// io.EOF before anything was read is left alone, since it means that there
+// was no dump at all; anywhere else the dump was cut short.
+func decodeError(err error, arg *LoadArg) error {
+	if err == nil {
+		return nil
+	}
+
+	var path []string
+	if pe, ok := err.(*pathError); ok {
+		err = pe.err
+		for i := len(pe.steps) - 1; i >= 0; i-- {
+			path = append(path, pe.steps[i])
+		}
+	}
+
+	if err == io.EOF {
+		if arg.offset == 0 {
+			return err
+		}
+		err = ErrTruncated
+	}
+
+	offset := arg.offset
+	var at *offsetError
+	var unsupported *UnsupportedTypeError
+	switch {
+	case errors.As(err, &at):
+		offset, err = at.offset, at.err
+	case errors.As(err, &unsupported):
+		offset = unsupported.Offset
+	}
+
+	return &DecodeError{
+		Offset: offset,
+		Path:   strings.TrimPrefix(strings.Join(path, ""), "."),
+		Err:    err,
+	}
+}
+
+// recoverPanic turns a panic while decoding into a DecodeError stored in *err.
+// It is a last line of defence: malformed data should fail with an error of
+// its own long before it can make the decoder panic.
+func recoverPanic(err *error, arg *LoadArg) {
+	if r := recover(); r != nil {
+		*err = &DecodeError{
+			Offset: arg.offset,
+			Err:    fmt.Errorf("panic while decoding: %v", r),
+		}
+	}
+}
+
+// An offsetError is an error about the value that starts at offset, which is
+// a better place to report than where reading stopped.
+type offsetError struct {
+	offset int64
+	err    error
+}
+
+func (e *offsetError) Error() string {
+	return e.err.Error()
+}
+
+func (e *offsetError) Unwrap() error {
+	return e.err
+}
+
+// A pathError is an error from inside an array, a hash or an object, with the
+// steps to the value that failed, innermost first.
+type pathError struct {
+	steps []string
+	err   error
+}
+
+func (e *pathError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pathError) Unwrap() error {
+	return e.err
+}
+
+// inPath records that err comes from step of the value being read.
+func inPath(err error, step string) error {
+	if pe, ok := err.(*pathError); ok {
+		pe.steps = append(pe.steps, step)
+		return pe
+	}
+
+	return &pathError{steps: []string{step}, err: err}
+}
+
+func indexStep(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+// hashKeyStep is the step to the key of the i-th pair of a hash, which has no
+// name to go by, such as "{2}".
+func hashKeyStep(i int) string {
+	return "{" + strconv.Itoa(i) + "}"
+}
+
+// keyStep is the step to the value of a hash key. Names are written the way
+// Extract matches them.
+func keyStep(key interface{}) string {
+	switch k := key.(type) {
+	case Symbol:
+		return "." + string(k)
+	case string:
+		return "." + k
+	}
+
+	return fmt.Sprintf("[%v]", key)
+}
+
+// nodeKeyStep is like keyStep, for the keys of a Node. Keys other than names
+// and integers are left as "[?]".
+func nodeKeyStep(key *Node) string {
+	switch key.Type {
+	case typeSymbol, typeString:
+		return "." + string(key.Bytes)
+	case typeFixnum:
+		return indexStep(key.Int)
+	case typeBignum:
+		return "[" + key.Big.String() + "]"
+	}
+
+	return "[?]"
+}
+
+// ivarStep is the step to the value of an ivar or a struct member.
+func ivarStep(name string) string {
+	return "." + strings.TrimPrefix(name, "@")
+}
@@ -0,0 +1,190 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+	// {users: [nil, {created_at: ...}]}, cut short before the last value.
+	users := []byte{
+		0x04, 0x08, 0x7b, 0x06, 0x3a, 0x0a, 0x75, 0x73,
+		0x65, 0x72, 0x73, 0x5b, 0x07, 0x30, 0x7b, 0x06,
+		0x3a, 0x0f, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65,
+		0x64, 0x5f, 0x61, 0x74,
+	}
+
+	cases := []struct {
+		desc     string
+		stream   []byte
+		document bool
+		want     *DecodeError
+	}{
+		{
+			"Truncated hash",
+			users,
+			false,
+			&DecodeError{Offset: 28, Path: "users[1].created_at", Err: io.ErrUnexpectedEOF},
+		},
+		{
+			"Truncated document",
+			users,
+			true,
+			&DecodeError{Offset: 28, Path: "users[1].created_at", Err: io.ErrUnexpectedEOF},
+		},
+		{
+			// [Point.new(1, <unknown>)]
+			"Object",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x06, 0x6f, 0x3a, 0x0a, 0x50,
+				0x6f, 0x69, 0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40,
+				0x78, 0x69, 0x06, 0x3a, 0x07, 0x40, 0x79, 0x5a,
+			},
+			false,
+			&DecodeError{
+				Offset: 23,
+				Path:   "[0].y",
+				Err:    &UnsupportedTypeError{TypeByte: 'Z', Offset: 23},
+			},
+		},
+		{
+			// {a: {<unknown>=>1}}
+			"Hash key",
+			[]byte{0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x7b, 0x06, 0x5a},
+			false,
+			&DecodeError{
+				Offset: 9,
+				Path:   "a{0}",
+				Err:    &UnsupportedTypeError{TypeByte: 'Z', Offset: 9},
+			},
+		},
+		{
+			"Hash key in a document",
+			[]byte{0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x7b, 0x06, 0x5a},
+			true,
+			&DecodeError{
+				Offset: 9,
+				Path:   "a{0}",
+				Err:    &UnsupportedTypeError{TypeByte: 'Z', Offset: 9},
+			},
+		},
+		{
+			// [:a, <a string for a class name>]
+			"Bad name",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0x61, 0x6f,
+				0x22, 0x06, 0x61,
+			},
+			false,
+			&DecodeError{
+				Offset: 8,
+				Path:   "[1]",
+				Err:    errors.New(`expected a symbol, got type byte '"'`),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			arg := &LoadArg{Document: c.document, DisallowUnknownTypes: true}
+			_, err := LoadBytesWith(c.stream, arg)
+			var got *DecodeError
+			if !errors.As(err, &got) {
+				t.Fatalf("got %v, want a *DecodeError", err)
+			}
+			if got.Offset != c.want.Offset || got.Path != c.want.Path {
+				t.Errorf("got offset %d, path %q, want %d, %q", got.Offset, got.Path, c.want.Offset, c.want.Path)
+			}
+			if got.Err.Error() != c.want.Err.Error() {
+				t.Errorf("got %v, want %v", got.Err, c.want.Err)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorUnwrap(t *testing.T) {
+	err := &DecodeError{Offset: 1832, Path: "users[3].created_at", Err: io.ErrUnexpectedEOF}
+	if want := "unexpected EOF at offset 1832, path users[3].created_at"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Error("errors.Is doesn't see the underlying error")
+	}
+
+	// Nothing at all to decode isn't a DecodeError.
+	if _, err := LoadBytes(nil); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+
+	var unsupported *UnsupportedTypeError
+	arg := &LoadArg{DisallowUnknownTypes: true}
+	if _, err := LoadBytesWith([]byte{0x04, 0x08, 0x5a}, arg); !errors.As(err, &unsupported) {
+		t.Errorf("got %v, want an *UnsupportedTypeError", err)
+	} else if want := (&UnsupportedTypeError{TypeByte: 'Z', Offset: 2}); !reflect.DeepEqual(unsupported, want) {
+		t.Errorf("got %#v, want %#v", unsupported, want)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    error
+	}{
+		{"Version", []byte{0x04, 0x07, 0x30}, ErrBadVersion},
+		{"Type byte", []byte{0x04, 0x08, 0x5a}, ErrUnsupportedType},
+		{"Truncated", []byte{0x04, 0x08, 0x22, 0x07, 0x61}, ErrTruncated},
+		{"Negative length", []byte{0x04, 0x08, 0x6c, 0x2b, 0xfa}, ErrBadLength},
+		{"Bignum sign", []byte{0x04, 0x08, 0x6c, 0x78, 0x06, 0x01, 0x00}, ErrBadBignum},
+		{"Object link", []byte{0x04, 0x08, 0x7b, 0x06, 0x40, 0x06}, ErrBadLink},
+		{"Negative object link", []byte{0x04, 0x08, 0x5b, 0x06, 0x40, 0xfa}, ErrBadLink},
+		// An object whose class name is a link to the second symbol.
+		{"Symlink", []byte{0x04, 0x08, 0x6f, 0x3b, 0x06, 0x00}, ErrBadSymlink},
+		// An object with 1 for the name of its class.
+		{"Class name", []byte{0x04, 0x08, 0x6f, 0x69, 0x06}, ErrExpectedSymbol},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			for _, doc := range []bool{false, true} {
+				arg := &LoadArg{DisallowUnknownTypes: true, Document: doc}
+				if _, err := LoadBytesWith(c.stream, arg); !errors.Is(err, c.err) {
+					t.Errorf("document %v: got %v, want %v", doc, err, c.err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	// [Money.new(1)]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x06, 0x6f, 0x3a, 0x0a, 0x4d,
+		0x6f, 0x6e, 0x65, 0x79, 0x06, 0x3a, 0x07, 0x40,
+		0x61, 0x69, 0x06,
+	}
+	arg := &LoadArg{ObjectDecoders: map[string]ObjectDecoder{
+		"Money": func(o RObject) (interface{}, error) {
+			panic("broken decoder")
+		},
+	}}
+
+	_, err := LoadBytesWith(stream, arg)
+	want := &DecodeError{Offset: 19, Err: errors.New("panic while decoding: broken decoder")}
+	if err == nil || err.Error() != want.Error() {
+		t.Errorf("LoadBytesWith: got %v, want %v", err, want)
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	d.LoadArg.ObjectDecoders = arg.ObjectDecoders
+	if _, err = d.Token(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v interface{}
+	if err = d.Decode(&v); err == nil || err.Error() != want.Error() {
+		t.Errorf("Decode: got %v, want %v", err, want)
+	}
+}
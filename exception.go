@@ -0,0 +1,79 @@
+package rbmarshal
+
+import "fmt"
+
+// RException is an exception. Ivars holds the instance variables it has on
+// top of the message and the backtrace.
+type RException struct {
+	Class     string
+	Message   string
+	Backtrace []string
+	Ivars     map[string]interface{}
+}
+
+// Exceptions keep their message and backtrace in the internal ivars mesg and
+// bt, whose names have no "@", and that is how they are told from other
+// objects of any class. Exceptions that DRb and job queues recreate keep them
+// in @message and @backtrace instead.
+func isException(o RObject) bool {
+	if _, ok := o.Ivars["mesg"]; ok {
+		return true
+	}
+	_, message := o.Ivars["@message"]
+	_, backtrace := o.Ivars["@backtrace"]
+
+	return message && backtrace
+}
+
+var exceptionIvars = map[string]bool{
+	"mesg":         true,
+	"bt":           true,
+	"bt_locations": true,
+	"@message":     true,
+	"@backtrace":   true,
+}
+
+// An exception without a message has its class name for one, like
+// Exception#message says.
+func decodeException(o RObject) (interface{}, error) {
+	e := RException{Class: o.Class, Message: o.Class}
+
+	mesg, ok := o.Ivars["mesg"]
+	if !ok {
+		mesg = o.Ivars["@message"]
+	}
+	if mesg != nil {
+		if e.Message, ok = stringValue(mesg); !ok {
+			return nil, fmt.Errorf("invalid %s message %v", o.Class, mesg)
+		}
+	}
+
+	bt, ok := o.Ivars["bt"]
+	if !ok {
+		bt = o.Ivars["@backtrace"]
+	}
+	if bt != nil {
+		lines, ok := bt.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s backtrace %v", o.Class, bt)
+		}
+		e.Backtrace = make([]string, len(lines))
+		for i, line := range lines {
+			if e.Backtrace[i], ok = stringValue(line); !ok {
+				return nil, fmt.Errorf("invalid %s backtrace %v", o.Class, bt)
+			}
+		}
+	}
+
+	for name, val := range o.Ivars {
+		if exceptionIvars[name] {
+			continue
+		}
+		if e.Ivars == nil {
+			e.Ivars = make(map[string]interface{})
+		}
+		e.Ivars[name] = val
+	}
+
+	return e, nil
+}
@@ -0,0 +1,72 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadException(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		data   interface{}
+	}{
+		{
+			// e = RuntimeError.new("boom"); e.set_backtrace(["a.rb:1"])
+			"Core exception",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x11, 0x52, 0x75, 0x6e,
+				0x74, 0x69, 0x6d, 0x65, 0x45, 0x72, 0x72, 0x6f,
+				0x72, 0x07, 0x3a, 0x09, 0x6d, 0x65, 0x73, 0x67,
+				0x49, 0x22, 0x09, 0x62, 0x6f, 0x6f, 0x6d, 0x06,
+				0x3a, 0x06, 0x45, 0x54, 0x3a, 0x07, 0x62, 0x74,
+				0x5b, 0x06, 0x49, 0x22, 0x0b, 0x61, 0x2e, 0x72,
+				0x62, 0x3a, 0x31, 0x06, 0x3b, 0x07, 0x54,
+			},
+			RException{"RuntimeError", "boom", []string{"a.rb:1"}, nil},
+		},
+		{
+			// RuntimeError.new
+			"Exception without a message",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x11, 0x52, 0x75, 0x6e,
+				0x74, 0x69, 0x6d, 0x65, 0x45, 0x72, 0x72, 0x6f,
+				0x72, 0x07, 0x3a, 0x09, 0x6d, 0x65, 0x73, 0x67,
+				0x30, 0x3a, 0x07, 0x62, 0x74, 0x30,
+			},
+			RException{"RuntimeError", "RuntimeError", nil, nil},
+		},
+		{
+			// An error recreated with @message, @backtrace and @code
+			"Recreated exception",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0c, 0x4d, 0x79, 0x45,
+				0x72, 0x72, 0x6f, 0x72, 0x08, 0x3a, 0x0d, 0x40,
+				0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x49,
+				0x22, 0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x3a, 0x0f, 0x40, 0x62, 0x61, 0x63, 0x6b, 0x74,
+				0x72, 0x61, 0x63, 0x65, 0x30, 0x3a, 0x0a, 0x40,
+				0x63, 0x6f, 0x64, 0x65, 0x69, 0x07,
+			},
+			RException{
+				"MyError", "x", nil, map[string]interface{}{"@code": 2},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
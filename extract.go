@@ -0,0 +1,211 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A pathStep is a step of a path passed to Extract, either a name or an
+// index.
+type pathStep struct {
+	name  string
+	index int
+	isKey bool
+}
+
+func (s pathStep) String() string {
+	if s.isKey {
+		return "." + s.name
+	}
+	return "[" + strconv.Itoa(s.index) + "]"
+}
+
+// Extract decodes the value at path in the dump in data, such as
+// "users[3].name". A name picks the value of a hash key, whether it is a
+// symbol or a string, the ivar of an object, with or without its leading @,
+// or the member of a struct. An index picks the element of an array, or the
+// value of an integer hash key. The empty path picks the whole value.
+//
+// The values along the path are streamed the way Decoder.Token does, and the
+// ones beside it are skipped, so only the value at the end is built. Like
+// streamed values, skipped ones can't be linked to from it.
+func Extract(data []byte, path string) (interface{}, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	for i, step := range steps {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var found bool
+		switch tok := tok.(type) {
+		case ArrayStart:
+			found, err = extractElem(d, tok.Len, step)
+		case HashStart:
+			found, err = extractPair(d, tok.Len, step)
+		case ObjectStart:
+			found, err = extractIvar(d, tok.Len, step)
+		default:
+			// Values that aren't streamed, like structs, were read whole.
+			return walkValue(tok, steps[i:], steps[:i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("%s not found", pathString(steps[:i+1]))
+		}
+	}
+
+	var v interface{}
+	if err = d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// extractElem skips to the element of an array that step picks.
+func extractElem(d *Decoder, size int, step pathStep) (bool, error) {
+	if step.isKey || step.index < 0 || step.index >= size {
+		return false, nil
+	}
+
+	for i := 0; i < step.index; i++ {
+		if err := d.Skip(); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// extractPair skips to the value of a hash that step picks.
+func extractPair(d *Decoder, size int, step pathStep) (bool, error) {
+	for i := 0; i < size; i++ {
+		var key interface{}
+		if err := d.Decode(&key); err != nil {
+			return false, err
+		}
+		if stepMatches(step, key) {
+			return true, nil
+		}
+		if err := d.Skip(); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// extractIvar skips to the ivar of an object that step picks.
+func extractIvar(d *Decoder, size int, step pathStep) (bool, error) {
+	if !step.isKey {
+		return false, nil
+	}
+
+	for i := 0; i < size; i++ {
+		var name string
+		if err := d.Decode(&name); err != nil {
+			return false, err
+		}
+		if name == "@"+strings.TrimPrefix(step.name, "@") {
+			return true, nil
+		}
+		if err := d.Skip(); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func stepMatches(step pathStep, key interface{}) bool {
+	if !step.isKey {
+		n, ok := intOf(key)
+		return ok && n == step.index
+	}
+
+	s, ok := textValue(key)
+	return ok && s == step.name
+}
+
+// walkValue follows steps through a decoded value. done are the steps taken
+// to get to v, for errors.
+func walkValue(v interface{}, steps, done []pathStep) (interface{}, error) {
+	for i, step := range steps {
+		var found bool
+		if a, ok := v.([]interface{}); ok {
+			if !step.isKey && step.index >= 0 && step.index < len(a) {
+				v, found = a[step.index], true
+			}
+		} else if pairs, ok := hashPairs(v); ok {
+			for _, p := range pairs {
+				if stepMatches(step, p.Key) {
+					v, found = p.Value, true
+					break
+				}
+			}
+		} else if fields, ok := fieldSource(v); ok && step.isKey {
+			v, found = fields.get(strings.TrimPrefix(step.name, "@"))
+		}
+
+		if !found {
+			path := append(append([]pathStep(nil), done...), steps[:i+1]...)
+			return nil, fmt.Errorf("%s not found", pathString(path))
+		}
+	}
+
+	return v, nil
+}
+
+// parsePath splits a path like "users[3].name" into its steps.
+func parsePath(path string) ([]pathStep, error) {
+	var steps []pathStep
+	for i := 0; i < len(path); {
+		switch {
+		case path[i] == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed [", path)
+			}
+			n, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", path, path[i+1:i+end])
+			}
+			steps = append(steps, pathStep{index: n})
+			i += end + 1
+		case path[i] == '.' && i > 0:
+			i++
+			fallthrough
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty name at %d", path, i)
+			}
+			steps = append(steps, pathStep{name: path[i : i+end], isKey: true})
+			i += end
+		}
+	}
+
+	return steps, nil
+}
+
+func pathString(steps []pathStep) string {
+	var b strings.Builder
+	for _, s := range steps {
+		b.WriteString(s.String())
+	}
+
+	return strings.TrimPrefix(b.String(), ".")
+}
@@ -0,0 +1,101 @@
+package rbmarshal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// {users: [{name: "ann"}, {name: "bob", "age" => 31}], "p" => Point.new(1),
+// ids: {1 => "one"}, pair: Pair.new(1)}
+var extractDump = []byte{
+	0x04, 0x08, 0x7b, 0x09, 0x3a, 0x0a, 0x75, 0x73,
+	0x65, 0x72, 0x73, 0x5b, 0x07, 0x7b, 0x06, 0x3a,
+	0x09, 0x6e, 0x61, 0x6d, 0x65, 0x49, 0x22, 0x08,
+	0x61, 0x6e, 0x6e, 0x06, 0x3a, 0x06, 0x45, 0x54,
+	0x7b, 0x07, 0x3b, 0x06, 0x49, 0x22, 0x08, 0x62,
+	0x6f, 0x62, 0x06, 0x3b, 0x07, 0x54, 0x49, 0x22,
+	0x08, 0x61, 0x67, 0x65, 0x06, 0x3b, 0x07, 0x54,
+	0x69, 0x24, 0x49, 0x22, 0x06, 0x70, 0x06, 0x3b,
+	0x07, 0x54, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+	0x6e, 0x74, 0x06, 0x3a, 0x07, 0x40, 0x78, 0x69,
+	0x06, 0x3a, 0x08, 0x69, 0x64, 0x73, 0x7b, 0x06,
+	0x69, 0x06, 0x49, 0x22, 0x08, 0x6f, 0x6e, 0x65,
+	0x06, 0x3b, 0x07, 0x54, 0x3a, 0x09, 0x70, 0x61,
+	0x69, 0x72, 0x53, 0x3a, 0x09, 0x50, 0x61, 0x69,
+	0x72, 0x06, 0x3a, 0x06, 0x61, 0x69, 0x06,
+}
+
+func TestExtract(t *testing.T) {
+	cases := []struct {
+		path string
+		want interface{}
+	}{
+		{"users[1].name", "bob"},
+		{"users[1].age", 31},
+		{"users[0]", map[string]interface{}{"name": "ann"}},
+		{"p.x", 1},
+		{"p.@x", 1},
+		{"ids[1]", "one"},
+		{"pair.a", 1},
+	}
+
+	for _, c := range cases {
+		got, err := Extract(extractDump, c.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.path, got, c.want)
+		}
+	}
+
+	whole, err := Extract(extractDump, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, _ := LoadBytes(extractDump); !reflect.DeepEqual(whole, want) {
+		t.Errorf("whole: got %v, want %v", whole, want)
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	cases := []struct {
+		path string
+		err  string
+	}{
+		{"users[2]", "users[2] not found"},
+		{"users[0].age", "users[0].age not found"},
+		{"users.name", "users.name not found"},
+		{"pair.b", "pair.b not found"},
+		{"ids[1].x", "ids[1].x not found"},
+		{"users[x]", `invalid path "users[x]": bad index "x"`},
+		{"users[1", `invalid path "users[1": unclosed [`},
+		{"users..name", `invalid path "users..name": empty name at 6`},
+	}
+
+	for _, c := range cases {
+		_, err := Extract(extractDump, c.path)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: got %v, want %s", c.path, err, c.err)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	steps, err := parsePath("[0].users[3][1].name")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []pathStep{
+		{index: 0},
+		{name: "users", isKey: true},
+		{index: 3},
+		{index: 1},
+		{name: "name", isKey: true},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got %+v, want %+v", steps, want)
+	}
+}
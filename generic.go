@@ -0,0 +1,29 @@
+package rbmarshal
+
+// Decode decodes the dump in data into a value of type T, the way Unmarshal
+// does, so that the result needs no type assertion:
+//
+//	counts, err := rbmarshal.Decode[map[string]int](data)
+//
+// On error, the zero value of T is returned.
+func Decode[T any](data []byte) (T, error) {
+	var v T
+	if err := Unmarshal(data, &v); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return v, nil
+}
+
+// DecodeValue is like Decode but reads the next value from d, the way
+// d.Decode does. At the end of the stream it returns io.EOF.
+func DecodeValue[T any](d *Decoder) (T, error) {
+	var v T
+	if err := d.Decode(&v); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return v, nil
+}
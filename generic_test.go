@@ -0,0 +1,70 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeGeneric(t *testing.T) {
+	// {"a" => 1, "b" => 2}
+	counts, err := Decode[map[string]int]([]byte{
+		0x04, 0x08, 0x7b, 0x07, 0x49, 0x22, 0x06, 0x61,
+		0x06, 0x3a, 0x06, 0x45, 0x54, 0x69, 0x06, 0x49,
+		0x22, 0x06, 0x62, 0x06, 0x3b, 0x00, 0x54, 0x69,
+		0x07,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"a": 1, "b": 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+
+	// [{name: "x"}] into a slice of structs.
+	type item struct{ Name string }
+	items, err := Decode[[]item]([]byte{
+		0x04, 0x08, 0x5b, 0x06, 0x7b, 0x06, 0x3a, 0x09,
+		0x6e, 0x61, 0x6d, 0x65, 0x49, 0x22, 0x06, 0x78,
+		0x06, 0x3a, 0x06, 0x45, 0x54,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []item{{"x"}}; !reflect.DeepEqual(items, want) {
+		t.Errorf("got %v, want %v", items, want)
+	}
+
+	// [1, "a"] doesn't fit a []int.
+	ints, err := Decode[[]int]([]byte{
+		0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x49, 0x22,
+		0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54,
+	})
+	if err == nil || ints != nil {
+		t.Errorf("got %v and %v, want an error and nil", ints, err)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	// 1, then 2.
+	d := NewDecoder(bytes.NewReader([]byte{
+		0x04, 0x08, 0x69, 0x06,
+		0x04, 0x08, 0x69, 0x07,
+	}))
+
+	var got []int8
+	for {
+		n, err := DecodeValue[int8](d)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, n)
+	}
+	if want := []int8{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
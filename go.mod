@@ -0,0 +1,8 @@
+module github.com/kyrylo/rbmarshal
+
+go 1.18
+
+require (
+	github.com/google/go-cmp v0.6.0
+	golang.org/x/text v0.14.0
+)
@@ -0,0 +1,46 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// afInet is Socket::AF_INET. AF_INET6 differs from one platform to another,
+// so every other family is taken to be IPv6.
+const afInet = 2
+
+// IPAddrs are dumped as objects with the address as an integer in @addr, and
+// decode to netip.Addr. The netmask in @mask_addr is dropped.
+func decodeIPAddr(o RObject) (interface{}, error) {
+	family, ok := intOf(o.Ivars["@family"])
+	if !ok {
+		return nil, fmt.Errorf("invalid IPAddr ivars %v", o.Ivars)
+	}
+	n, ok := toBigInt(o.Ivars["@addr"])
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("invalid IPAddr ivars %v", o.Ivars)
+	}
+
+	var addr netip.Addr
+	if family == afInet {
+		if n.BitLen() > 32 {
+			return nil, fmt.Errorf("invalid IPAddr ivars %v", o.Ivars)
+		}
+		var b [4]byte
+		n.FillBytes(b[:])
+		addr = netip.AddrFrom4(b)
+	} else {
+		if n.BitLen() > 128 {
+			return nil, fmt.Errorf("invalid IPAddr ivars %v", o.Ivars)
+		}
+		var b [16]byte
+		n.FillBytes(b[:])
+		addr = netip.AddrFrom16(b)
+		if zone, ok := stringValue(o.Ivars["@zone_id"]); ok {
+			addr = addr.WithZone(strings.TrimPrefix(zone, "%"))
+		}
+	}
+
+	return addr, nil
+}
@@ -0,0 +1,63 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestLoadIPAddr(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		want   netip.Addr
+	}{
+		{
+			// IPAddr.new("192.168.1.10")
+			"IPv4",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0b, 0x49, 0x50, 0x41,
+				0x64, 0x64, 0x72, 0x08, 0x3a, 0x0a, 0x40, 0x61,
+				0x64, 0x64, 0x72, 0x6c, 0x2b, 0x07, 0x0a, 0x01,
+				0xa8, 0xc0, 0x3a, 0x0f, 0x40, 0x6d, 0x61, 0x73,
+				0x6b, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x6c, 0x2b,
+				0x07, 0xff, 0xff, 0xff, 0xff, 0x3a, 0x0c, 0x40,
+				0x66, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x69, 0x07,
+			},
+			netip.MustParseAddr("192.168.1.10"),
+		},
+		{
+			// IPAddr.new("fe80::1%eth0")
+			"IPv6 with a zone",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0b, 0x49, 0x50, 0x41,
+				0x64, 0x64, 0x72, 0x09, 0x3a, 0x0a, 0x40, 0x61,
+				0x64, 0x64, 0x72, 0x6c, 0x2b, 0x0d, 0x01, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x80, 0xfe, 0x3a, 0x0f,
+				0x40, 0x6d, 0x61, 0x73, 0x6b, 0x5f, 0x61, 0x64,
+				0x64, 0x72, 0x6c, 0x2b, 0x0d, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0x3a, 0x0c, 0x40,
+				0x66, 0x61, 0x6d, 0x69, 0x6c, 0x79, 0x69, 0x0f,
+				0x3a, 0x0d, 0x40, 0x7a, 0x6f, 0x6e, 0x65, 0x5f,
+				0x69, 0x64, 0x49, 0x22, 0x0a, 0x25, 0x65, 0x74,
+				0x68, 0x30, 0x06, 0x3a, 0x06, 0x45, 0x54,
+			},
+			netip.MustParseAddr("fe80::1%eth0"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := Load(bufio.NewReader(bytes.NewReader(c.stream)))
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if data != c.want {
+				t.Errorf("data: got %v, want %v", data, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// StreamToJSON converts Marshal data from r into JSON written to w. Arrays
+// and hashes are written out as they are read rather than being built in
+// memory first, so even huge dumps convert with little overhead. Symbols
+// become JSON strings and integers of any size become JSON numbers. Hash keys
+// are stringified as Ruby's to_json would do it.
+//
+// Scalars still go into the object table, because links may refer to them
+// later in the stream. Links to arrays and hashes can't be followed and fail.
+// Errors are DecodeErrors, like those of Load.
+func StreamToJSON(r *bufio.Reader, w io.Writer) error {
+	return StreamToJSONWith(r, w, new(LoadArg))
+}
+
+// StreamToJSONWith is like StreamToJSON but decodes according to the options
+// set on arg, such as MaxDepth or MaxCollectionLen. Hash keys are stringified
+// the way to_json does whatever JSONCompatKeys is.
+func StreamToJSONWith(r *bufio.Reader, w io.Writer, arg *LoadArg) (err error) {
+	streamArg := *arg
+	streamArg.JSONCompatKeys = true
+	arg = &streamArg
+	defer recoverPanic(&err, arg)
+
+	resetLoadArg(arg)
+	if err := validateVersion(r, arg); err != nil {
+		return decodeError(err, arg)
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := streamJSON(r, arg, bw); err != nil {
+		return decodeError(err, arg)
+	}
+	if arg.DisallowTrailingData {
+		if err := checkTrailing(r, arg); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func streamJSON(r byteReader, arg *LoadArg, w *bufio.Writer) error {
+	bytes, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	switch bytes[0] {
+	case typeArray:
+		return streamJSONArray(r, arg, w)
+	case typeHash:
+		return streamJSONHash(r, arg, w)
+	default:
+		v, err := read(r, arg)
+		if err != nil {
+			return err
+		}
+		if v == nil && bytes[0] == typeObjlink {
+			return errors.New("cannot stream a link to an array or a hash")
+		}
+
+		return writeJSON(w, v)
+	}
+}
+
+func streamJSONArray(r byteReader, arg *LoadArg, w *bufio.Writer) error {
+	// Nested arrays and hashes count against MaxDepth, like in read.
+	if err := enterValue(arg); err != nil {
+		return err
+	}
+	defer leaveValue(arg)
+
+	// Skip the typeArray byte.
+	if _, err := readByte(r, arg); err != nil {
+		return err
+	}
+
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return err
+	}
+
+	// Arrays aren't kept, but they still occupy a slot in the object table.
+	arg.Objects = append(arg.Objects, nil)
+
+	w.WriteByte('[')
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		if err = streamJSON(r, arg, w); err != nil {
+			return inPath(err, indexStep(i))
+		}
+	}
+	w.WriteByte(']')
+
+	return nil
+}
+
+func streamJSONHash(r byteReader, arg *LoadArg, w *bufio.Writer) error {
+	// Nested arrays and hashes count against MaxDepth, like in read.
+	if err := enterValue(arg); err != nil {
+		return err
+	}
+	defer leaveValue(arg)
+
+	// Skip the typeHash byte.
+	if _, err := readByte(r, arg); err != nil {
+		return err
+	}
+
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return err
+	}
+
+	// Hashes aren't kept, but they still occupy a slot in the object table.
+	arg.Objects = append(arg.Objects, nil)
+
+	w.WriteByte('{')
+	for i := 0; i < size; i++ {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+
+		key, err := read(r, arg)
+		if err != nil {
+			return inPath(err, hashKeyStep(i))
+		}
+		if err = writeJSON(w, hashKey(key, arg)); err != nil {
+			return err
+		}
+		w.WriteByte(':')
+
+		if err = streamJSON(r, arg, w); err != nil {
+			return inPath(err, keyStep(key))
+		}
+	}
+	w.WriteByte('}')
+
+	return nil
+}
+
+func writeJSON(w *bufio.Writer, v interface{}) error {
+	if x, ok := v.(*regexp.Regexp); ok {
+		v = x.String()
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+
+	return err
+}
@@ -0,0 +1,170 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStreamToJSON(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+	}{
+		{
+			"Array of integers",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x69, 0x06, 0x69, 0x07,
+				0x69, 0x8,
+			},
+		},
+		{
+			"String",
+			[]byte{
+				0x04, 0x08, 0x49, 0x22, 0x07, 0x48, 0x69, 0x06,
+				0x3A, 0x06, 0x45, 0x54,
+			},
+		},
+		{
+			"Nested hash with symbols, bignum and link",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x09, 0x3a, 0x08, 0x66, 0x6f,
+				0x6f, 0x69, 0x06, 0x3a, 0x08, 0x62, 0x61, 0x72,
+				0x49, 0x22, 0x08, 0x62, 0x61, 0x7a, 0x06, 0x3a,
+				0x06, 0x45, 0x54, 0x3a, 0x0a, 0x61, 0x72, 0x72,
+				0x61, 0x79, 0x5b, 0x09, 0x69, 0x06, 0x69, 0x07,
+				0x5b, 0x07, 0x54, 0x30, 0x40, 0x06, 0x3a, 0x09,
+				0x68, 0x61, 0x73, 0x68, 0x7b, 0x07, 0x3a, 0x0a,
+				0x62, 0x69, 0x6e, 0x67, 0x6f, 0x66, 0x08, 0x31,
+				0x2e, 0x32, 0x3a, 0x08, 0x62, 0x69, 0x67, 0x6c,
+				0x2b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var streamed bytes.Buffer
+			err := StreamToJSON(bufio.NewReader(bytes.NewReader(c.stream)), &streamed)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+
+			data, err := Load(bufio.NewReader(bytes.NewReader(c.stream)))
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			baseline, err := json.Marshal(data)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+
+			var got, want interface{}
+			if err = json.Unmarshal(streamed.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", streamed.Bytes(), err)
+			}
+			if err = json.Unmarshal(baseline, &want); err != nil {
+				t.Fatalf("invalid JSON %s: %v", baseline, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("data: got %s, want %s", streamed.Bytes(), baseline)
+			}
+		})
+	}
+}
+
+func TestStreamToJSONContainerLink(t *testing.T) {
+	// a = [1]; [a, a]
+	stream := []byte{0x04, 0x08, 0x5b, 0x07, 0x5b, 0x06, 0x69, 0x06, 0x40, 0x06}
+
+	var buf bytes.Buffer
+	err := StreamToJSON(bufio.NewReader(bytes.NewReader(stream)), &buf)
+	if err == nil {
+		t.Errorf("expected an error, got %s", buf.Bytes())
+	}
+}
+
+func TestStreamToJSONErrors(t *testing.T) {
+	// [Money.new(1)]
+	money := []byte{
+		0x04, 0x08, 0x5b, 0x06, 0x6f, 0x3a, 0x0a, 0x4d,
+		0x6f, 0x6e, 0x65, 0x79, 0x06, 0x3a, 0x07, 0x40,
+		0x61, 0x69, 0x06,
+	}
+	broken := map[string]ObjectDecoder{
+		"Money": func(o RObject) (interface{}, error) {
+			panic("broken decoder")
+		},
+	}
+
+	cases := []struct {
+		desc   string
+		stream []byte
+		arg    *LoadArg
+		err    error
+		want   *DecodeError
+	}{
+		{
+			// [[[1]]]
+			"Depth",
+			[]byte{0x04, 0x08, 0x5b, 0x06, 0x5b, 0x06, 0x5b, 0x06, 0x69, 0x06},
+			&LoadArg{MaxDepth: 2},
+			ErrDepthExceeded,
+			&DecodeError{Offset: 6, Path: "[0][0]"},
+		},
+		{
+			// [1, 2]
+			"Length",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x69, 0x07},
+			&LoadArg{MaxCollectionLen: 1},
+			ErrLengthExceeded,
+			&DecodeError{Offset: 3},
+		},
+		{
+			// {a: [1, "b"]}, cut short.
+			"Truncated",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x5b,
+				0x07, 0x69, 0x06, 0x22,
+			},
+			new(LoadArg),
+			ErrTruncated,
+			&DecodeError{Offset: 12, Path: "a[1]"},
+		},
+		{
+			// {a: {<unknown>=>1}}
+			"Hash key",
+			[]byte{0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x7b, 0x06, 0x5a},
+			&LoadArg{DisallowUnknownTypes: true},
+			ErrUnsupportedType,
+			&DecodeError{Offset: 9, Path: "a{0}"},
+		},
+		{
+			"Panic",
+			money,
+			&LoadArg{ObjectDecoders: broken},
+			nil,
+			&DecodeError{Offset: 19},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := StreamToJSONWith(bufio.NewReader(bytes.NewReader(c.stream)), &buf, c.arg)
+			var got *DecodeError
+			if !errors.As(err, &got) {
+				t.Fatalf("got %v, want a *DecodeError", err)
+			}
+			if c.err != nil && !errors.Is(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+			if got.Offset != c.want.Offset || got.Path != c.want.Path {
+				t.Errorf("got offset %d, path %q, want %d, %q", got.Offset, got.Path, c.want.Offset, c.want.Path)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package rbmarshal
+
+// Marshaler is implemented by types that choose how they are dumped.
+// MarshalRuby returns the value to dump in their place, which may be any
+// value Dump supports, like an RObject or a UserDef of a class of their own.
+type Marshaler interface {
+	MarshalRuby() (interface{}, error)
+}
+
+// Unmarshaler is implemented by types that choose how they are decoded.
+// UnmarshalRuby is called with the value as Load returns it, and must copy
+// whatever it wants to keep of it.
+type Unmarshaler interface {
+	UnmarshalRuby(v interface{}) error
+}
@@ -0,0 +1,71 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type rubyMoney struct {
+	Cents    int
+	Currency string
+}
+
+func (m rubyMoney) MarshalRuby() (interface{}, error) {
+	return UserMarshal{"Money", makeSlice(m.Cents, m.Currency)}, nil
+}
+
+func (m *rubyMoney) UnmarshalRuby(v interface{}) error {
+	u, ok := v.(UserMarshal)
+	if !ok || u.Class != "Money" {
+		return fmt.Errorf("not a Money: %v", v)
+	}
+	data, ok := u.Data.([]interface{})
+	if !ok || len(data) != 2 {
+		return fmt.Errorf("invalid Money data %v", u.Data)
+	}
+	m.Cents, _ = data[0].(int)
+	m.Currency, _ = data[1].(string)
+
+	return nil
+}
+
+func TestMarshaler(t *testing.T) {
+	// Money.new(150, "USD"), which dumps [150, "USD"] with marshal_dump
+	want := []byte{
+		0x04, 0x08, 0x55, 0x3a, 0x0a, 0x4d, 0x6f, 0x6e,
+		0x65, 0x79, 0x5b, 0x07, 0x69, 0x01, 0x96, 0x49,
+		0x22, 0x08, 0x55, 0x53, 0x44, 0x06, 0x3a, 0x06,
+		0x45, 0x54,
+	}
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, rubyMoney{150, "USD"}); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("stream: got %x, want %x", buf.Bytes(), want)
+	}
+
+	var m rubyMoney
+	if err := NewDecoder(&buf).Decode(&m); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if m != (rubyMoney{150, "USD"}) {
+		t.Errorf("data: got %v, want %v", m, rubyMoney{150, "USD"})
+	}
+}
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalRuby() (interface{}, error) {
+	return nil, errors.New("cannot marshal")
+}
+
+func TestMarshalerError(t *testing.T) {
+	err := Dump(new(bytes.Buffer), makeSlice(failingMarshaler{}))
+	if err == nil || err.Error() != "cannot marshal" {
+		t.Errorf("error: got %v, want %q", err, "cannot marshal")
+	}
+}
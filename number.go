@@ -0,0 +1,78 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Number is an integer in decimal, as integers decode with LoadArg.UseNumber.
+// Like json.Number, it keeps integers of any size exact until the caller
+// picks a type for them. Dump writes it back as an integer.
+type Number string
+
+// String returns the digits of n.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns n as an int64, failing if it doesn't fit.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Float64 returns n as a float64, rounded if needed.
+func (n Number) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// BigInt returns n as a *big.Int.
+func (n Number) BigInt() (*big.Int, error) {
+	b, ok := new(big.Int).SetString(string(n), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", string(n))
+	}
+
+	return b, nil
+}
+
+// integerValue returns an integer read from the stream, an int or a
+// *big.Int, in the form the options of arg ask for.
+func integerValue(v interface{}, arg *LoadArg) interface{} {
+	switch v := v.(type) {
+	case int:
+		if arg.UseNumber {
+			return Number(strconv.Itoa(v))
+		}
+		if arg.Int64s {
+			return int64(v)
+		}
+	case *big.Int:
+		if arg.UseNumber {
+			return Number(v.String())
+		}
+		if arg.Int64s && !arg.BigInts && v.IsInt64() {
+			return v.Int64()
+		}
+	}
+
+	return v
+}
+
+// intOf returns the value of an integer that fits in an int, whichever form
+// it was decoded to.
+func intOf(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		if int64(int(v)) == v {
+			return int(v), true
+		}
+	case Number:
+		n, err := strconv.Atoi(string(v))
+		return n, err == nil
+	}
+
+	return 0, false
+}
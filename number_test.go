@@ -0,0 +1,112 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestLoadNumbers(t *testing.T) {
+	// [1, 2**40, 2**70, 1.5]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x09, 0x69, 0x06, 0x6c, 0x2b,
+		0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x6c,
+		0x2b, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x40, 0x00, 0x66, 0x08, 0x31, 0x2e,
+		0x35,
+	}
+	huge, _ := new(big.Int).SetString("1180591620717411303424", 10)
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data interface{}
+	}{
+		{
+			"Int64s",
+			&LoadArg{Int64s: true},
+			makeSlice(int64(1), int64(1<<40), huge, 1.5),
+		},
+		{
+			"Int64s and BigInts",
+			&LoadArg{Int64s: true, BigInts: true},
+			makeSlice(int64(1), big.NewInt(1<<40), huge, 1.5),
+		},
+		{
+			"UseNumber",
+			&LoadArg{UseNumber: true, Int64s: true},
+			makeSlice(Number("1"), Number("1099511627776"), Number(huge.String()), 1.5),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := LoadWith(bytes.NewReader(stream), c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %#v, want %#v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestNumberConversions(t *testing.T) {
+	n := Number("1099511627776")
+	if i, err := n.Int64(); err != nil || i != 1<<40 {
+		t.Errorf("Int64: got %v and %v", i, err)
+	}
+	if f, err := n.Float64(); err != nil || f != 1<<40 {
+		t.Errorf("Float64: got %v and %v", f, err)
+	}
+	if b, err := n.BigInt(); err != nil || b.Int64() != 1<<40 {
+		t.Errorf("BigInt: got %v and %v", b, err)
+	}
+	if _, err := Number("x").BigInt(); err == nil {
+		t.Error("BigInt of x didn't fail")
+	}
+
+	// Numbers fill numeric fields and dump back as integers.
+	var buf bytes.Buffer
+	if err := Dump(&buf, []interface{}{n, Number("-3")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v struct {
+		A []int64
+	}
+	err := UnmarshalWith(append([]byte{0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61}, buf.Bytes()[2:]...), &v, &LoadArg{UseNumber: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int64{1 << 40, -3}; !reflect.DeepEqual(v.A, want) {
+		t.Errorf("got %v, want %v", v.A, want)
+	}
+}
+
+func TestNumbersInCoreClasses(t *testing.T) {
+	// Time.at(0, 5, :nsec).utc, which keeps nano_num and nano_den.
+	stream := []byte{
+		0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+		0x6d, 0x65, 0x0d, 0x00, 0x00, 0x18, 0xc0, 0x00,
+		0x00, 0x00, 0x00, 0x07, 0x3a, 0x0d, 0x6e, 0x61,
+		0x6e, 0x6f, 0x5f, 0x6e, 0x75, 0x6d, 0x69, 0x0a,
+		0x3a, 0x0d, 0x6e, 0x61, 0x6e, 0x6f, 0x5f, 0x64,
+		0x65, 0x6e, 0x69, 0x06,
+	}
+
+	want, err := Load(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, arg := range []*LoadArg{{Int64s: true}, {UseNumber: true}} {
+		got, err := LoadWith(bytes.NewReader(stream), arg)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error: %v", arg, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got %v, want %v", arg, got, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rbmarshal
+
+import "fmt"
+
+// decodeOpenStruct turns the output of OpenStruct#marshal_dump, the table of
+// its attributes keyed by symbols, into a map keyed by attribute name.
+func decodeOpenStruct(u UserMarshal) (interface{}, error) {
+	switch table := u.Data.(type) {
+	case map[string]interface{}:
+		return table, nil
+	case KeyedHash:
+		return table.Values, nil
+	case *OrderedHash:
+		attrs := make(map[string]interface{}, len(table.Pairs))
+		for _, p := range table.Pairs {
+			sym, ok := p.Key.(Symbol)
+			if !ok {
+				return nil, fmt.Errorf("invalid OpenStruct data %v", u.Data)
+			}
+			attrs[string(sym)] = p.Value
+		}
+
+		return attrs, nil
+	case map[interface{}]interface{}:
+		attrs := make(map[string]interface{}, len(table))
+		for name, val := range table {
+			sym, ok := name.(Symbol)
+			if !ok {
+				return nil, fmt.Errorf("invalid OpenStruct data %v", u.Data)
+			}
+			attrs[string(sym)] = val
+		}
+
+		return attrs, nil
+	}
+
+	return nil, fmt.Errorf("invalid OpenStruct data %v", u.Data)
+}
@@ -0,0 +1,30 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadOpenStruct(t *testing.T) {
+	// OpenStruct.new(name: "x", age: 3)
+	stream := []byte{
+		0x04, 0x08, 0x55, 0x3a, 0x0f, 0x4f, 0x70, 0x65,
+		0x6e, 0x53, 0x74, 0x72, 0x75, 0x63, 0x74, 0x7b,
+		0x07, 0x3a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x49,
+		0x22, 0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+		0x3a, 0x08, 0x61, 0x67, 0x65, 0x69, 0x08,
+	}
+	want := map[string]interface{}{"name": "x", "age": 3}
+
+	for _, arg := range []*LoadArg{{}, {KeepKeys: true}, {AnyKeys: true}, {OrderedHashes: true}} {
+		data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+		if err != nil {
+			t.Fatalf("unexpected error: '%q'", err)
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("data: got %v, want %v", data, want)
+		}
+	}
+}
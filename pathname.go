@@ -0,0 +1,13 @@
+package rbmarshal
+
+import "fmt"
+
+// Pathnames are dumped as objects with the path in @path, and decode to it.
+func decodePathname(o RObject) (interface{}, error) {
+	path, ok := stringValue(o.Ivars["@path"])
+	if !ok {
+		return nil, fmt.Errorf("invalid Pathname ivars %v", o.Ivars)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,26 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestLoadPathname(t *testing.T) {
+	// Pathname.new("/tmp/a b")
+	stream := []byte{
+		0x04, 0x08, 0x6f, 0x3a, 0x0d, 0x50, 0x61, 0x74,
+		0x68, 0x6e, 0x61, 0x6d, 0x65, 0x06, 0x3a, 0x0a,
+		0x40, 0x70, 0x61, 0x74, 0x68, 0x49, 0x22, 0x0d,
+		0x2f, 0x74, 0x6d, 0x70, 0x2f, 0x61, 0x20, 0x62,
+		0x06, 0x3a, 0x06, 0x45, 0x54,
+	}
+
+	data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if data != "/tmp/a b" {
+		t.Errorf("data: got %v, want %q", data, "/tmp/a b")
+	}
+}
@@ -0,0 +1,43 @@
+package rbmarshal
+
+import "sync"
+
+// A Pool decodes independent blobs with load arguments borrowed from a shared
+// pool, which saves allocations in servers that decode many small payloads.
+// The zero value is ready to use. A Pool is safe for concurrent use.
+type Pool struct {
+	args sync.Pool
+}
+
+// Load decodes a single dump from data, like a new Decoder would, but reads
+// data in place the way LoadBytes does. Byte slices in the result share memory
+// with data, which must not change while they are in use.
+func (p *Pool) Load(data []byte) (interface{}, error) {
+	arg, ok := p.args.Get().(*LoadArg)
+	if !ok {
+		arg = &LoadArg{DisallowUnknownTypes: true}
+	}
+
+	v, err := load(&cursor{data: data}, arg)
+
+	// Don't let the pooled arguments keep data alive through the symbols and
+	// objects of the last dump.
+	clearTables(arg)
+	p.args.Put(arg)
+
+	return v, err
+}
+
+// clearTables empties the symbol and object tables of arg, dropping the
+// references they hold but keeping their capacity.
+func clearTables(arg *LoadArg) {
+	for i := range arg.Symbols {
+		arg.Symbols[i] = ""
+	}
+	arg.Symbols = arg.Symbols[:0]
+
+	for i := range arg.Objects {
+		arg.Objects[i] = nil
+	}
+	arg.Objects = arg.Objects[:0]
+}
@@ -0,0 +1,113 @@
+package rbmarshal
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPoolLoad(t *testing.T) {
+	blobs := []struct {
+		stream []byte
+		data   interface{}
+	}{
+		// [:a, :a]
+		{
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0x61, 0x3b, 0x00},
+			makeSlice(Symbol("a"), Symbol("a")),
+		},
+		// [:b, :b]
+		{
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0x62, 0x3b, 0x00},
+			makeSlice(Symbol("b"), Symbol("b")),
+		},
+		// s = "x"; [s, s]
+		{
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x78,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06,
+			},
+			makeSlice("x", "x"),
+		},
+		// {:c=>1}
+		{
+			[]byte{0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x63, 0x69, 0x06},
+			map[string]interface{}{"c": 1},
+		},
+	}
+
+	var p Pool
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 500; i++ {
+				blob := blobs[(g+i)%len(blobs)]
+				data, err := p.Load(blob.stream)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if !reflect.DeepEqual(data, blob.data) {
+					t.Errorf("data: got %v, want %v", data, blob.data)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestPoolLoadClearsTables(t *testing.T) {
+	var p Pool
+	// s = "x"; [:a, s, s]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x08, 0x3a, 0x06, 0x61, 0x49,
+		0x22, 0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+		0x40, 0x06,
+	}
+	if _, err := p.Load(stream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	arg, ok := p.args.Get().(*LoadArg)
+	if !ok {
+		t.Skip("the pool dropped the arguments")
+	}
+	if len(arg.Symbols) != 0 || len(arg.Objects) != 0 {
+		t.Errorf("got %d symbols and %d objects, want none", len(arg.Symbols), len(arg.Objects))
+	}
+	for _, s := range arg.Symbols[:cap(arg.Symbols)] {
+		if s != "" {
+			t.Errorf("symbol %q is still referenced", s)
+		}
+	}
+	for _, o := range arg.Objects[:cap(arg.Objects)] {
+		if o != nil {
+			t.Errorf("object %v is still referenced", o)
+		}
+	}
+}
+
+func TestPoolLoadSharesData(t *testing.T) {
+	var p Pool
+	// Money._load("12")
+	stream := []byte{
+		0x04, 0x08, 0x75, 0x3a, 0x0a, 0x4d, 0x6f, 0x6e,
+		0x65, 0x79, 0x07, 0x31, 0x32,
+	}
+
+	data, err := p.Load(stream)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, ok := data.(UserDef)
+	if !ok || string(u.Data) != "12" {
+		t.Fatalf("got %v, want Money with 12", data)
+	}
+	if &u.Data[0] != &stream[11] {
+		t.Errorf("the data of the user type was copied")
+	}
+}
@@ -0,0 +1,22 @@
+package rbmarshal
+
+import "fmt"
+
+// Range is a Ruby range. Begin is nil for a beginless range, and End for an
+// endless one.
+type Range struct {
+	Begin     interface{}
+	End       interface{}
+	Exclusive bool
+}
+
+// Ranges are dumped as objects with the internal ivars excl, begin and end,
+// whose names have no "@".
+func decodeRange(o RObject) (interface{}, error) {
+	excl, ok := o.Ivars["excl"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("invalid Range ivars %v", o.Ivars)
+	}
+
+	return Range{Begin: o.Ivars["begin"], End: o.Ivars["end"], Exclusive: excl}, nil
+}
@@ -0,0 +1,87 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadRange(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    string
+		data   interface{}
+	}{
+		{
+			// 1..10
+			"Inclusive",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x52, 0x61, 0x6e,
+				0x67, 0x65, 0x08, 0x3a, 0x09, 0x65, 0x78, 0x63,
+				0x6c, 0x46, 0x3a, 0x0a, 0x62, 0x65, 0x67, 0x69,
+				0x6e, 0x69, 0x06, 0x3a, 0x08, 0x65, 0x6e, 0x64,
+				0x69, 0x0f,
+			},
+			"",
+			Range{1, 10, false},
+		},
+		{
+			// "a"..."z"
+			"Exclusive",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x52, 0x61, 0x6e,
+				0x67, 0x65, 0x08, 0x3a, 0x09, 0x65, 0x78, 0x63,
+				0x6c, 0x54, 0x3a, 0x0a, 0x62, 0x65, 0x67, 0x69,
+				0x6e, 0x49, 0x22, 0x06, 0x61, 0x06, 0x3a, 0x06,
+				0x45, 0x54, 0x3a, 0x08, 0x65, 0x6e, 0x64, 0x49,
+				0x22, 0x06, 0x7a, 0x06, 0x3b, 0x08, 0x54,
+			},
+			"",
+			Range{"a", "z", true},
+		},
+		{
+			// r = 1..; [r, r]
+			"Endless with a link",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x6f, 0x3a, 0x0a, 0x52,
+				0x61, 0x6e, 0x67, 0x65, 0x08, 0x3a, 0x09, 0x65,
+				0x78, 0x63, 0x6c, 0x46, 0x3a, 0x0a, 0x62, 0x65,
+				0x67, 0x69, 0x6e, 0x69, 0x06, 0x3a, 0x08, 0x65,
+				0x6e, 0x64, 0x30, 0x40, 0x06,
+			},
+			"",
+			makeSlice(Range{1, nil, false}, Range{1, nil, false}),
+		},
+		{
+			"Missing excl",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x52, 0x61, 0x6e,
+				0x67, 0x65, 0x00,
+			},
+			"invalid Range ivars map[] at offset 11",
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("error: got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// decodeRational turns the output of Rational#marshal_dump, which is
+// [numerator, denominator], into a *big.Rat.
+func decodeRational(u UserMarshal) (interface{}, error) {
+	a, ok := u.Data.([]interface{})
+	if !ok || len(a) != 2 {
+		return nil, fmt.Errorf("invalid Rational data %v", u.Data)
+	}
+
+	num, ok := toBigInt(a[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid Rational data %v", u.Data)
+	}
+	den, ok := toBigInt(a[1])
+	if !ok || den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Rational data %v", u.Data)
+	}
+
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// decodeComplex turns the output of Complex#marshal_dump, which is [real,
+// imaginary], into a complex128. The parts may be of any real type Ruby has,
+// and lose precision the way a conversion to float64 does.
+func decodeComplex(u UserMarshal) (interface{}, error) {
+	a, ok := u.Data.([]interface{})
+	if !ok || len(a) != 2 {
+		return nil, fmt.Errorf("invalid Complex data %v", u.Data)
+	}
+
+	re, ok := toFloat(a[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid Complex data %v", u.Data)
+	}
+	im, ok := toFloat(a[1])
+	if !ok {
+		return nil, fmt.Errorf("invalid Complex data %v", u.Data)
+	}
+
+	return complex(re, im), nil
+}
+
+func toBigInt(v interface{}) (*big.Int, bool) {
+	switch v := v.(type) {
+	case int:
+		return big.NewInt(int64(v)), true
+	case int64:
+		return big.NewInt(v), true
+	case Number:
+		n, err := v.BigInt()
+		return n, err == nil
+	case *big.Int:
+		return v, true
+	}
+
+	return nil, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f, true
+	}
+
+	return 0, false
+}
+
+// rationalUserMarshal turns r into what Rational#marshal_dump returns. The
+// parts are copies, so that they aren't linked to other uses of r.
+func rationalUserMarshal(r *big.Rat) UserMarshal {
+	num := new(big.Int).Set(r.Num())
+	den := new(big.Int).Set(r.Denom())
+
+	return UserMarshal{Class: "Rational", Data: []interface{}{num, den}}
+}
+
+// complexUserMarshal turns c into what Complex#marshal_dump returns for a
+// complex with float parts.
+func complexUserMarshal(c complex128) UserMarshal {
+	return UserMarshal{Class: "Complex", Data: []interface{}{real(c), imag(c)}}
+}
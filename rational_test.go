@@ -0,0 +1,207 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestLoadRational(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    string
+		data   *big.Rat
+	}{
+		{
+			// Rational(1, 3)
+			"Fixnums",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x52, 0x61, 0x74,
+				0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07, 0x69,
+				0x06, 0x69, 0x08,
+			},
+			"",
+			big.NewRat(1, 3),
+		},
+		{
+			// Rational(2**64, 3)
+			"Bignum",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x52, 0x61, 0x74,
+				0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07, 0x6c,
+				0x2b, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x01, 0x00, 0x69, 0x08,
+			},
+			"",
+			new(big.Rat).SetFrac(
+				new(big.Int).Lsh(big.NewInt(1), 64),
+				big.NewInt(3),
+			),
+		},
+		{
+			"Zero denominator",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x52, 0x61, 0x74,
+				0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07, 0x69,
+				0x06, 0x69, 0x00,
+			},
+			"invalid Rational data [1 0] at offset 19",
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Errorf("error: got %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if x, ok := data.(*big.Rat); !ok || x.Cmp(c.data) != 0 {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestLoadComplex(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		data   complex128
+	}{
+		{
+			// Complex(1, 2.5)
+			"Fixnum and float",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0c, 0x43, 0x6f, 0x6d,
+				0x70, 0x6c, 0x65, 0x78, 0x5b, 0x07, 0x69, 0x06,
+				0x66, 0x08, 0x32, 0x2e, 0x35,
+			},
+			complex(1, 2.5),
+		},
+		{
+			// Complex(Rational(1, 2), 1)
+			"Rational",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0c, 0x43, 0x6f, 0x6d,
+				0x70, 0x6c, 0x65, 0x78, 0x5b, 0x07, 0x55, 0x3a,
+				0x0d, 0x52, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x61,
+				0x6c, 0x5b, 0x07, 0x69, 0x06, 0x69, 0x07, 0x69,
+				0x06,
+			},
+			complex(0.5, 1),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if data != c.data {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestDumpRationalAndComplex(t *testing.T) {
+	cases := []struct {
+		desc   string
+		v      interface{}
+		stream []byte
+	}{
+		{
+			// Rational(1, 3)
+			"Rational",
+			big.NewRat(1, 3),
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x52, 0x61, 0x74,
+				0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07, 0x69,
+				0x06, 0x69, 0x08,
+			},
+		},
+		{
+			// Rational(-(2**64), 3)
+			"Rational with a bignum",
+			new(big.Rat).SetFrac(
+				new(big.Int).Lsh(big.NewInt(-1), 64),
+				big.NewInt(3),
+			),
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x52, 0x61, 0x74,
+				0x69, 0x6f, 0x6e, 0x61, 0x6c, 0x5b, 0x07, 0x6c,
+				0x2d, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x01, 0x00, 0x69, 0x08,
+			},
+		},
+		{
+			// Complex(1.5, -2.0)
+			"Complex",
+			complex(1.5, -2),
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0c, 0x43, 0x6f, 0x6d,
+				0x70, 0x6c, 0x65, 0x78, 0x5b, 0x07, 0x66, 0x08,
+				0x31, 0x2e, 0x35, 0x66, 0x07, 0x2d, 0x32,
+			},
+		},
+		{
+			// Complex(0.5, 0.0)
+			"Complex64",
+			complex64(0.5),
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0c, 0x43, 0x6f, 0x6d,
+				0x70, 0x6c, 0x65, 0x78, 0x5b, 0x07, 0x66, 0x08,
+				0x30, 0x2e, 0x35, 0x66, 0x06, 0x30,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Dump(&buf, c.v); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.stream) {
+				t.Errorf("stream: got %x, want %x", buf.Bytes(), c.stream)
+			}
+		})
+	}
+}
+
+func TestDumpRationalRoundTrip(t *testing.T) {
+	r := big.NewRat(-22, 7)
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, makeSlice(r, r)); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	data, err := Load(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	a, ok := data.([]interface{})
+	if !ok || len(a) != 2 {
+		t.Fatalf("got %v, want two rationals", data)
+	}
+	for _, v := range a {
+		if got, ok := v.(*big.Rat); !ok || got.Cmp(r) != 0 {
+			t.Errorf("got %v, want %v", v, r)
+		}
+	}
+}
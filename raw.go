@@ -0,0 +1,238 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RawValue is the dump of a single value, header included. Unmarshal leaves
+// the values it decodes into a RawValue as they are, so that a field whose
+// type depends on the rest of the payload can be decoded later, with
+// Unmarshal or Load. Dump writes a RawValue back as the value it holds.
+//
+// The symbols and objects that a value shares with the rest of the dump are
+// written out in full the first time they appear in its RawValue, so that it
+// stands on its own. Otherwise it holds the bytes the value was read from.
+type RawValue []byte
+
+var rawValueType = reflect.TypeOf(RawValue(nil))
+
+// hasRawValue reports whether a value of type t may hold a RawValue. seen
+// guards against recursive types.
+func hasRawValue(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t == rawValueType {
+		return true
+	}
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasRawValue(t.Elem(), seen)
+	case reflect.Map:
+		return hasRawValue(t.Key(), seen) || hasRawValue(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasRawValue(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// assignNode stores the value of n in dst, the way assign does, but leaves
+// the parts of it that go into a RawValue undecoded.
+func assignNode(dst reflect.Value, n *Node, arg *LoadArg) error {
+	if dst.Type() == rawValueType {
+		var buf bytes.Buffer
+		if err := Dump(&buf, n); err != nil {
+			return err
+		}
+		dst.SetBytes(buf.Bytes())
+		return nil
+	}
+
+	var custom bool
+	if dst.CanAddr() {
+		_, custom = dst.Addr().Interface().(Unmarshaler)
+	}
+	if custom || !hasRawValue(dst.Type(), map[reflect.Type]bool{}) {
+		return assignNodeValue(dst, n, arg)
+	}
+
+	switch n.Type {
+	case typeNil:
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	case typeUclass, typeExtended:
+		return assignNode(dst, n.Data, arg)
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(dst.Type().Elem())
+		seen, leave := enterConvert(arg, n, p)
+		if seen.IsValid() {
+			dst.Set(seen)
+			return nil
+		}
+		defer leave()
+		if err := assignNode(p.Elem(), n, arg); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	case reflect.Slice:
+		if n.Type != typeArray {
+			break
+		}
+		s := reflect.MakeSlice(dst.Type(), len(n.Elems), len(n.Elems))
+		seen, leave := enterConvert(arg, n, s)
+		if seen.IsValid() {
+			dst.Set(seen)
+			return nil
+		}
+		defer leave()
+		for i, e := range n.Elems {
+			if err := assignNode(s.Index(i), e, arg); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Array:
+		if n.Type != typeArray {
+			break
+		}
+		if len(n.Elems) != dst.Len() {
+			return fmt.Errorf("cannot decode an array of %d into %v", len(n.Elems), dst.Type())
+		}
+		for i, e := range n.Elems {
+			if err := assignNode(dst.Index(i), e, arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if n.Type != typeHash && n.Type != typeHashDef {
+			break
+		}
+		t := dst.Type()
+		m := reflect.MakeMapWithSize(t, len(n.Pairs))
+		seen, leave := enterConvert(arg, n, m)
+		if seen.IsValid() {
+			dst.Set(seen)
+			return nil
+		}
+		defer leave()
+		for _, p := range n.Pairs {
+			// Keys are stringified, as in the hashes Load returns.
+			key, err := nodeValue(p.Key, arg)
+			if err != nil {
+				return err
+			}
+			k := reflect.New(t.Key()).Elem()
+			if err = assignKey(k, hashKey(key, arg), arg); err != nil {
+				return err
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if err := assignNode(e, p.Value, arg); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Struct:
+		if fields, ok := nodeFields(n); ok {
+			set := func(f reflect.Value, v interface{}) error {
+				return assignNode(f, v.(*Node), arg)
+			}
+			return convertStruct(dst, fields, set, arg.FieldMatch)
+		}
+	}
+
+	return assignNodeValue(dst, n, arg)
+}
+
+// assignNodeValue decodes n as Load would and stores the result in dst.
+func assignNodeValue(dst reflect.Value, n *Node, arg *LoadArg) error {
+	v, err := nodeValue(n, arg)
+	if err != nil {
+		return err
+	}
+
+	return assign(dst, v, arg)
+}
+
+func nodeValue(n *Node, arg *LoadArg) (interface{}, error) {
+	var buf bytes.Buffer
+	if err := Dump(&buf, n); err != nil {
+		return nil, err
+	}
+
+	return LoadBytesWith(buf.Bytes(), arg)
+}
+
+// nodeFields is like fieldSource, for nodes.
+func nodeFields(n *Node) (fieldSet, bool) {
+	switch n.Type {
+	case typeObject, typeStruct:
+		prefix := ""
+		if n.Type == typeObject {
+			prefix = "@"
+		}
+		each := func(fn func(string, interface{}) bool) {
+			for _, iv := range n.Ivars {
+				if fn(strings.TrimPrefix(iv.Name, prefix), iv.Value) {
+					return
+				}
+			}
+		}
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				return findName(each, name)
+			},
+			each: each,
+		}, true
+	case typeHash, typeHashDef:
+		// Symbol keys are preferred to strings, as in fieldSource.
+		each := func(fn func(string, interface{}) bool) {
+			for _, t := range []byte{typeSymbol, typeString} {
+				for _, p := range n.Pairs {
+					if p.Key.Type == t && fn(string(p.Key.Bytes), p.Value) {
+						return
+					}
+				}
+			}
+		}
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				return findName(each, name)
+			},
+			each: each,
+		}, true
+	}
+
+	return fieldSet{}, false
+}
+
+// findName looks name up with the each function of a fieldSet.
+func findName(each func(func(string, interface{}) bool), name string) (interface{}, bool) {
+	var found interface{}
+	var ok bool
+	each(func(key string, v interface{}) bool {
+		if key == name {
+			found, ok = v, true
+		}
+		return ok
+	})
+
+	return found, ok
+}
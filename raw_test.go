@@ -0,0 +1,124 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalRawValue(t *testing.T) {
+	// {kind: :point, data: Point.new(1, :kind)}
+	data := []byte{
+		0x04, 0x08, 0x7b, 0x07, 0x3a, 0x09, 0x6b, 0x69,
+		0x6e, 0x64, 0x3a, 0x0a, 0x70, 0x6f, 0x69, 0x6e,
+		0x74, 0x3a, 0x09, 0x64, 0x61, 0x74, 0x61, 0x6f,
+		0x3a, 0x0a, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x07,
+		0x3a, 0x07, 0x40, 0x78, 0x69, 0x06, 0x3a, 0x0a,
+		0x40, 0x6e, 0x61, 0x6d, 0x65, 0x3b, 0x00,
+	}
+
+	var msg struct {
+		Kind string
+		Data RawValue
+	}
+	if err := Unmarshal(data, &msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Kind != "point" {
+		t.Errorf("kind: got %q, want point", msg.Kind)
+	}
+
+	// The symlink to :kind is written out as the symbol itself.
+	want := RawValue{
+		0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+		0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40, 0x78, 0x69,
+		0x06, 0x3a, 0x0a, 0x40, 0x6e, 0x61, 0x6d, 0x65,
+		0x3a, 0x09, 0x6b, 0x69, 0x6e, 0x64,
+	}
+	if !bytes.Equal(msg.Data, want) {
+		t.Errorf("data: got % x, want % x", msg.Data, want)
+	}
+
+	var p struct {
+		X    int
+		Name string
+	}
+	if err := Unmarshal(msg.Data, &p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.X != 1 || p.Name != "kind" {
+		t.Errorf("point: got %+v, want {X:1 Name:kind}", p)
+	}
+
+	var m map[string]RawValue
+	if err := Unmarshal(data, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(m["data"], want) {
+		t.Errorf("map: got % x, want % x", m["data"], want)
+	}
+	if kind := []byte{0x04, 0x08, 0x3a, 0x0a, 0x70, 0x6f, 0x69, 0x6e, 0x74}; !bytes.Equal(m["kind"], kind) {
+		t.Errorf("map: got % x, want % x", m["kind"], kind)
+	}
+}
+
+func TestDumpRawValue(t *testing.T) {
+	// [:a, [:a, "x"]], with the inner array kept raw.
+	want := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0x61, 0x5b,
+		0x07, 0x3b, 0x00, 0x49, 0x22, 0x06, 0x78, 0x06,
+		0x3a, 0x06, 0x45, 0x54,
+	}
+
+	var parts []RawValue
+	if err := Unmarshal(want, &parts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, []interface{}{Symbol("a"), parts[1]}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got % x, want % x", buf.Bytes(), want)
+	}
+
+	buf.Reset()
+	if err := Dump(&buf, RawValue(nil)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nilDump := []byte{0x04, 0x08, 0x30}; !bytes.Equal(buf.Bytes(), nilDump) {
+		t.Errorf("got % x, want % x", buf.Bytes(), nilDump)
+	}
+}
+
+func TestHasRawValue(t *testing.T) {
+	type node struct {
+		Next *node
+		Data RawValue
+	}
+	type plain struct {
+		Next *plain
+	}
+
+	cases := []struct {
+		v    interface{}
+		want bool
+	}{
+		{RawValue(nil), true},
+		{[]RawValue(nil), true},
+		{map[string]RawValue(nil), true},
+		{node{}, true},
+		{plain{}, false},
+		{[]byte(nil), false},
+	}
+
+	for _, c := range cases {
+		if got := hasRawValue(reflect.TypeOf(c.v), map[reflect.Type]bool{}); got != c.want {
+			t.Errorf("%T: got %v, want %v", c.v, got, c.want)
+		}
+	}
+}
@@ -4,25 +4,23 @@ package rbmarshal
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 // Marshaled data has major and minor version numbers stored along with
 // the object information (first two bytes).
 var marshalVersion = [2]byte{0x04, 0x08}
 
-// Special byte sequences used to denote encoding lengths of a string. Ugly name
-// but encodings in marshal.c are mysterious.
-var (
-	fiveDigitEnc = [2]byte{0x06, 0x3A}
-	fourDigitEnc = [2]byte{0x06, 0x3B}
-)
-
 const (
 	// These objects are each one byte long.
 
@@ -38,27 +36,26 @@ const (
 	// determined by adding the offest to the value.
 	fixnumOffset = 5
 
-	// typeExtended   = 'e'
-	// typeUclass     = 'C'
-	// typeObject     = 'o'
-	// typeData       = 'd'
-	// typeUserdef    = 'u'
-	// typeUsrmarshal = 'U'
-	typeFloat    = 'f'
-	typeBignum   = 'l'
-	bignumPos    = '+'
-	bignumNeg    = '-'
-	bignumOffset = 10 // not sure why 10 but it does the job
-
-	typeString = '"'
-	typeRegexp = '/'
-	typeArray  = '['
-	typeHash   = '{'
-	// typeHashDef    = '}'
-	// typeStruct     = 'S'
-	// typeModuleOld  = 'M'
-	// typeClass      = 'c'
-	// typeModule     = 'm'
+	typeExtended   = 'e'
+	typeUclass     = 'C'
+	typeObject     = 'o'
+	typeData       = 'd'
+	typeUserdef    = 'u'
+	typeUsrmarshal = 'U'
+	typeFloat      = 'f'
+	typeBignum     = 'l'
+	bignumPos      = '+'
+	bignumNeg      = '-'
+
+	typeString    = '"'
+	typeRegexp    = '/'
+	typeArray     = '['
+	typeHash      = '{'
+	typeHashDef   = '}'
+	typeStruct    = 'S'
+	typeModuleOld = 'M'
+	typeClass     = 'c'
+	typeModule    = 'm'
 
 	typeSymbol  = ':'
 	typeSymlink = ';'
@@ -67,44 +64,280 @@ const (
 	typeObjlink = '@'
 )
 
-const (
-	encStart  = 0x06
-	semicolon = 0x3b
-	colon     = 0x3a
-)
-
 type LoadArg struct {
 	Symbols []string
 	Objects []interface{}
+
+	// JSONCompatKeys makes hash keys stringify the way Ruby's to_json
+	// renders them, so that float, boolean and nil keys don't collapse
+	// into "".
+	JSONCompatKeys bool
+
+	// KeepKeys makes hashes decode to KeyedHash, which remembers the
+	// original key behind every stringified one.
+	KeepKeys bool
+
+	// OrderedHashes makes hashes decode to *OrderedHash, which keeps the
+	// order of their pairs. It takes precedence over the other options for
+	// hash keys.
+	OrderedHashes bool
+
+	// AnyKeys makes hashes decode to map[interface{}]interface{}, keyed by
+	// the keys as they were decoded, rather than stringified. Keys that
+	// can't be map keys in Go are wrapped in UnhashableKey. It takes
+	// precedence over JSONCompatKeys and KeepKeys.
+	AnyKeys bool
+
+	// ClassAliases renames classes as their names are read from the
+	// stream, which helps to load dumps that predate a class rename. The
+	// keys are the old names.
+	ClassAliases map[string]string
+
+	// UserDefDecoders turn user-defined objects of the given classes into
+	// Go values. They take precedence over the decoders registered with
+	// RegisterUserDef.
+	UserDefDecoders map[string]UserDefDecoder
+
+	// ObjectDecoders turn plain objects of the given classes into Go
+	// values. They take precedence over the decoders registered with
+	// RegisterClass.
+	ObjectDecoders map[string]ObjectDecoder
+
+	// AllowVersions lists the versions, besides 4.8, that streams may
+	// have in their header, like {4, 7} for dumps of old Ruby versions.
+	// Ruby itself reads any minor version up to 8 with the same parser,
+	// and so does Load.
+	AllowVersions [][2]byte
+
+	// MaxBytes, if positive, caps how many bytes of the stream, header
+	// included, may be consumed. Going over it fails with
+	// ErrBudgetExceeded, before any memory is allocated for the value that
+	// doesn't fit.
+	MaxBytes int64
+
+	// MaxStringLen caps the length of strings, symbols, bignums and the
+	// other byte strings of the stream, and MaxCollectionLen the count of
+	// elements of arrays, of pairs of hashes and of ivars. A length over
+	// its limit fails with ErrLengthExceeded. If zero, DefaultMaxStringLen
+	// and DefaultMaxCollectionLen are used, and a negative value lifts the
+	// limit.
+	//
+	// Whatever the limits, big strings and collections aren't allocated in
+	// full until the stream has shown it holds them.
+	MaxStringLen     int
+	MaxCollectionLen int
+
+	// MaxDepth caps how deeply values may nest, like arrays in arrays, so
+	// that crafted data can't exhaust the stack. Going over it fails with
+	// ErrDepthExceeded. If zero, DefaultMaxDepth is used, and a negative
+	// value lifts the limit.
+	MaxDepth int
+
+	// DisallowUnknownTypes makes type bytes that the decoder doesn't know
+	// fail with an UnsupportedTypeError, rather than decode to nil, which
+	// OnUnknownType is told about. Such bytes usually mean the data is
+	// corrupt. Decoders set it by default.
+	DisallowUnknownTypes bool
+
+	// DisallowTrailingData makes anything after the dump fail with
+	// ErrTrailingData, for payloads that must hold a single dump, whole.
+	// A Decoder with it set fails to decode a dump that isn't the last one
+	// of its stream.
+	DisallowTrailingData bool
+
+	// StringEncodings makes strings decode to RString, which tells what
+	// encoding each of them is in.
+	StringEncodings bool
+
+	// TranscodeToUTF8 makes strings in encodings other than UTF-8 decode to
+	// UTF-8, with RString reporting them as UTF-8. Binary strings are left
+	// as they are.
+	TranscodeToUTF8 bool
+
+	// BinaryAsBytes makes binary strings, those in ASCII-8BIT, decode to
+	// []byte. It takes precedence over StringEncodings.
+	BinaryAsBytes bool
+
+	// FlattenSymbols makes symbols decode to plain strings, the way they
+	// did before Symbol was introduced.
+	FlattenSymbols bool
+
+	// BigInts makes every bignum decode to *big.Int, even one that would
+	// fit in an int.
+	BigInts bool
+
+	// Int64s makes integers decode to int64 rather than int, so that
+	// they have the same type on every platform. Bignums that don't fit
+	// in an int64 are still *big.Int.
+	Int64s bool
+
+	// UseNumber makes integers decode to Number, the way json.Number keeps
+	// them. It takes precedence over BigInts and Int64s. Floats are
+	// doubles in Ruby too, and still decode to float64.
+	UseNumber bool
+
+	// RawRegexps makes regexps decode to RRegexp instead of being compiled.
+	// Many Ruby patterns use syntax, like backreferences, that RE2 rejects.
+	RawRegexps bool
+
+	// Document makes Load return the stream as a tree of *Node, which
+	// Dump writes back byte for byte.
+	Document bool
+
+	// FieldMatch is how Unmarshal and Decoder.Decode match the fields of
+	// Go structs with the values that fill them.
+	FieldMatch FieldMatch
+
+	// OnString, if set, is called for every string decoded, with the
+	// position and the length of its bytes in the stream. Tools that
+	// redact strings can overwrite those spans without re-encoding.
+	OnString func(value string, offset int, length int)
+
+	// OnUnknownType, if set, is called for every type byte that the
+	// decoder doesn't know and decodes to nil, with its position in the
+	// stream. It lets such data be logged without making it fail.
+	OnUnknownType func(typeByte byte, offset int)
+
+	// How many bytes of the stream have been consumed so far.
+	offset int64
+
+	// How deeply the value being read is nested.
+	depth int
+
+	// If set, decoding stops at the next value once ctx is done.
+	ctx context.Context
+
+	// The next hash read is the @hash of a Set, and only its keys, in
+	// order, are wanted.
+	setHash bool
+
+	// The values being converted by Unmarshal and Decode, by source and
+	// destination type, so that cycles in the source end up as cycles in
+	// the destination.
+	converting map[convertKey]reflect.Value
 }
 
-func Load(r *bufio.Reader) (interface{}, error) {
-	if err := validateVersion(r); err != nil {
-		return nil, err
+// Load decodes a single dump from r. If r is not a *bufio.Reader already, it
+// gets wrapped into one, which may read past the end of the dump. Streams of
+// several dumps are best read with a Decoder.
+func Load(r io.Reader) (interface{}, error) {
+	return LoadWith(r, new(LoadArg))
+}
+
+// LoadWith is like Load but decodes according to the options set on arg. The
+// symbol and object tables of arg are reset before decoding.
+func LoadWith(rd io.Reader, arg *LoadArg) (interface{}, error) {
+	r, ok := rd.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(rd)
 	}
 
-	return read(r, new(LoadArg))
+	return load(r, arg)
 }
 
-func validateVersion(r *bufio.Reader) error {
-	var version [2]byte
-	_, err := io.ReadFull(r, version[:])
+func load(r byteReader, arg *LoadArg) (v interface{}, err error) {
+	defer recoverPanic(&err, arg)
+
+	resetLoadArg(arg)
+	if err := validateVersion(r, arg); err != nil {
+		return nil, decodeError(err, arg)
+	}
+	if arg.Document {
+		var n *Node
+		if n, err = readNode(r, arg); err == nil {
+			v = n
+		}
+	} else {
+		v, err = read(r, arg)
+	}
+	if err != nil {
+		return nil, decodeError(err, arg)
+	}
+
+	if arg.DisallowTrailingData {
+		if err = checkTrailing(r, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+func resetLoadArg(arg *LoadArg) {
+	arg.Symbols = arg.Symbols[:0]
+	arg.Objects = arg.Objects[:0]
+	arg.offset = 0
+	arg.depth = 0
+	arg.setHash = false
+}
+
+// LoadBase64 decodes Marshal data transported as Base64, such as the payload
+// of a Rails cookie. Both the standard and the URL-safe alphabets are
+// accepted, with or without padding. Base64 errors are wrapped, so they can be
+// told apart from decoding errors with errors.As and
+// base64.CorruptInputError.
+func LoadBase64(s string) (interface{}, error) {
+	// Ruby's Base64.encode64 breaks lines every 60 characters.
+	s = strings.NewReplacer("\n", "", "\r", "").Replace(s)
+	s = strings.TrimRight(s, "=")
+
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.RawURLEncoding
+	}
+
+	data, err := enc.DecodeString(s)
 	if err != nil {
+		return nil, fmt.Errorf("invalid base64 payload: %w", err)
+	}
+
+	return LoadBytes(data)
+}
+
+func validateVersion(r byteReader, arg *LoadArg) error {
+	// Reading the bytes one at a time keeps version off the heap.
+	var version [2]byte
+	var err error
+	if version[0], err = readByte(r, arg); err != nil {
+		return err
+	}
+	if version[1], err = readByte(r, arg); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
 		return err
 	}
 
-	if version != marshalVersion {
-		return fmt.Errorf(
-			"unsupported marshal version %v, wanted %v",
-			version, marshalVersion,
-		)
+	if version == marshalVersion {
+		return nil
+	}
+	for _, v := range arg.AllowVersions {
+		if version == v {
+			return nil
+		}
 	}
 
-	return nil
+	return &offsetError{err: fmt.Errorf(
+		"%w %v, wanted %v",
+		ErrBadVersion, version, marshalVersion,
+	)}
 }
 
-func read(r *bufio.Reader, arg *LoadArg) (interface{}, error) {
-	byte, err := r.ReadByte()
+func read(r byteReader, arg *LoadArg) (interface{}, error) {
+	if arg.ctx != nil {
+		select {
+		case <-arg.ctx.Done():
+			return nil, arg.ctx.Err()
+		default:
+		}
+	}
+
+	if err := enterValue(arg); err != nil {
+		return nil, err
+	}
+	defer leaveValue(arg)
+
+	byte, err := readByte(r, arg)
 	if err != nil {
 		return nil, err
 	}
@@ -117,36 +350,148 @@ func read(r *bufio.Reader, arg *LoadArg) (interface{}, error) {
 	case typeFalse:
 		return false, nil
 	case typeFixnum:
-		return readFixnum(r, arg)
+		n, err := readFixnum(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		return integerValue(n, arg), nil
 	case typeBignum:
-		return readBignum(r, arg)
+		v, err := readBignum(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		// readBignum registered the number itself.
+		v = integerValue(v, arg)
+		arg.Objects[len(arg.Objects)-1] = v
+		return v, nil
 	case typeString:
-		return readString(r, arg)
+		return readStringValue(r, arg, false)
 	case typeArray:
 		return readArray(r, arg)
 	case typeFloat:
 		return readFloat(r, arg)
 	case typeIvar:
-		return readIvar(r, arg)
+		return readWrapped(r, arg, true)
 	case typeRegexp:
-		return readRegexp(r, arg)
-	case typeSymbol:
-		return readSymbol(r, arg)
-	case typeSymlink:
-		return readSymlink(r, arg)
+		return readRegexp(r, arg, false)
+	case typeSymbol, typeSymlink:
+		return readSymbolValue(r, arg, byte)
 	case typeHash:
-		return readHash(r, arg)
+		return readHash(r, arg, false)
+	case typeHashDef:
+		return readHash(r, arg, true)
 	case typeObjlink:
 		return readObjlink(r, arg)
+	case typeUserdef:
+		return readUserdef(r, arg, false)
+	case typeObject:
+		return readObject(r, arg)
+	case typeStruct:
+		return readStruct(r, arg)
+	case typeUsrmarshal:
+		return readUsrmarshal(r, arg)
+	case typeData:
+		return readData(r, arg)
+	case typeClass, typeModule, typeModuleOld:
+		return readClassRef(r, arg, byte)
+	case typeUclass:
+		return readUclass(r, arg, false)
+	case typeExtended:
+		return readExtended(r, arg, false)
 	default:
-		fmt.Printf("unsupported type byte: %v\n", byte)
+		if arg.DisallowUnknownTypes {
+			return nil, &UnsupportedTypeError{TypeByte: byte, Offset: arg.offset - 1}
+		}
+		if arg.OnUnknownType != nil {
+			arg.OnUnknownType(byte, int(arg.offset)-1)
+		}
 	}
 
 	return nil, nil
 }
 
-func readFixnum(r *bufio.Reader, arg *LoadArg) (int, error) {
-	b, err := r.ReadByte()
+// The limits that apply when LoadArg.MaxStringLen, LoadArg.MaxCollectionLen
+// and LoadArg.MaxDepth are zero.
+const (
+	DefaultMaxStringLen     = 1 << 28
+	DefaultMaxCollectionLen = 1 << 24
+	DefaultMaxDepth         = 10000
+)
+
+// readStringLen reads the length of a byte string.
+func readStringLen(r byteReader, arg *LoadArg) (int, error) {
+	return readLength(r, arg, limitOf(arg.MaxStringLen, DefaultMaxStringLen))
+}
+
+// readCollectionLen reads the count of elements, pairs or ivars of a value.
+func readCollectionLen(r byteReader, arg *LoadArg) (int, error) {
+	return readLength(r, arg, limitOf(arg.MaxCollectionLen, DefaultMaxCollectionLen))
+}
+
+func limitOf(max, def int) int {
+	if max == 0 {
+		return def
+	}
+	return max
+}
+
+// enterValue counts one more level of nesting for the value about to be read,
+// which can't go over MaxDepth. leaveValue counts it back once it's read.
+func enterValue(arg *LoadArg) error {
+	limit := limitOf(arg.MaxDepth, DefaultMaxDepth)
+	if limit >= 0 && arg.depth >= limit {
+		return fmt.Errorf("%w: over %d levels", ErrDepthExceeded, limit)
+	}
+	arg.depth++
+
+	return nil
+}
+
+func leaveValue(arg *LoadArg) {
+	arg.depth--
+}
+
+// readLength reads a fixnum that holds a size, which can't be negative nor go
+// over limit, unless limit is negative.
+func readLength(r byteReader, arg *LoadArg, limit int) (int, error) {
+	offset := arg.offset
+	n, err := readFixnum(r, arg)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, &offsetError{offset: offset, err: fmt.Errorf("%w %d", ErrBadLength, n)}
+	}
+	if limit >= 0 && n > limit {
+		return 0, &offsetError{
+			offset: offset,
+			err:    fmt.Errorf("%w: %d, over %d", ErrLengthExceeded, n, limit),
+		}
+	}
+
+	return n, nil
+}
+
+// preallocLen is how many elements of a collection may be allocated before
+// they're read. Bigger ones grow as their elements come in, so that a length
+// that the stream can't back costs nothing up front.
+const preallocLen = 1 << 12
+
+// allocLen is how many of size elements to allocate up front. A payload in
+// memory shows how much it can hold, since no element takes less than a byte.
+func allocLen(r byteReader, size int) int {
+	if size <= preallocLen {
+		return size
+	}
+	if c, ok := r.(*cursor); ok && size <= len(c.data)-c.pos {
+		return size
+	}
+
+	return preallocLen
+}
+
+func readFixnum(r byteReader, arg *LoadArg) (int, error) {
+	b, err := readByte(r, arg)
 	if err != nil {
 		return 0, err
 	}
@@ -163,7 +508,7 @@ func readFixnum(r *bufio.Reader, arg *LoadArg) (int, error) {
 
 		n := 0
 		for i := 0; i < c; i++ {
-			b, err = r.ReadByte()
+			b, err = readByte(r, arg)
 			if err != nil {
 				return 0, err
 			}
@@ -179,7 +524,7 @@ func readFixnum(r *bufio.Reader, arg *LoadArg) (int, error) {
 		n := -1
 		for i := 0; i < c; i++ {
 			n &= ^(0xFF << (8 * i))
-			b, err = r.ReadByte()
+			b, err = readByte(r, arg)
 			if err != nil {
 				return 0, err
 			}
@@ -187,92 +532,140 @@ func readFixnum(r *bufio.Reader, arg *LoadArg) (int, error) {
 		}
 		return n, nil
 	}
-
-	return 0, nil
 }
 
-func readBignum(r *bufio.Reader, arg *LoadArg) (int, error) {
-	sign, err := r.ReadByte()
+// Bignums that fit in an int come back as one, unless LoadArg.BigInts is set,
+// and the rest as *big.Int.
+func readBignum(r byteReader, arg *LoadArg) (interface{}, error) {
+	sign, err := readByte(r, arg)
 	if err != nil {
 		return 0, err
 	}
+	if sign != bignumPos && sign != bignumNeg {
+		return 0, &offsetError{
+			offset: arg.offset - 1,
+			err:    fmt.Errorf("%w %q", ErrBadBignum, sign),
+		}
+	}
 
-	rawLen, err := r.ReadByte()
+	// The length is the number of 16 bit words the value takes. A crafted
+	// stream may declare no words at all, which means zero.
+	words, err := readStringLen(r, arg)
 	if err != nil {
 		return 0, err
 	}
+	len := 2 * words
 
-	len := int(2*rawLen - bignumOffset)
-	data := make([]byte, len)
-	_, err = io.ReadFull(r, data)
+	data, err := readN(r, arg, len)
 	if err != nil {
 		return 0, err
 	}
 
-	n := 0
-	for i := 0; i < len; i++ {
-		n |= int(data[i]) << (8 * i)
+	// The bytes are little-endian, and big.Int wants them the other way.
+	for i, j := 0, len-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
 	}
+	n := new(big.Int).SetBytes(data)
 
-	switch sign {
-	case bignumPos:
-		return n, nil
-	case bignumNeg:
-		return -n, nil
-	default:
-		panic("unexpected sign")
+	if sign == bignumNeg {
+		n.Neg(n)
+	}
+
+	var v interface{} = n
+	if !arg.BigInts && n.IsInt64() && int64(int(n.Int64())) == n.Int64() {
+		v = int(n.Int64())
 	}
+	arg.Objects = append(arg.Objects, v)
+
+	return v, nil
 }
 
-func readIvar(r *bufio.Reader, arg *LoadArg) (interface{}, error) {
-	bytes, err := r.Peek(1)
+// WithIvars is a value that came with instance variables of its own, such as
+// an array with @meta set. The names keep their "@".
+type WithIvars struct {
+	Value interface{}
+	Ivars map[string]interface{}
+}
+
+// readIvars reads a count of name and value pairs, the way instance variables
+// are laid out.
+func readIvars(r byteReader, arg *LoadArg) (map[string]interface{}, error) {
+	size, err := readCollectionLen(r, arg)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	b := bytes[0]
-	switch b {
-	case typeString:
-		return readString(r, arg)
-	default:
-		return read(r, arg)
+	ivars := make(map[string]interface{}, allocLen(r, size))
+	for i := 0; i < size; i++ {
+		name, err := readName(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		val, err := read(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		ivars[name] = val
 	}
+
+	return ivars, nil
 }
 
-func readString(r *bufio.Reader, arg *LoadArg) (string, error) {
-	bytes, err := r.Peek(1)
-	if err != nil {
-		return "", err
+// ivarsEncoding returns the name of the encoding that ivars specify. The E
+// ivar is the shorthand Ruby uses for UTF-8 (true) and US-ASCII (false), the
+// encoding ivar names any other encoding, and without either the value is
+// binary.
+func ivarsEncoding(ivars map[string]interface{}) string {
+	if e, ok := ivars["E"]; ok {
+		if e == true {
+			return "UTF-8"
+		}
+		return "US-ASCII"
 	}
 
-	b := bytes[0]
-	if b != typeString {
-		return readBinaryString(r, arg)
+	if enc, ok := stringValue(ivars["encoding"]); ok {
+		return enc
 	}
 
-	// Skip the typeString byte.
-	_, err = r.ReadByte()
-	if err != nil {
-		return "", err
-	}
+	return "ASCII-8BIT"
+}
 
-	encodedStr, err := readEncodedString(r, arg)
-	if err != nil {
-		return "", err
+// userIvars returns ivars without the ones that specify the encoding, or nil
+// if nothing else is left.
+func userIvars(ivars map[string]interface{}) map[string]interface{} {
+	var rest map[string]interface{}
+	for name, val := range ivars {
+		if name == "E" || name == "encoding" {
+			continue
+		}
+		if rest == nil {
+			rest = make(map[string]interface{}, len(ivars))
+		}
+		rest[name] = val
 	}
-	arg.Objects = append(arg.Objects, encodedStr)
 
-	return encodedStr, nil
+	return rest
 }
 
-func readBinaryString(r *bufio.Reader, arg *LoadArg) (string, error) {
-	len, err := readFixnum(r, arg)
+// Strings are mutable objects in Ruby, so every string goes into the object
+// table, where links to the same string object can find it later.
+func readString(r byteReader, arg *LoadArg) (string, error) {
+	b, err := readBytes(r, arg)
 	if err != nil {
 		return "", err
 	}
+	str := string(b)
+	arg.Objects = append(arg.Objects, str)
+
+	if arg.OnString != nil {
+		arg.OnString(str, int(arg.offset)-len(b), len(b))
+	}
 
-	str := make([]byte, len)
-	_, err = io.ReadFull(r, str)
+	return str, nil
+}
+
+func readBinaryString(r byteReader, arg *LoadArg) (string, error) {
+	str, err := readBytes(r, arg)
 	if err != nil {
 		return "", err
 	}
@@ -280,79 +673,149 @@ func readBinaryString(r *bufio.Reader, arg *LoadArg) (string, error) {
 	return string(str), nil
 }
 
-func readEncodedString(r *bufio.Reader, arg *LoadArg) (string, error) {
-	len, err := readFixnum(r, arg)
+func readBytes(r byteReader, arg *LoadArg) ([]byte, error) {
+	len, err := readStringLen(r, arg)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	str := make([]byte, len)
-	_, err = io.ReadFull(r, str)
-	if err != nil {
-		return "", err
+	if err = checkBudget(arg, len); err != nil {
+		return nil, err
+	}
+	if c, ok := r.(*cursor); ok {
+		// The bytes can be used where they are.
+		b, err := c.next(len)
+		if err != nil {
+			return nil, err
+		}
+		arg.offset += int64(len)
+		return b, nil
 	}
 
-	if err = stripEncoding(r, arg); err != nil {
-		return "", err
+	return readN(r, arg, len)
+}
+
+// RString is a string along with the name of its encoding and the instance
+// variables it has, if any, such as @html_safe. Strings decode to RString when
+// LoadArg.StringEncodings is set.
+type RString struct {
+	Value    string
+	Encoding string
+	Ivars    map[string]interface{}
+}
+
+// stringValue returns the contents of v if v is a decoded string, whichever
+// type the options made it decode to.
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case RString:
+		return v.Value, true
+	case []byte:
+		return string(v), true
 	}
 
-	return string(str), nil
+	return "", false
 }
 
-// Encoding is not used anywhere at the moment, so we just move the pointer
-// forwards.
-func stripEncoding(r *bufio.Reader, arg *LoadArg) error {
-	var signature [2]byte
-	_, err := io.ReadFull(r, signature[:])
+// A string that came wrapped in ivars has its encoding among them. One that
+// didn't is binary.
+func readStringValue(r byteReader, arg *LoadArg, ivar bool) (interface{}, error) {
+	obj := len(arg.Objects)
+	str, err := readString(r, arg)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	var len int // how many more bytes to strip
-	if signature == fiveDigitEnc {
-		len = 3
-	} else if signature == fourDigitEnc {
-		len = 2
-	} else {
-		return errors.New(
-			fmt.Sprintf(
-				"unsupported string encoding signature %v",
-				signature,
-			),
-		)
+	enc := "ASCII-8BIT"
+	var ivars map[string]interface{}
+	if ivar {
+		if ivars, err = readIvars(r, arg); err != nil {
+			return "", err
+		}
+		enc = ivarsEncoding(ivars)
 	}
 
-	enc := make([]byte, len)
-	_, err = io.ReadFull(r, enc)
-	if err != nil {
-		return err
+	if arg.TranscodeToUTF8 && enc != "UTF-8" {
+		if str, err = transcode(str, enc); err != nil {
+			return "", err
+		}
+		if enc != "ASCII-8BIT" {
+			enc = "UTF-8"
+		}
+		arg.Objects[obj] = str
 	}
 
-	return nil
+	if arg.BinaryAsBytes && enc == "ASCII-8BIT" {
+		b := []byte(str)
+		arg.Objects[obj] = b
+		return b, nil
+	}
+	if !arg.StringEncodings {
+		return str, nil
+	}
+	s := RString{Value: str, Encoding: enc, Ivars: userIvars(ivars)}
+	arg.Objects[obj] = s
+
+	return s, nil
 }
 
-func readArray(r *bufio.Reader, arg *LoadArg) ([]interface{}, error) {
-	size, err := readFixnum(r, arg)
+func readArray(r byteReader, arg *LoadArg) ([]interface{}, error) {
+	size, err := readCollectionLen(r, arg)
 	if err != nil {
 		return make([]interface{}, 0), err
 	}
 
-	arr := make([]interface{}, size)
+	// The array goes into the object table before its elements are read,
+	// so that elements linking back to it resolve to the array itself. It
+	// shares the backing array with the slice being filled, unless it was
+	// too big to allocate up front: links from inside such an array only
+	// see the elements before them.
+	arr := make([]interface{}, allocLen(r, size))
+	obj := len(arg.Objects)
+	arg.Objects = append(arg.Objects, arr)
+
 	for i := 0; i < size; i++ {
-		arr[i], err = read(r, arg)
+		var v interface{}
+		v, err = read(r, arg)
+		if i < len(arr) {
+			arr[i] = v
+		} else {
+			arr = append(arr, v)
+			arg.Objects[obj] = arr
+		}
 		if err != nil {
-			return arr, err
+			return arr, inPath(err, indexStep(i))
 		}
 	}
 
 	return arr, nil
 }
 
-func readFloat(r *bufio.Reader, arg *LoadArg) (float64, error) {
-	str, err := readString(r, arg)
+func readFloat(r byteReader, arg *LoadArg) (float64, error) {
+	str, err := readBinaryString(r, arg)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := parseFloat(str)
 	if err != nil {
 		return 0, err
 	}
+	arg.Objects = append(arg.Objects, f)
+
+	return f, nil
+}
+
+func parseFloat(str string) (float64, error) {
+	// Ruby 1.8, and the implementations that copied its format, write
+	// the float with "%.17g" and then append a NUL followed by the low bits
+	// of the mantissa.
+	var mantissa string
+	if i := strings.IndexByte(str, 0); i >= 0 {
+		str, mantissa = str[:i], str[i:]
+	}
 
 	switch str {
 	case "inf":
@@ -365,63 +828,125 @@ func readFloat(r *bufio.Reader, arg *LoadArg) (float64, error) {
 			return 0, err
 		}
 
-		return f, nil
+		return loadMantissa(f, []byte(mantissa)), nil
 	}
 }
 
-func readRegexp(r *bufio.Reader, arg *LoadArg) (*regexp.Regexp, error) {
-	str, err := readString(r, arg)
-	if err != nil {
-		return regexp.MustCompile(""), err
+// The number of mantissa bits that are taken from the decimal digits, and how
+// many more bits each chunk of the appended mantissa carries.
+const (
+	decimalMant = 53 - 16
+	mantBits    = 32
+)
+
+// loadMantissa restores the precision of f from the NUL-prefixed mantissa
+// bytes, the same way load_mantissa in marshal.c does.
+func loadMantissa(f float64, buf []byte) float64 {
+	if len(buf) < 2 || buf[0] != 0 {
+		return f
 	}
+	buf = buf[1:]
 
-	options, err := r.ReadByte()
-	if err != nil {
-		return regexp.MustCompile(""), err
+	frac, e := math.Frexp(math.Abs(f))
+	d, _ := math.Modf(math.Ldexp(frac, decimalMant))
+
+	dig := 0
+	for len(buf) > 0 {
+		n := mantBits / 8
+		if len(buf) < n {
+			n = len(buf)
+		}
+
+		var m uint64
+		for _, b := range buf[:n] {
+			m = m<<8 | uint64(b)
+		}
+		buf = buf[n:]
+
+		dig -= 8 * n
+		d += math.Ldexp(float64(m), dig)
 	}
 
-	switch options {
-	case 0: // o - perform #{} interpolation only once
-		// doesn't make sense in Go
-	// i (1) - case-insensitive
-	// ix (3)
-	case 1, 3:
-		str = "(?i)" + str
-	case 2: // x - ignore whitespace and comments
-		// unsupported by Go
-	// m (4) - treat a newline as a character matched by .
-	// xm (6)
-	case 4, 6:
-		str = "(?s)" + str
-	case 5, 7: // (5) - im, (7) - xmi
-		str = "(?is)" + str
-	default:
-		// Other cases invlove Regexp encoding, which we don't care
-		// about at the moment.
+	d = math.Ldexp(d, e-decimalMant)
+	if f < 0 {
+		d = -d
+	}
+
+	return d
+}
+
+// RRegexp is a Ruby regexp as it was marshaled. Options holds the
+// Regexp::IGNORECASE, EXTENDED and MULTILINE bits, along with the encoding
+// flags.
+type RRegexp struct {
+	Source  string
+	Options byte
+}
+
+func readRegexp(r byteReader, arg *LoadArg, ivar bool) (interface{}, error) {
+	str, err := readBinaryString(r, arg)
+	if err != nil {
+		return nil, err
 	}
 
-	bytes, err := r.Peek(2)
+	options, err := readByte(r, arg)
 	if err != nil {
-		return regexp.MustCompile(""), err
+		return nil, err
+	}
+
+	// Like a string, the regexp takes its slot before its ivars, which only
+	// ever hold the encoding of the source but may be strings themselves.
+	obj := len(arg.Objects)
+	arg.Objects = append(arg.Objects, nil)
+	if ivar {
+		if _, err = readIvars(r, arg); err != nil {
+			return nil, err
+		}
 	}
-	if bytes[0] == encStart && (bytes[1] == colon || bytes[1] == semicolon) {
-		stripEncoding(r, arg)
+
+	if arg.RawRegexps {
+		x := RRegexp{Source: str, Options: options}
+		arg.Objects[obj] = x
+		return x, nil
 	}
 
-	x, err := regexp.Compile(str)
+	x, err := regexp.Compile(translateRegexp(str, options))
 	if err != nil {
-		return regexp.MustCompile(""), err
+		return nil, err
 	}
-	arg.Objects = append(arg.Objects, x)
+	arg.Objects[obj] = x
 
 	return x, nil
 }
 
-// Returns strings for now but if this library will ever support encoding, we
-// will need a proper solution, so that we don't dump strings when they should
-// be symbols.
-func readSymbol(r *bufio.Reader, arg *LoadArg) (string, error) {
-	s, err := readString(r, arg)
+// Symbol is a Ruby symbol. Symbols that are values decode to Symbol, so that
+// :foo and "foo" stay apart and dump back as they were. With
+// LoadArg.FlattenSymbols set they decode to plain strings instead, as they
+// used to.
+type Symbol string
+
+// readSymbolValue reads a symbol, or a link to one, that is a value rather
+// than a name. Names, such as those of classes and ivars, stay strings.
+func readSymbolValue(r byteReader, arg *LoadArg, t byte) (interface{}, error) {
+	var s string
+	var err error
+	if t == typeSymbol {
+		s, err = readSymbol(r, arg)
+	} else {
+		s, err = readSymlink(r, arg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if arg.FlattenSymbols {
+		return s, nil
+	}
+	return Symbol(s), nil
+}
+
+func readSymbol(r byteReader, arg *LoadArg) (string, error) {
+	s, err := readBinaryString(r, arg)
 	if err != nil {
 		return "", err
 	}
@@ -429,50 +954,810 @@ func readSymbol(r *bufio.Reader, arg *LoadArg) (string, error) {
 	return s, nil
 }
 
-func readSymlink(r *bufio.Reader, arg *LoadArg) (string, error) {
+func readSymlink(r byteReader, arg *LoadArg) (string, error) {
 	i, err := readFixnum(r, arg)
 	if err != nil {
 		return "", err
 	}
+	if i < 0 || i >= len(arg.Symbols) {
+		return "", fmt.Errorf("%w %d", ErrBadSymlink, i)
+	}
 	return arg.Symbols[i], nil
 }
 
-func readHash(r *bufio.Reader, arg *LoadArg) (map[string]interface{}, error) {
-	size, err := readFixnum(r, arg)
+// A hash with a default value, like Hash.new(0), has the default after its
+// pairs and decodes to a HashWithDefault.
+func readHash(r byteReader, arg *LoadArg, withDefault bool) (interface{}, error) {
+	size, err := readCollectionLen(r, arg)
 	if err != nil {
 		return map[string]interface{}{}, err
 	}
+	n := allocLen(r, size)
+
+	var members setMembers
+	inOrder := arg.setHash
+	if inOrder {
+		members = make(setMembers, 0, n)
+	}
+	arg.setHash = false
+
+	// Like arrays, hashes are in the object table before their pairs.
+	hash := make(map[string]interface{}, n)
+	obj := len(arg.Objects)
+	var keys map[string]interface{}
+	var anyHash map[interface{}]interface{}
+	var ordered *OrderedHash
+	switch {
+	case arg.OrderedHashes:
+		ordered = &OrderedHash{
+			Pairs: make([]HashPair, 0, n),
+			index: make(map[interface{}]int, n),
+		}
+		arg.Objects = append(arg.Objects, ordered)
+	case arg.AnyKeys:
+		anyHash = make(map[interface{}]interface{}, n)
+		arg.Objects = append(arg.Objects, anyHash)
+	case arg.KeepKeys:
+		keys = make(map[string]interface{}, n)
+		arg.Objects = append(arg.Objects, KeyedHash{Values: hash, Keys: keys})
+	default:
+		arg.Objects = append(arg.Objects, hash)
+	}
 
-	hash := make(map[string]interface{}, size)
 	for i := 0; i < size; i++ {
 		key, err := read(r, arg)
 		if err != nil {
-			return hash, err
+			return hash, inPath(err, hashKeyStep(i))
 		}
 		val, err := read(r, arg)
 		if err != nil {
-			return hash, err
+			return hash, inPath(err, keyStep(key))
 		}
 
-		var k string
-		switch key := key.(type) {
-		case string:
-			k = key
-		case int:
-			k = strconv.Itoa(key)
-		default:
-			k = ""
+		if inOrder {
+			members = append(members, key)
 		}
+		if ordered != nil {
+			ordered.add(key, val)
+			continue
+		}
+		if anyHash != nil {
+			anyHash[anyKey(key)] = val
+			continue
+		}
+
+		k := hashKey(key, arg)
 		hash[k] = val
+		if keys != nil {
+			keys[k] = key
+		}
+	}
+
+	var h interface{} = hash
+	if ordered != nil {
+		h = ordered
+	}
+	if anyHash != nil {
+		h = anyHash
+	}
+	if keys != nil {
+		h = KeyedHash{Values: hash, Keys: keys}
+	}
+	if inOrder {
+		h = members
+	}
+	if !withDefault {
+		return h, nil
+	}
+
+	def, err := read(r, arg)
+	if err != nil {
+		return h, err
+	}
+	// Links read before this point, from inside the hash, can only see the
+	// plain hash.
+	hd := HashWithDefault{Hash: h, Default: def}
+	arg.Objects[obj] = hd
+
+	return hd, nil
+}
+
+// OrderedHash is a hash decoded with LoadArg.OrderedHashes, which keeps its
+// pairs in the order they were inserted in Ruby, with the keys as they were
+// decoded.
+type OrderedHash struct {
+	Pairs []HashPair
+
+	// Positions of the pairs in Pairs, by key, for the keys that can be map
+	// keys in Go, and how many pairs there were when it was built. Pairs
+	// may be built or changed by hand, so the index is only a hint.
+	index   map[interface{}]int
+	indexed int
+}
+
+// HashPair is a key and a value of an OrderedHash.
+type HashPair struct {
+	Key   interface{}
+	Value interface{}
+}
+
+func (h *OrderedHash) add(key, val interface{}) {
+	if key == nil || hashable(reflect.ValueOf(key)) {
+		if _, ok := h.index[key]; !ok {
+			h.index[key] = len(h.Pairs)
+		}
+	}
+	h.Pairs = append(h.Pairs, HashPair{Key: key, Value: val})
+	h.indexed = len(h.Pairs)
+}
+
+// reindex builds the index of the keys in Pairs again.
+func (h *OrderedHash) reindex() {
+	h.index = make(map[interface{}]int, len(h.Pairs))
+	for i, p := range h.Pairs {
+		if p.Key != nil && !hashable(reflect.ValueOf(p.Key)) {
+			continue
+		}
+		if _, ok := h.index[p.Key]; !ok {
+			h.index[p.Key] = i
+		}
+	}
+	h.indexed = len(h.Pairs)
+}
+
+// Get returns the value of key, which is compared with the keys as they were
+// decoded, such as Symbol("a") for :a. It works as well for hashes built by
+// hand, or whose Pairs were changed, but then it updates an index of the keys
+// and mustn't be called concurrently.
+func (h *OrderedHash) Get(key interface{}) (interface{}, bool) {
+	if key == nil || hashable(reflect.ValueOf(key)) {
+		if h.index == nil || h.indexed != len(h.Pairs) {
+			h.reindex()
+		}
+		if i, ok := h.index[key]; ok && i < len(h.Pairs) && h.Pairs[i].Key == key {
+			return h.Pairs[i].Value, true
+		}
+	}
+
+	// Either the key can't be looked up in the index, or the index is out
+	// of date because a key was changed in place.
+	for _, p := range h.Pairs {
+		if reflect.DeepEqual(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UnhashableKey wraps a hash key that Go can't use as a map key, such as an
+// array, in hashes decoded with LoadArg.AnyKeys. Keys are wrapped in a new
+// *UnhashableKey each, and since a Ruby hash never has two equal keys, those
+// stay apart.
+type UnhashableKey struct {
+	Value interface{}
+}
+
+func anyKey(key interface{}) interface{} {
+	if key != nil && !hashable(reflect.ValueOf(key)) {
+		return &UnhashableKey{Value: key}
+	}
+
+	return key
+}
+
+// hashable reports whether v can be a map key. Unlike reflect.Type.Comparable,
+// it looks into the values that interfaces hold.
+func hashable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return v.IsNil() || hashable(v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !hashable(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !hashable(v.Field(i)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// HashWithDefault is a hash that has a default value. Hash is what the hash
+// would decode to without one.
+type HashWithDefault struct {
+	Hash    interface{}
+	Default interface{}
+}
+
+// KeyedHash is a hash decoded with LoadArg.KeepKeys. Values is what a plain
+// decoded hash would be, and Keys maps each stringified key back to the key
+// as it was decoded, which tells {5=>x} and {"5"=>x} apart.
+type KeyedHash struct {
+	Values map[string]interface{}
+	Keys   map[string]interface{}
+}
+
+func hashKey(key interface{}, arg *LoadArg) string {
+	switch key := key.(type) {
+	case string:
+		return key
+	case Symbol:
+		return string(key)
+	case RString:
+		return key.Value
+	case []byte:
+		return string(key)
+	case int:
+		return strconv.Itoa(key)
+	case int64:
+		return strconv.FormatInt(key, 10)
+	case Number:
+		return string(key)
+	case *big.Int:
+		return key.String()
+	}
+
+	if !arg.JSONCompatKeys {
+		return ""
+	}
+
+	switch key := key.(type) {
+	case float64:
+		return rubyFloatString(key)
+	case bool:
+		return strconv.FormatBool(key)
+	default:
+		// nil.to_s is "", and so is everything we don't know how to
+		// render.
+		return ""
+	}
+}
+
+// rubyFloatString formats f the way Ruby's Float#to_s does: the shortest
+// representation that round-trips, always with a fractional part, switching
+// to the exponent form outside of 1e-4...1e16.
+func rubyFloatString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	digits, decpt, neg := floatDigits(f)
+
+	var s string
+	switch {
+	case 0 < decpt && decpt <= 16:
+		if decpt >= len(digits) {
+			s = digits + strings.Repeat("0", decpt-len(digits)) + ".0"
+		} else {
+			s = digits[:decpt] + "." + digits[decpt:]
+		}
+	case -4 < decpt && decpt <= 0:
+		s = "0." + strings.Repeat("0", -decpt) + digits
+	default:
+		frac := digits[1:]
+		if frac == "" {
+			frac = "0"
+		}
+		s = fmt.Sprintf("%s.%se%+03d", digits[:1], frac, decpt-1)
+	}
+
+	if neg {
+		s = "-" + s
 	}
 
-	return hash, nil
+	return s
+}
+
+// floatDigits returns the shortest decimal digits that represent f exactly,
+// and the position of the decimal point relative to the first digit (as in
+// C's dtoa).
+func floatDigits(f float64) (digits string, decpt int, neg bool) {
+	neg = math.Signbit(f)
+	s := strconv.FormatFloat(math.Abs(f), 'e', -1, 64)
+
+	i := strings.IndexByte(s, 'e')
+	exp, _ := strconv.Atoi(s[i+1:])
+	digits = strings.Replace(s[:i], ".", "", 1)
+
+	return digits, exp + 1, neg
 }
 
-func readObjlink(r *bufio.Reader, arg *LoadArg) (interface{}, error) {
+func readObjlink(r byteReader, arg *LoadArg) (interface{}, error) {
 	i, err := readFixnum(r, arg)
 	if err != nil {
 		return "", err
 	}
-	return arg.Objects[i-1], nil
+	if i < 0 || i >= len(arg.Objects) {
+		return "", fmt.Errorf("%w %d", ErrBadLink, i)
+	}
+	return arg.Objects[i], nil
+}
+
+// UserDef is an object of a class that defines _dump and _load. Data is the
+// verbatim output of _dump, which is usually binary, and Ivars are the ivars of
+// that string, its encoding included, if it had any.
+type UserDef struct {
+	Class string
+	Data  []byte
+	Ivars map[string]interface{}
+}
+
+func readUserdef(r byteReader, arg *LoadArg, ivar bool) (interface{}, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBytes(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	u := UserDef{Class: class, Data: data}
+	if ivar {
+		if u.Ivars, err = readIvars(r, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unlike most objects, these are registered only after _load, which
+	// is when they come into existence.
+	obj, err := loadUserDef(u, arg)
+	if err != nil {
+		return nil, err
+	}
+	arg.Objects = append(arg.Objects, obj)
+
+	return obj, nil
+}
+
+// UserMarshal is an object of a class that defines marshal_dump and
+// marshal_load. Data is whatever marshal_dump returned, decoded.
+type UserMarshal struct {
+	Class string
+	Data  interface{}
+}
+
+func readUsrmarshal(r byteReader, arg *LoadArg) (interface{}, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The object is registered before its data is read, but it can only
+	// be filled in afterwards.
+	obj := len(arg.Objects)
+	arg.Objects = append(arg.Objects, nil)
+
+	data, err := read(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := loadUserMarshal(UserMarshal{Class: class, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	arg.Objects[obj] = v
+
+	return v, nil
+}
+
+// RData is an object of a C extension class that dumps itself with _dump_data
+// and loads with _load_data. Data is whatever _dump_data returned, decoded.
+type RData struct {
+	Class string
+	Data  interface{}
+}
+
+func readData(r byteReader, arg *LoadArg) (interface{}, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := len(arg.Objects)
+	arg.Objects = append(arg.Objects, nil)
+
+	data, err := read(r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := loadData(RData{Class: class, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	arg.Objects[obj] = v
+
+	return v, nil
+}
+
+// UClass is an instance of a user-defined subclass of String, Array, Hash or
+// Regexp. Value is the built-in value it wraps.
+type UClass struct {
+	Class string
+	Value interface{}
+}
+
+// The wrapper itself takes no slot in the object table, the wrapped value
+// does, and links to it should still see the subclass.
+func readUclass(r byteReader, arg *LoadArg, ivar bool) (UClass, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return UClass{}, err
+	}
+
+	obj := len(arg.Objects)
+	value, err := readWrapped(r, arg, ivar)
+	if err != nil {
+		return UClass{}, err
+	}
+
+	u := UClass{Class: class, Value: value}
+	if len(arg.Objects) > obj {
+		arg.Objects[obj] = u
+	}
+
+	return u, nil
+}
+
+// Extended is an object extended with modules, as in obj.extend(Mod). Modules
+// lists them in the order they appear in the dump.
+type Extended struct {
+	Modules []string
+	Value   interface{}
+}
+
+// An object extended with several modules is dumped as one 'e' record per
+// module, each wrapping the next, and those are collapsed into one Extended.
+// Like the 'C' wrapper, it leaves the object table slot to the value it wraps.
+func readExtended(r byteReader, arg *LoadArg, ivar bool) (Extended, error) {
+	var e Extended
+	for {
+		module, err := readClassName(r, arg)
+		if err != nil {
+			return Extended{}, err
+		}
+		e.Modules = append(e.Modules, module)
+
+		b, err := r.Peek(1)
+		if err != nil {
+			return Extended{}, err
+		}
+		if b[0] != typeExtended {
+			break
+		}
+		// Skip the typeExtended byte.
+		if _, err = readByte(r, arg); err != nil {
+			return Extended{}, err
+		}
+	}
+
+	obj := len(arg.Objects)
+	value, err := readWrapped(r, arg, ivar)
+	if err != nil {
+		return Extended{}, err
+	}
+
+	e.Value = value
+	if len(arg.Objects) > obj {
+		arg.Objects[obj] = e
+	}
+
+	return e, nil
+}
+
+// readWrapped reads the value inside an ivar record or a 'C' or 'e' wrapper.
+// Inside an ivar record, the ivars follow the innermost value. Strings and
+// regexps take their encoding from them, user-defined objects keep them, and
+// any other value that has ivars besides the encoding comes back as WithIvars.
+func readWrapped(r byteReader, arg *LoadArg, ivar bool) (interface{}, error) {
+	if !ivar {
+		return read(r, arg)
+	}
+
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+	switch t {
+	case typeString, typeRegexp, typeUserdef, typeUclass, typeExtended:
+		// Skip the type byte.
+		if _, err = readByte(r, arg); err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case typeString:
+			return readStringValue(r, arg, true)
+		case typeRegexp:
+			return readRegexp(r, arg, true)
+		case typeUserdef:
+			return readUserdef(r, arg, true)
+		case typeUclass:
+			return readUclass(r, arg, true)
+		default:
+			return readExtended(r, arg, true)
+		}
+	}
+
+	v, err := read(r, arg)
+	if err != nil {
+		return nil, err
+	}
+	ivars, err := readIvars(r, arg)
+	if err != nil {
+		return nil, err
+	}
+	if rest := userIvars(ivars); rest != nil {
+		return WithIvars{Value: v, Ivars: rest}, nil
+	}
+
+	return v, nil
+}
+
+// RClass is a reference to a class, such as the result of
+// Marshal.dump(String).
+type RClass struct {
+	Name string
+}
+
+// RModule is a reference to a module. Dumps in the old format don't tell
+// classes and modules apart, and their references come back as RModule too.
+type RModule struct {
+	Name string
+}
+
+func readClassRef(r byteReader, arg *LoadArg, t byte) (interface{}, error) {
+	name, err := readBinaryString(r, arg)
+	if err != nil {
+		return nil, err
+	}
+	if alias, ok := arg.ClassAliases[name]; ok {
+		name = alias
+	}
+
+	var ref interface{}
+	if t == typeClass {
+		ref = RClass{Name: name}
+	} else {
+		ref = RModule{Name: name}
+	}
+	arg.Objects = append(arg.Objects, ref)
+
+	return ref, nil
+}
+
+// RObject is a plain Ruby object: the name of its class and its instance
+// variables, keyed by their names ("@foo").
+type RObject struct {
+	Class string
+	Ivars map[string]interface{}
+}
+
+// Objects of the core classes that are known decode to their own types once
+// all of their ivars are read. Until then, links to them see the RObject.
+func readObject(r byteReader, arg *LoadArg) (interface{}, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return RObject{}, err
+	}
+
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return RObject{}, err
+	}
+
+	obj := RObject{Class: class, Ivars: make(map[string]interface{}, allocLen(r, size))}
+	i := len(arg.Objects)
+	arg.Objects = append(arg.Objects, obj)
+
+	for j := 0; j < size; j++ {
+		name, err := readName(r, arg)
+		if err != nil {
+			return obj, err
+		}
+		if name == "@hash" && setClasses[class] {
+			b, err := r.Peek(1)
+			arg.setHash = err == nil && (b[0] == typeHash || b[0] == typeHashDef)
+		}
+		obj.Ivars[name], err = read(r, arg)
+		if err != nil {
+			return obj, inPath(err, ivarStep(name))
+		}
+	}
+
+	v, err := loadObject(obj, arg)
+	if err != nil {
+		return nil, err
+	}
+	arg.Objects[i] = v
+
+	return v, nil
+}
+
+// RStruct is an instance of a Struct class, with its members in the order of
+// their definition.
+type RStruct struct {
+	Class   string
+	Members []StructMember
+}
+
+// StructMember is a member of a struct.
+type StructMember struct {
+	Name  string
+	Value interface{}
+}
+
+// Member returns the value of the member with the given name.
+func (s RStruct) Member(name string) (interface{}, bool) {
+	for _, m := range s.Members {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func readStruct(r byteReader, arg *LoadArg) (RStruct, error) {
+	class, err := readClassName(r, arg)
+	if err != nil {
+		return RStruct{}, err
+	}
+
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return RStruct{}, err
+	}
+
+	// Like arrays, structs too big to allocate up front grow as their
+	// members come in.
+	obj := RStruct{Class: class, Members: make([]StructMember, allocLen(r, size))}
+	i := len(arg.Objects)
+	arg.Objects = append(arg.Objects, obj)
+
+	for j := 0; j < size; j++ {
+		if j == len(obj.Members) {
+			obj.Members = append(obj.Members, StructMember{})
+			arg.Objects[i] = obj
+		}
+		m := &obj.Members[j]
+		m.Name, err = readName(r, arg)
+		if err != nil {
+			return obj, err
+		}
+		m.Value, err = read(r, arg)
+		if err != nil {
+			return obj, inPath(err, ivarStep(m.Name))
+		}
+	}
+
+	return obj, nil
+}
+
+func readClassName(r byteReader, arg *LoadArg) (string, error) {
+	name, err := readName(r, arg)
+	if err != nil {
+		return "", err
+	}
+
+	if alias, ok := arg.ClassAliases[name]; ok {
+		return alias, nil
+	}
+
+	return name, nil
+}
+
+// readName reads a symbol or a symlink, refusing anything else.
+func readName(r byteReader, arg *LoadArg) (string, error) {
+	offset := arg.offset
+	b, err := readByte(r, arg)
+	if err != nil {
+		return "", err
+	}
+
+	switch b {
+	case typeSymbol:
+		return readSymbol(r, arg)
+	case typeSymlink:
+		return readSymlink(r, arg)
+	case typeIvar:
+		// A symbol that isn't US-ASCII, like :"名前", carries its encoding
+		// in an ivar wrapper. The symbol is registered before its ivars.
+		if b, err = readByte(r, arg); err != nil {
+			return "", err
+		}
+		if b != typeSymbol {
+			break
+		}
+		s, err := readSymbol(r, arg)
+		if err != nil {
+			return "", err
+		}
+		if _, err = readIvars(r, arg); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+
+	return "", &offsetError{
+		offset: offset,
+		err:    fmt.Errorf("%w, got type byte %q", ErrExpectedSymbol, b),
+	}
+}
+
+func readByte(r byteReader, arg *LoadArg) (byte, error) {
+	if err := checkBudget(arg, 1); err != nil {
+		return 0, err
+	}
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	arg.offset++
+
+	return b, nil
+}
+
+func readFull(r byteReader, arg *LoadArg, buf []byte) error {
+	if err := checkBudget(arg, len(buf)); err != nil {
+		return err
+	}
+
+	n, err := io.ReadFull(r, buf)
+	arg.offset += int64(n)
+
+	return err
+}
+
+// readN reads the next n bytes. Big reads go through a buffer that grows as
+// the bytes come in, so that a length the stream can't back isn't allocated.
+func readN(r byteReader, arg *LoadArg, n int) ([]byte, error) {
+	if n <= readChunk {
+		buf := make([]byte, n)
+		return buf, readFull(r, arg, buf)
+	}
+
+	if err := checkBudget(arg, n); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(readChunk)
+	m, err := io.CopyN(&buf, r, int64(n))
+	arg.offset += m
+	if err == io.EOF && m > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return buf.Bytes(), err
+}
+
+// readChunk is the size past which readN grows its buffer.
+const readChunk = 1 << 16
+
+// checkBudget tells whether n more bytes can be read without going over
+// MaxBytes.
+func checkBudget(arg *LoadArg, n int) error {
+	if arg.MaxBytes > 0 && arg.offset+int64(n) > arg.MaxBytes {
+		return ErrBudgetExceeded
+	}
+
+	return nil
 }
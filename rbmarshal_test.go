@@ -3,11 +3,16 @@ package rbmarshal
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"errors"
+	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -28,13 +33,13 @@ func TestLoad(t *testing.T) {
 		{
 			"Unsupported major version",
 			[]byte{0x01, 0x08, 0x30},
-			errors.New("unsupported marshal version [1 8], wanted [4 8]"),
+			errors.New("unsupported marshal version [1 8], wanted [4 8] at offset 0"),
 			nil,
 		},
 		{
 			"Unsupported minor version",
 			[]byte{0x04, 0x01, 0x30},
-			errors.New("unsupported marshal version [4 1], wanted [4 8]"),
+			errors.New("unsupported marshal version [4 1], wanted [4 8] at offset 0"),
 			nil,
 		},
 		{
@@ -241,6 +246,48 @@ func TestLoad(t *testing.T) {
 			nil,
 			-99999991073741825,
 		},
+		{
+			"Bignum 18446744073709551616",
+			[]byte{
+				0x04, 0x08, 0x6C, 0x2B, 0x0A, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+			},
+			nil,
+			new(big.Int).Lsh(big.NewInt(1), 64),
+		},
+		{
+			"Bignum -18446744073709551616",
+			[]byte{
+				0x04, 0x08, 0x6C, 0x2D, 0x0A, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+			},
+			nil,
+			new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 64)),
+		},
+		{
+			"Bignum with zero words",
+			[]byte{0x04, 0x08, 0x6C, 0x2B, 0x00},
+			nil,
+			0,
+		},
+		{
+			"Negative bignum with zero words",
+			[]byte{0x04, 0x08, 0x6C, 0x2D, 0x00},
+			nil,
+			0,
+		},
+		{
+			"Bignum with invalid length",
+			[]byte{0x04, 0x08, 0x6C, 0x2B, 0xFA, 0x00, 0x00},
+			errors.New("invalid length -1 at offset 4"),
+			nil,
+		},
+		{
+			"Bignum with invalid sign",
+			[]byte{0x04, 0x08, 0x6C, 0x78, 0x06, 0x01, 0x00},
+			errors.New("invalid bignum sign 'x' at offset 3"),
+			nil,
+		},
 		{
 			"String '' (empty)",
 			[]byte{
@@ -404,8 +451,8 @@ func TestLoad(t *testing.T) {
 
 			// [//, //, "", 1]
 			makeSlice(
-				regexp.MustCompile(""),
-				regexp.MustCompile(""),
+				regexp.MustCompile("(?m)"),
+				regexp.MustCompile("(?m)"),
 				"",
 				1,
 			),
@@ -425,14 +472,82 @@ func TestLoad(t *testing.T) {
 			makeSlice(
 				"hello",
 				"world",
-				regexp.MustCompile("regexp"),
+				regexp.MustCompile("(?m)regexp"),
 				"hello",
 				"world",
-				regexp.MustCompile("regexp"),
-				regexp.MustCompile("regexp"),
+				regexp.MustCompile("(?m)regexp"),
+				regexp.MustCompile("(?m)regexp"),
 				"hello",
 			),
 		},
+		{
+			"Array with a shared string",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x78,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06,
+			},
+			nil,
+
+			// s = "x"; [s, s]
+			makeSlice("x", "x"),
+		},
+		{
+			"Array with a shared ASCII_8BIT string",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x22, 0x06, 0x78, 0x40,
+				0x06,
+			},
+			nil,
+
+			// s = "x".b; [s, s]
+			makeSlice("x", "x"),
+		},
+		{
+			"Array with a shared array",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x5b, 0x06, 0x49, 0x22,
+				0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x40,
+				0x06,
+			},
+			nil,
+
+			// a = ["x"]; [a, a]
+			makeSlice(makeSlice("x"), makeSlice("x")),
+		},
+		{
+			"Array with shared objects after a nested array",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x0c, 0x5b, 0x06, 0x69, 0x06,
+				0x7b, 0x00, 0x66, 0x08, 0x31, 0x2e, 0x35, 0x6c,
+				0x2b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x40, 0x07, 0x40, 0x08, 0x40, 0x09,
+			},
+			nil,
+
+			// [[1], h = {}, f = 1.5, b = 2**40, h, f, b]
+			makeSlice(
+				makeSlice(1),
+				map[string]interface{}{},
+				1.5,
+				1<<40,
+				map[string]interface{}{},
+				1.5,
+				1<<40,
+			),
+		},
+		{
+			"Array with a shared regexp with an encoding",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x2f, 0x06, 0x61,
+				0x10, 0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63, 0x6f,
+				0x64, 0x69, 0x6e, 0x67, 0x22, 0x0b, 0x45, 0x55,
+				0x43, 0x2d, 0x4a, 0x50, 0x40, 0x06,
+			},
+			nil,
+
+			// r = /a/e; [r, r]
+			makeSlice(regexp.MustCompile("(?m)a"), regexp.MustCompile("(?m)a")),
+		},
 		{
 			"Positive float number",
 			[]byte{0x04, 0x08, 0x66, 0x09, 0x33, 0x2e, 0x31, 0x34},
@@ -467,6 +582,52 @@ func TestLoad(t *testing.T) {
 			nil,
 			10.999999999999999,
 		},
+		{
+			"Float with a binary mantissa",
+			[]byte{
+				0x04, 0x08, 0x66, 0x1a, 0x31, 0x30, 0x2e, 0x39,
+				0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39, 0x39,
+				0x39, 0x39, 0x39, 0x39, 0x39, 0x38, 0x00, 0xff,
+				0xff,
+			},
+			nil,
+
+			// Ruby 1.8 appends the mantissa bits after a NUL
+			10.999999999999998,
+		},
+		{
+			"Float with a binary mantissa below one",
+			[]byte{
+				0x04, 0x08, 0x66, 0x1b, 0x30, 0x2e, 0x31, 0x30,
+				0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30,
+				0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31, 0x00,
+				0x99, 0x9a,
+			},
+			nil,
+			0.1,
+		},
+		{
+			"Negative float with a binary mantissa",
+			[]byte{
+				0x04, 0x08, 0x66, 0x1b, 0x2d, 0x33, 0x2e, 0x30,
+				0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x30,
+				0x30, 0x30, 0x30, 0x30, 0x30, 0x30, 0x34, 0x00,
+				0x00, 0x01,
+			},
+			nil,
+			-3.0000000000000004,
+		},
+		{
+			"Float with an exponent",
+			[]byte{
+				0x04, 0x08, 0x66, 0x0c, 0x31, 0x2e, 0x30, 0x65,
+				0x2b, 0x32, 0x30,
+			},
+			nil,
+
+			// Written as "1.0e+20" rather than MRI's "1e20"
+			1e20,
+		},
 		{
 			"Empty regexp",
 			[]byte{
@@ -474,7 +635,7 @@ func TestLoad(t *testing.T) {
 				0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile(""),
+			regexp.MustCompile("(?m)"),
 		},
 		{
 			"Non-empty regexp",
@@ -484,7 +645,7 @@ func TestLoad(t *testing.T) {
 				0x2a, 0x24, 0x00, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile(`\A[0-9]+\..*$`),
+			regexp.MustCompile(`(?m)\A[0-9]+\..*$`),
 		},
 		{
 			"Regexp with the 'o' option",
@@ -493,7 +654,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x00, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("[a-z]"),
+			regexp.MustCompile("(?m)[a-z]"),
 		},
 		{
 			"Regexp with the 'i' option",
@@ -502,16 +663,16 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x01, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?i)[a-z]"),
+			regexp.MustCompile("(?im)[a-z]"),
 		},
 		{
-			"Regexp with the 'x' option (unsupported by Go)",
+			"Regexp with the 'x' option",
 			[]byte{
 				0x04, 0x08, 0x49, 0x2f, 0x0a, 0x5b, 0x61, 0x2d,
 				0x7a, 0x5d, 0x02, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("[a-z]"),
+			regexp.MustCompile("(?m)[a-z]"),
 		},
 		{
 			"Regexp with the 'ix' option",
@@ -520,7 +681,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x03, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?i)[a-z]"),
+			regexp.MustCompile("(?im)[a-z]"),
 		},
 		{
 			"Regexp with the 'm' option",
@@ -529,7 +690,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x04, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?s)[a-z]"),
+			regexp.MustCompile("(?ms)[a-z]"),
 		},
 		{
 			"Regexp with the 'im' option",
@@ -538,7 +699,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x05, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?is)[a-z]"),
+			regexp.MustCompile("(?ims)[a-z]"),
 		},
 		{
 			"Regexp with the 'xm' option",
@@ -547,7 +708,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x06, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?s)[a-z]"),
+			regexp.MustCompile("(?ms)[a-z]"),
 		},
 		{
 			"Regexp with the 'xmi' option",
@@ -556,7 +717,7 @@ func TestLoad(t *testing.T) {
 				0x7a, 0x5d, 0x07, 0x06, 0x3a, 0x06, 0x45, 0x46,
 			},
 			nil,
-			regexp.MustCompile("(?is)[a-z]"),
+			regexp.MustCompile("(?ims)[a-z]"),
 		},
 		{
 			"Symbol 'hello'",
@@ -565,7 +726,7 @@ func TestLoad(t *testing.T) {
 				0x6f,
 			},
 			nil,
-			"hello",
+			Symbol("hello"),
 		},
 		{
 			"Symbol with symlinks",
@@ -575,158 +736,1019 @@ func TestLoad(t *testing.T) {
 				0x07, 0x3b, 0x06,
 			},
 			nil,
-			makeSlice("a", "a", "b", "c", "c", "b"),
+			makeSlice(
+				Symbol("a"), Symbol("a"), Symbol("b"),
+				Symbol("c"), Symbol("c"), Symbol("b"),
+			),
 		},
 		{
-			"Empty hash",
-			[]byte{0x04, 0x08, 0x7b, 0x00},
+			"Symbols and strings",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x09, 0x3a, 0x08, 0x73, 0x79,
+				0x6d, 0x49, 0x22, 0x08, 0x73, 0x79, 0x6d, 0x06,
+				0x3a, 0x06, 0x45, 0x54, 0x69, 0x06, 0x49, 0x22,
+				0x06, 0x31, 0x06, 0x3b, 0x06, 0x54,
+			},
 			nil,
-			map[string]interface{}{},
+
+			// [:sym, "sym", 1, "1"]
+			makeSlice(Symbol("sym"), "sym", 1, "1"),
 		},
 		{
-			"Simple hash",
+			"User-defined object",
 			[]byte{
-				0x04, 0x08, 0x7b, 0x07, 0x3a, 0x0b, 0x73, 0x69,
-				0x6d, 0x70, 0x6c, 0x65, 0x69, 0x06, 0x3a, 0x09,
-				0x68, 0x61, 0x73, 0x68, 0x69, 0x07,
+				0x04, 0x08, 0x75, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x0a, 0x00, 0x01, 0x00, 0xff, 0x00,
 			},
 			nil,
-			map[string]interface{}{
-				"simple": 1,
-				"hash":   2,
+
+			// Foo#_dump returns "\x00\x01\x00\xFF\x00".b
+			UserDef{"Foo", []byte{0x00, 0x01, 0x00, 0xff, 0x00}, nil},
+		},
+		{
+			"User-defined objects with links",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x75, 0x3a, 0x08, 0x46,
+				0x6f, 0x6f, 0x06, 0x00, 0x75, 0x3b, 0x00, 0x06,
+				0x01, 0x40, 0x06,
 			},
+			nil,
+
+			// f = Foo.new; [f, Foo.new, f]
+			makeSlice(
+				UserDef{"Foo", []byte{0x00}, nil},
+				UserDef{"Foo", []byte{0x01}, nil},
+				UserDef{"Foo", []byte{0x00}, nil},
+			),
 		},
 		{
-			"Nested hash",
+			"User-defined object of a namespaced class",
 			[]byte{
-				0x04, 0x08, 0x7b, 0x08, 0x3a, 0x0b, 0x73, 0x69,
-				0x6d, 0x70, 0x6c, 0x65, 0x69, 0x06, 0x3a, 0x0b,
-				0x6e, 0x65, 0x73, 0x74, 0x65, 0x64, 0x7b, 0x06,
-				0x3a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x69, 0x07,
-				0x3a, 0x07, 0x68, 0x69, 0x69, 0x08,
+				0x04, 0x08, 0x5b, 0x07, 0x75, 0x3a, 0x0d, 0x46,
+				0x6f, 0x6f, 0x3a, 0x3a, 0x42, 0x61, 0x72, 0x06,
+				0x00, 0x75, 0x3b, 0x00, 0x06, 0x01,
 			},
 			nil,
-			map[string]interface{}{
-				"simple": 1,
-				"nested": map[string]interface{}{
-					"hash": 2,
-				},
-				"hi": 3,
+
+			// [Foo::Bar.new, Foo::Bar.new]
+			makeSlice(
+				UserDef{"Foo::Bar", []byte{0x00}, nil},
+				UserDef{"Foo::Bar", []byte{0x01}, nil},
+			),
+		},
+		{
+			"User-defined object with a string for the class name",
+			[]byte{
+				0x04, 0x08, 0x75, 0x22, 0x08, 0x46, 0x6f, 0x6f,
+				0x06, 0x00,
 			},
+			errors.New(`expected a symbol, got type byte '"' at offset 3`),
+			nil,
 		},
 		{
-			"Empty hash of hashes",
-			[]byte{0x04, 0x08, 0x7b, 0x06, 0x7b, 0x00, 0x7b, 0x00},
+			"Object without instance variables",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0b, 0x4f, 0x62, 0x6a,
+				0x65, 0x63, 0x74, 0x00,
+			},
 			nil,
-			map[string]interface{}{
-				"": map[string]interface{}{},
+
+			// Object.new
+			RObject{"Object", map[string]interface{}{}},
+		},
+		{
+			"Object with instance variables",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40, 0x78, 0x69,
+				0x06, 0x3a, 0x07, 0x40, 0x79, 0x49, 0x22, 0x06,
+				0x61, 0x06, 0x3a, 0x06, 0x45, 0x54,
 			},
+			nil,
+
+			// Point.new(1, "a")
+			RObject{"Point", map[string]interface{}{"@x": 1, "@y": "a"}},
 		},
 		{
-			"Hash with numeric keys",
+			"Nested objects",
 			[]byte{
-				0x04, 0x08, 0x7b, 0x07, 0x69, 0x06, 0x69, 0x07,
-				0x69, 0x08, 0x69, 0x09,
+				0x04, 0x08, 0x6f, 0x3a, 0x09, 0x4c, 0x69, 0x6e,
+				0x65, 0x07, 0x3a, 0x0b, 0x40, 0x73, 0x74, 0x61,
+				0x72, 0x74, 0x6f, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40, 0x78, 0x69,
+				0x06, 0x3a, 0x07, 0x40, 0x79, 0x69, 0x07, 0x3a,
+				0x09, 0x40, 0x65, 0x6e, 0x64, 0x6f, 0x3b, 0x07,
+				0x07, 0x3b, 0x08, 0x69, 0x08, 0x3b, 0x09, 0x69,
+				0x09,
 			},
 			nil,
-			map[string]interface{}{
-				"1": 2,
-				"3": 4,
+
+			// Line.new(Point.new(1, 2), Point.new(3, 4))
+			RObject{"Line", map[string]interface{}{
+				"@start": RObject{"Point", map[string]interface{}{"@x": 1, "@y": 2}},
+				"@end":   RObject{"Point", map[string]interface{}{"@x": 3, "@y": 4}},
+			}},
+		},
+		{
+			"Struct",
+			[]byte{
+				0x04, 0x08, 0x53, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x06, 0x78, 0x69, 0x06,
+				0x3a, 0x06, 0x79, 0x69, 0x07,
 			},
+			nil,
+
+			// Point = Struct.new(:x, :y); Point.new(1, 2)
+			RStruct{"Point", []StructMember{{"x", 1}, {"y", 2}}},
 		},
 		{
-			"Complex hash with mixed values",
+			"Structs sharing member names",
 			[]byte{
-				0x04, 0x08, 0x7b, 0x09, 0x3a, 0x08, 0x66, 0x6f,
-				0x6f, 0x69, 0x06, 0x3a, 0x08, 0x62, 0x61, 0x72,
-				0x49, 0x22, 0x08, 0x62, 0x61, 0x7a, 0x06, 0x3a,
-				0x06, 0x45, 0x54, 0x3a, 0x0a, 0x61, 0x72, 0x72,
-				0x61, 0x79, 0x5b, 0x08, 0x69, 0x06, 0x69, 0x07,
-				0x49, 0x2f, 0x00, 0x00, 0x06, 0x3b, 0x07, 0x46,
-				0x3a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x7b, 0x08,
-				0x3a, 0x0a, 0x62, 0x69, 0x6e, 0x67, 0x6f, 0x66,
-				0x08, 0x31, 0x2e, 0x32, 0x3a, 0x0a, 0x62, 0x61,
-				0x6e, 0x67, 0x6f, 0x66, 0x08, 0x33, 0x2e, 0x34,
-				0x3a, 0x0a, 0x62, 0x6f, 0x6e, 0x67, 0x6f, 0x5b,
-				0x06, 0x49, 0x22, 0x07, 0x68, 0x69, 0x06, 0x3b,
-				0x07, 0x54,
+				0x04, 0x08, 0x5b, 0x07, 0x53, 0x3a, 0x0a, 0x50,
+				0x6f, 0x69, 0x6e, 0x74, 0x07, 0x3a, 0x06, 0x78,
+				0x69, 0x06, 0x3a, 0x06, 0x79, 0x69, 0x07, 0x53,
+				0x3b, 0x00, 0x07, 0x3b, 0x06, 0x49, 0x22, 0x06,
+				0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x3b, 0x07,
+				0x30,
 			},
 			nil,
-			map[string]interface{}{
-				// {foo: 1, bar: "baz", array: [1, 2, //], hash: { bingo: 1.2, bango: 3.4, bongo: ["hi"] }}
-				"foo":   1,
-				"bar":   "baz",
-				"array": makeSlice(1, 2, regexp.MustCompile("")),
-				"hash": map[string]interface{}{
-					"bingo": 1.2,
-					"bango": 3.4,
-					"bongo": makeSlice("hi"),
-				},
+
+			// [Point.new(1, 2), Point.new("a", nil)]
+			makeSlice(
+				RStruct{"Point", []StructMember{{"x", 1}, {"y", 2}}},
+				RStruct{"Point", []StructMember{{"x", "a"}, {"y", nil}}},
+			),
+		},
+		{
+			"Object with marshal_dump",
+			[]byte{
+				0x04, 0x08, 0x55, 0x3a, 0x0d, 0x46, 0x72, 0x61,
+				0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5b, 0x07, 0x69,
+				0x06, 0x69, 0x08,
 			},
+			nil,
+
+			// Fraction#marshal_dump returns [1, 3]
+			UserMarshal{"Fraction", makeSlice(1, 3)},
 		},
 		{
-			"Hash with the same key and value",
+			"Objects with marshal_dump and links",
 			[]byte{
-				0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x3a,
-				0x06, 0x62,
+				0x04, 0x08, 0x5b, 0x08, 0x55, 0x3a, 0x0c, 0x53,
+				0x65, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x5b, 0x07,
+				0x69, 0x06, 0x69, 0x07, 0x55, 0x3b, 0x00, 0x5b,
+				0x07, 0x69, 0x00, 0x69, 0x06, 0x40, 0x06,
 			},
 			nil,
-			map[string]interface{}{"a": "b"},
+
+			// s = Segment.new(1, 2); [s, Segment.new(0, 1), s], where
+			// Segment#marshal_dump returns [from, to]
+			makeSlice(
+				UserMarshal{"Segment", makeSlice(1, 2)},
+				UserMarshal{"Segment", makeSlice(0, 1)},
+				UserMarshal{"Segment", makeSlice(1, 2)},
+			),
 		},
-	}
+		{
+			"Class",
+			[]byte{
+				0x04, 0x08, 0x63, 0x0b, 0x53, 0x74, 0x72, 0x69,
+				0x6e, 0x67,
+			},
+			nil,
 
-	for _, c := range cases {
-		t.Run(c.desc, func(t *testing.T) {
-			r := bytes.NewReader(c.stream)
-			buf := bufio.NewReader(r)
+			// String
+			RClass{"String"},
+		},
+		{
+			"Module",
+			[]byte{
+				0x04, 0x08, 0x6d, 0x0f, 0x43, 0x6f, 0x6d, 0x70,
+				0x61, 0x72, 0x61, 0x62, 0x6c, 0x65,
+			},
+			nil,
 
-			data, err := Load(buf)
-			if c.err == nil && err != nil {
-				t.Fatalf("unexpected error: '%q'", err)
-				return
-			}
-			if c.err != nil && err != nil {
-				if c.err.Error() != err.Error() {
-					t.Fatalf("expected error %q, want %q", err, c.err)
-				}
-				return
-			}
-			if c.err != nil && err == nil {
-				t.Errorf("expected error %q, got nothing", c.err)
-			}
+			// Comparable
+			RModule{"Comparable"},
+		},
+		{
+			"Old format module",
+			[]byte{
+				0x04, 0x08, 0x4d, 0x0f, 0x43, 0x6f, 0x6d, 0x70,
+				0x61, 0x72, 0x61, 0x62, 0x6c, 0x65,
+			},
+			nil,
+			RModule{"Comparable"},
+		},
+		{
+			"Namespaced class",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x63, 0x0d, 0x46, 0x6f,
+				0x6f, 0x3a, 0x3a, 0x42, 0x61, 0x72, 0x40, 0x06,
+			},
+			nil,
 
-			switch v := data.(type) {
-			case []interface{}:
-				d, ok := c.data.([]interface{})
-				if !ok {
-					t.Errorf("c.data: error asserting the type of %d", d)
-					return
-				}
-				if !cmp.Equal(v, d, cmp.Comparer(equalRegexps)) {
-					t.Errorf("data: got %d, want %d", v, c.data)
-				}
-			case *regexp.Regexp:
-				if !equalRegexps(v, c.data.(*regexp.Regexp)) {
-					t.Errorf("data: got %s, want %s", v, c.data)
-				}
-			case map[string]interface{}:
-				if !reflect.DeepEqual(v, c.data) {
-					t.Errorf("data: got %s, want %s", v, c.data)
-				}
-			default:
-				if v != c.data {
-					t.Errorf("data: got %d, want %d", v, c.data)
-				}
-			}
-		})
-	}
-}
+			// [Foo::Bar, Foo::Bar]
+			makeSlice(RClass{"Foo::Bar"}, RClass{"Foo::Bar"}),
+		},
+		{
+			"String subclass",
+			[]byte{
+				0x04, 0x08, 0x49, 0x43, 0x3a, 0x0d, 0x4d, 0x79,
+				0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x22, 0x06,
+				0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+			},
+			nil,
 
-func makeSlice(args ...interface{}) []interface{} {
-	s := make([]interface{}, len(args))
-	for i, arg := range args {
-		s[i] = arg
-	}
+			// MyString.new("x")
+			UClass{"MyString", "x"},
+		},
+		{
+			"Array subclass",
+			[]byte{
+				0x04, 0x08, 0x43, 0x3a, 0x0c, 0x4d, 0x79, 0x41,
+				0x72, 0x72, 0x61, 0x79, 0x5b, 0x07, 0x69, 0x06,
+				0x69, 0x07,
+			},
+			nil,
+
+			// MyArray[1, 2]
+			UClass{"MyArray", makeSlice(1, 2)},
+		},
+		{
+			"Linked string subclass",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x49, 0x43, 0x3a, 0x0d,
+				0x4d, 0x79, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67,
+				0x22, 0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x40, 0x06, 0x49, 0x22, 0x06, 0x79, 0x06, 0x3b,
+				0x06, 0x54,
+			},
+			nil,
+
+			// s = MyString.new("x"); [s, s, "y"]
+			makeSlice(UClass{"MyString", "x"}, UClass{"MyString", "x"}, "y"),
+		},
+		{
+			"Extended object",
+			[]byte{
+				0x04, 0x08, 0x65, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x6f, 0x3a, 0x0b, 0x4f, 0x62, 0x6a, 0x65, 0x63,
+				0x74, 0x00,
+			},
+			nil,
+
+			// Object.new.extend(Foo)
+			Extended{
+				[]string{"Foo"},
+				RObject{"Object", map[string]interface{}{}},
+			},
+		},
+		{
+			"Object extended with several modules",
+			[]byte{
+				0x04, 0x08, 0x65, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x65, 0x3a, 0x08, 0x42, 0x61, 0x72, 0x5b, 0x06,
+				0x69, 0x06,
+			},
+			nil,
+
+			// [1].extend(Bar).extend(Foo)
+			Extended{[]string{"Foo", "Bar"}, makeSlice(1)},
+		},
+		{
+			"Linked extended string",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x65, 0x3a, 0x08,
+				0x46, 0x6f, 0x6f, 0x22, 0x06, 0x78, 0x06, 0x3a,
+				0x06, 0x45, 0x54, 0x40, 0x06,
+			},
+			nil,
+
+			// s = "x".extend(Foo); [s, s]
+			makeSlice(
+				Extended{[]string{"Foo"}, "x"},
+				Extended{[]string{"Foo"}, "x"},
+			),
+		},
+		{
+			"Extended string subclass",
+			[]byte{
+				0x04, 0x08, 0x49, 0x65, 0x3a, 0x08, 0x46, 0x6f,
+				0x6f, 0x43, 0x3a, 0x0d, 0x4d, 0x79, 0x53, 0x74,
+				0x72, 0x69, 0x6e, 0x67, 0x22, 0x06, 0x78, 0x06,
+				0x3a, 0x06, 0x45, 0x54,
+			},
+			nil,
+
+			// MyString.new("x").extend(Foo)
+			Extended{[]string{"Foo"}, UClass{"MyString", "x"}},
+		},
+		{
+			"Hash with default",
+			[]byte{
+				0x04, 0x08, 0x7d, 0x06, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x69, 0x06, 0x69,
+				0x00,
+			},
+			nil,
+
+			// h = Hash.new(0); h["a"] = 1
+			HashWithDefault{map[string]interface{}{"a": 1}, 0},
+		},
+		{
+			"Empty hash with string default",
+			[]byte{
+				0x04, 0x08, 0x7d, 0x00, 0x49, 0x22, 0x06, 0x78,
+				0x06, 0x3a, 0x06, 0x45, 0x54,
+			},
+			nil,
+
+			// Hash.new("x")
+			HashWithDefault{map[string]interface{}{}, "x"},
+		},
+		{
+			"Linked hash with default",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x7d, 0x00, 0x69, 0x00,
+				0x40, 0x06,
+			},
+			nil,
+
+			// h = Hash.new(0); [h, h]
+			makeSlice(
+				HashWithDefault{map[string]interface{}{}, 0},
+				HashWithDefault{map[string]interface{}{}, 0},
+			),
+		},
+		{
+			"User-defined object with ivars",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x08, 0x46, 0x6f,
+				0x6f, 0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54,
+			},
+			nil,
+
+			// Foo#_dump returns "a"
+			UserDef{"Foo", []byte("a"), map[string]interface{}{"E": true}},
+		},
+		{
+			"String with ivars besides its encoding",
+			[]byte{
+				0x04, 0x08, 0x49, 0x22, 0x06, 0x61, 0x07, 0x3a,
+				0x06, 0x45, 0x54, 0x3a, 0x0f, 0x40, 0x68, 0x74,
+				0x6d, 0x6c, 0x5f, 0x73, 0x61, 0x66, 0x65, 0x54,
+			},
+			nil,
+
+			// "a".html_safe
+			"a",
+		},
+		{
+			"Array with ivars",
+			[]byte{
+				0x04, 0x08, 0x49, 0x5b, 0x06, 0x69, 0x06, 0x06,
+				0x3a, 0x0a, 0x40, 0x6d, 0x65, 0x74, 0x61, 0x69,
+				0x07,
+			},
+			nil,
+
+			// a = [1]; a.instance_variable_set(:@meta, 2)
+			WithIvars{makeSlice(1), map[string]interface{}{"@meta": 2}},
+		},
+		{
+			"Enum-like module constants",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x6f, 0x3a, 0x0b, 0x53,
+				0x74, 0x61, 0x74, 0x75, 0x73, 0x07, 0x3a, 0x0a,
+				0x40, 0x6e, 0x61, 0x6d, 0x65, 0x3a, 0x0b, 0x61,
+				0x63, 0x74, 0x69, 0x76, 0x65, 0x3a, 0x0a, 0x40,
+				0x65, 0x6e, 0x75, 0x6d, 0x6d, 0x0b, 0x4d, 0x79,
+				0x45, 0x6e, 0x75, 0x6d, 0x6f, 0x3b, 0x00, 0x07,
+				0x3b, 0x06, 0x3a, 0x0d, 0x69, 0x6e, 0x61, 0x63,
+				0x74, 0x69, 0x76, 0x65, 0x3b, 0x08, 0x40, 0x07,
+				0x40, 0x06,
+			},
+			nil,
+
+			// [MyEnum::ACTIVE, MyEnum::INACTIVE, MyEnum::ACTIVE], where
+			// the constants are Status.new(name, MyEnum)
+			makeSlice(
+				RObject{"Status", map[string]interface{}{
+					"@name": Symbol("active"),
+					"@enum": RModule{"MyEnum"},
+				}},
+				RObject{"Status", map[string]interface{}{
+					"@name": Symbol("inactive"),
+					"@enum": RModule{"MyEnum"},
+				}},
+				RObject{"Status", map[string]interface{}{
+					"@name": Symbol("active"),
+					"@enum": RModule{"MyEnum"},
+				}},
+			),
+		},
+		{
+			"Empty hash",
+			[]byte{0x04, 0x08, 0x7b, 0x00},
+			nil,
+			map[string]interface{}{},
+		},
+		{
+			"Simple hash",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x07, 0x3a, 0x0b, 0x73, 0x69,
+				0x6d, 0x70, 0x6c, 0x65, 0x69, 0x06, 0x3a, 0x09,
+				0x68, 0x61, 0x73, 0x68, 0x69, 0x07,
+			},
+			nil,
+			map[string]interface{}{
+				"simple": 1,
+				"hash":   2,
+			},
+		},
+		{
+			"Nested hash",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x08, 0x3a, 0x0b, 0x73, 0x69,
+				0x6d, 0x70, 0x6c, 0x65, 0x69, 0x06, 0x3a, 0x0b,
+				0x6e, 0x65, 0x73, 0x74, 0x65, 0x64, 0x7b, 0x06,
+				0x3a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x69, 0x07,
+				0x3a, 0x07, 0x68, 0x69, 0x69, 0x08,
+			},
+			nil,
+			map[string]interface{}{
+				"simple": 1,
+				"nested": map[string]interface{}{
+					"hash": 2,
+				},
+				"hi": 3,
+			},
+		},
+		{
+			"Empty hash of hashes",
+			[]byte{0x04, 0x08, 0x7b, 0x06, 0x7b, 0x00, 0x7b, 0x00},
+			nil,
+			map[string]interface{}{
+				"": map[string]interface{}{},
+			},
+		},
+		{
+			"Hash with numeric keys",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x07, 0x69, 0x06, 0x69, 0x07,
+				0x69, 0x08, 0x69, 0x09,
+			},
+			nil,
+			map[string]interface{}{
+				"1": 2,
+				"3": 4,
+			},
+		},
+		{
+			"Complex hash with mixed values",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x09, 0x3a, 0x08, 0x66, 0x6f,
+				0x6f, 0x69, 0x06, 0x3a, 0x08, 0x62, 0x61, 0x72,
+				0x49, 0x22, 0x08, 0x62, 0x61, 0x7a, 0x06, 0x3a,
+				0x06, 0x45, 0x54, 0x3a, 0x0a, 0x61, 0x72, 0x72,
+				0x61, 0x79, 0x5b, 0x08, 0x69, 0x06, 0x69, 0x07,
+				0x49, 0x2f, 0x00, 0x00, 0x06, 0x3b, 0x07, 0x46,
+				0x3a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x7b, 0x08,
+				0x3a, 0x0a, 0x62, 0x69, 0x6e, 0x67, 0x6f, 0x66,
+				0x08, 0x31, 0x2e, 0x32, 0x3a, 0x0a, 0x62, 0x61,
+				0x6e, 0x67, 0x6f, 0x66, 0x08, 0x33, 0x2e, 0x34,
+				0x3a, 0x0a, 0x62, 0x6f, 0x6e, 0x67, 0x6f, 0x5b,
+				0x06, 0x49, 0x22, 0x07, 0x68, 0x69, 0x06, 0x3b,
+				0x07, 0x54,
+			},
+			nil,
+			map[string]interface{}{
+				// {foo: 1, bar: "baz", array: [1, 2, //], hash: { bingo: 1.2, bango: 3.4, bongo: ["hi"] }}
+				"foo":   1,
+				"bar":   "baz",
+				"array": makeSlice(1, 2, regexp.MustCompile("(?m)")),
+				"hash": map[string]interface{}{
+					"bingo": 1.2,
+					"bango": 3.4,
+					"bongo": makeSlice("hi"),
+				},
+			},
+		},
+		{
+			"Hash with the same key and value",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x3a, 0x06, 0x61, 0x3a,
+				0x06, 0x62,
+			},
+			nil,
+			map[string]interface{}{"a": Symbol("b")},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			r := bytes.NewReader(c.stream)
+			buf := bufio.NewReader(r)
+
+			data, err := Load(buf)
+			if c.err == nil && err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+				return
+			}
+			if c.err != nil && err != nil {
+				if c.err.Error() != err.Error() {
+					t.Fatalf("expected error %q, want %q", err, c.err)
+				}
+				return
+			}
+			if c.err != nil && err == nil {
+				t.Errorf("expected error %q, got nothing", c.err)
+			}
+
+			switch v := data.(type) {
+			case []interface{}:
+				d, ok := c.data.([]interface{})
+				if !ok {
+					t.Errorf("c.data: error asserting the type of %d", d)
+					return
+				}
+				if !cmp.Equal(v, d, cmp.Comparer(equalRegexps)) {
+					t.Errorf("data: got %d, want %d", v, c.data)
+				}
+			case *big.Int:
+				if d, ok := c.data.(*big.Int); !ok || v.Cmp(d) != 0 {
+					t.Errorf("data: got %v, want %v", v, c.data)
+				}
+			case *regexp.Regexp:
+				if !equalRegexps(v, c.data.(*regexp.Regexp)) {
+					t.Errorf("data: got %s, want %s", v, c.data)
+				}
+			case map[string]interface{}:
+				if !reflect.DeepEqual(v, c.data) {
+					t.Errorf("data: got %s, want %s", v, c.data)
+				}
+			case UserDef, UserMarshal, RObject, RStruct, RClass, RModule, UClass,
+				Extended, HashWithDefault, WithIvars:
+				if !reflect.DeepEqual(v, c.data) {
+					t.Errorf("data: got %v, want %v", v, c.data)
+				}
+			default:
+				if v != c.data {
+					t.Errorf("data: got %d, want %d", v, c.data)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadWithOrderedHashes(t *testing.T) {
+	// {:b=>1, "a"=>2, [1]=>3, 1=>{:z=>0}}
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x09, 0x3a, 0x06, 0x62, 0x69,
+		0x06, 0x49, 0x22, 0x06, 0x61, 0x06, 0x3a, 0x06,
+		0x45, 0x54, 0x69, 0x07, 0x5b, 0x06, 0x69, 0x06,
+		0x69, 0x08, 0x69, 0x06, 0x7b, 0x06, 0x3a, 0x06,
+		0x7a, 0x69, 0x00,
+	}
+
+	arg := &LoadArg{OrderedHashes: true}
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	hash, ok := data.(*OrderedHash)
+	if !ok {
+		t.Fatalf("data: got %T, want *OrderedHash", data)
+	}
+	var keys []interface{}
+	for _, p := range hash.Pairs {
+		keys = append(keys, p.Key)
+	}
+	want := makeSlice(Symbol("b"), "a", makeSlice(1), 1)
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys: got %v, want %v", keys, want)
+	}
+
+	for _, c := range []struct {
+		key interface{}
+		val interface{}
+	}{
+		{Symbol("b"), 1},
+		{"a", 2},
+		{makeSlice(1), 3},
+	} {
+		if val, ok := hash.Get(c.key); !ok || val != c.val {
+			t.Errorf("Get(%v): got %v, %v, want %v", c.key, val, ok, c.val)
+		}
+	}
+	if _, ok := hash.Get("b"); ok {
+		t.Errorf("Get(%q): found a value for the :b key", "b")
+	}
+
+	nested, _ := hash.Get(1)
+	if val, ok := nested.(*OrderedHash).Get(Symbol("z")); !ok || val != 0 {
+		t.Errorf("nested Get(:z): got %v, %v, want 0", val, ok)
+	}
+}
+
+func TestOrderedHashGet(t *testing.T) {
+	h := &OrderedHash{Pairs: []HashPair{
+		{Key: Symbol("a"), Value: 1},
+		{Key: "b", Value: 2},
+		{Key: makeSlice(1), Value: 3},
+	}}
+	if val, ok := h.Get("b"); !ok || val != 2 {
+		t.Errorf("Get(%q): got %v, %v, want 2", "b", val, ok)
+	}
+
+	h.Pairs = append(h.Pairs, HashPair{Key: 4, Value: 4})
+	if val, ok := h.Get(4); !ok || val != 4 {
+		t.Errorf("Get(4) after append: got %v, %v, want 4", val, ok)
+	}
+
+	h.Pairs[0].Key = Symbol("c")
+	if val, ok := h.Get(Symbol("c")); !ok || val != 1 {
+		t.Errorf("Get(:c) after a change: got %v, %v, want 1", val, ok)
+	}
+	if _, ok := h.Get(Symbol("a")); ok {
+		t.Error("Get(:a) after a change: found a value for the old key")
+	}
+
+	h.Pairs = h.Pairs[:1]
+	if _, ok := h.Get("b"); ok {
+		t.Errorf("Get(%q) after shortening: found a value for a dropped key", "b")
+	}
+	if val, ok := h.Get(Symbol("c")); !ok || val != 1 {
+		t.Errorf("Get(:c) after shortening: got %v, %v, want 1", val, ok)
+	}
+}
+
+func TestLoadWithAnyKeys(t *testing.T) {
+	// {1=>"a", "1"=>"b", :s=>1, [1, 2]=>3, nil=>4, 1.5=>5}
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x0b, 0x69, 0x06, 0x49, 0x22,
+		0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x49,
+		0x22, 0x06, 0x31, 0x06, 0x3b, 0x00, 0x54, 0x49,
+		0x22, 0x06, 0x62, 0x06, 0x3b, 0x00, 0x54, 0x3a,
+		0x06, 0x73, 0x69, 0x06, 0x5b, 0x07, 0x69, 0x06,
+		0x69, 0x07, 0x69, 0x08, 0x30, 0x69, 0x09, 0x66,
+		0x08, 0x31, 0x2e, 0x35, 0x69, 0x0a,
+	}
+
+	arg := &LoadArg{AnyKeys: true}
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	hash, ok := data.(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("data: got %T, want map[interface{}]interface{}", data)
+	}
+	if len(hash) != 6 {
+		t.Errorf("len: got %d, want 6", len(hash))
+	}
+	for key, want := range map[interface{}]interface{}{
+		1:           "a",
+		"1":         "b",
+		Symbol("s"): 1,
+		nil:         4,
+		1.5:         5,
+	} {
+		if hash[key] != want {
+			t.Errorf("hash[%#v]: got %v, want %v", key, hash[key], want)
+		}
+	}
+
+	var found bool
+	for key, val := range hash {
+		if k, ok := key.(*UnhashableKey); ok {
+			found = reflect.DeepEqual(k.Value, makeSlice(1, 2)) && val == 3
+		}
+	}
+	if !found {
+		t.Errorf("hash: got %v, want the [1, 2] key wrapped", hash)
+	}
+}
+
+func TestLoadWithStringEncodings(t *testing.T) {
+	// s = "a"
+	// [s, "b".force_encoding("US-ASCII"), "c".force_encoding("Shift_JIS"),
+	//  "\xff".b, s]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x0a, 0x49, 0x22, 0x06, 0x61,
+		0x06, 0x3a, 0x06, 0x45, 0x54, 0x49, 0x22, 0x06,
+		0x62, 0x06, 0x3b, 0x00, 0x46, 0x49, 0x22, 0x06,
+		0x63, 0x06, 0x3a, 0x0d, 0x65, 0x6e, 0x63, 0x6f,
+		0x64, 0x69, 0x6e, 0x67, 0x22, 0x0e, 0x53, 0x68,
+		0x69, 0x66, 0x74, 0x5f, 0x4a, 0x49, 0x53, 0x22,
+		0x06, 0xff, 0x40, 0x06,
+	}
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data interface{}
+	}{
+		{
+			"Default",
+			&LoadArg{},
+			makeSlice("a", "b", "c", "\xff", "a"),
+		},
+		{
+			"String encodings",
+			&LoadArg{StringEncodings: true},
+			makeSlice(
+				RString{"a", "UTF-8", nil},
+				RString{"b", "US-ASCII", nil},
+				RString{"c", "Shift_JIS", nil},
+				RString{"\xff", "ASCII-8BIT", nil},
+				RString{"a", "UTF-8", nil},
+			),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %q, want %q", data, c.data)
+			}
+		})
+	}
+}
+
+func TestLoadStringIvars(t *testing.T) {
+	// s = "a"; s.instance_variable_set(:@html_safe, true)
+	// [s, ActiveSupport::SafeBuffer.new("b")]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x61,
+		0x07, 0x3a, 0x06, 0x45, 0x54, 0x3a, 0x0f, 0x40,
+		0x68, 0x74, 0x6d, 0x6c, 0x5f, 0x73, 0x61, 0x66,
+		0x65, 0x54, 0x49, 0x43, 0x3a, 0x1e, 0x41, 0x63,
+		0x74, 0x69, 0x76, 0x65, 0x53, 0x75, 0x70, 0x70,
+		0x6f, 0x72, 0x74, 0x3a, 0x3a, 0x53, 0x61, 0x66,
+		0x65, 0x42, 0x75, 0x66, 0x66, 0x65, 0x72, 0x22,
+		0x06, 0x62, 0x07, 0x3b, 0x00, 0x54, 0x3b, 0x06,
+		0x46,
+	}
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data interface{}
+	}{
+		{
+			"Default",
+			&LoadArg{},
+			makeSlice("a", UClass{"ActiveSupport::SafeBuffer", "b"}),
+		},
+		{
+			"String encodings",
+			&LoadArg{StringEncodings: true},
+			makeSlice(
+				RString{"a", "UTF-8", map[string]interface{}{
+					"@html_safe": true,
+				}},
+				UClass{"ActiveSupport::SafeBuffer", RString{
+					"b", "UTF-8", map[string]interface{}{
+						"@html_safe": false,
+					},
+				}},
+			),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestLoadWithBinaryAsBytes(t *testing.T) {
+	// b = "\x00\xff".b; [b, "a", "\x01".b, b, {b=>1}]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x0a, 0x22, 0x07, 0x00, 0xff,
+		0x49, 0x22, 0x06, 0x61, 0x06, 0x3a, 0x06, 0x45,
+		0x54, 0x22, 0x06, 0x01, 0x40, 0x06, 0x7b, 0x06,
+		0x40, 0x06, 0x69, 0x06,
+	}
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data interface{}
+	}{
+		{
+			"Binary as bytes",
+			&LoadArg{BinaryAsBytes: true},
+			makeSlice(
+				[]byte{0x00, 0xff},
+				"a",
+				[]byte{0x01},
+				[]byte{0x00, 0xff},
+				map[string]interface{}{"\x00\xff": 1},
+			),
+		},
+		{
+			"With string encodings",
+			&LoadArg{BinaryAsBytes: true, StringEncodings: true},
+			makeSlice(
+				[]byte{0x00, 0xff},
+				RString{"a", "UTF-8", nil},
+				[]byte{0x01},
+				[]byte{0x00, 0xff},
+				map[string]interface{}{"\x00\xff": 1},
+			),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %q, want %q", data, c.data)
+			}
+		})
+	}
+}
+
+func TestLoadWithFlattenSymbols(t *testing.T) {
+	// [:sym, "sym", {:a=>:b}]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x08, 0x3a, 0x08, 0x73, 0x79,
+		0x6d, 0x49, 0x22, 0x08, 0x73, 0x79, 0x6d, 0x06,
+		0x3a, 0x06, 0x45, 0x54, 0x7b, 0x06, 0x3a, 0x06,
+		0x61, 0x3a, 0x06, 0x62,
+	}
+
+	arg := &LoadArg{FlattenSymbols: true}
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	want := makeSlice("sym", "sym", map[string]interface{}{"a": "b"})
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+}
+
+func TestLoadUTF8Symbols(t *testing.T) {
+	testCases := []struct {
+		name   string
+		stream []byte
+		want   interface{}
+	}{
+		{
+			// {:"名前"=>1, :a=>:"名前"}
+			name: "hash key and symlink",
+			stream: []byte{
+				0x04, 0x08, 0x7b, 0x07, 0x49, 0x3a, 0x0b, 0xe5,
+				0x90, 0x8d, 0xe5, 0x89, 0x8d, 0x06, 0x3a, 0x06,
+				0x45, 0x54, 0x69, 0x06, 0x3a, 0x06, 0x61, 0x3b,
+				0x00,
+			},
+			want: map[string]interface{}{"名前": 1, "a": Symbol("名前")},
+		},
+		{
+			// Foo.new with @名 = 1
+			name: "ivar name",
+			stream: []byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+				0x06, 0x49, 0x3a, 0x09, 0x40, 0xe5, 0x90, 0x8d,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x69, 0x06,
+			},
+			want: RObject{"Foo", map[string]interface{}{"@名": 1}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := Load(bufio.NewReader(bytes.NewReader(tc.stream)))
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, tc.want) {
+				t.Errorf("data: got %#v, want %#v", data, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadWithRawRegexps(t *testing.T) {
+	// /(a)\1/i, which RE2 can't compile.
+	stream := []byte{
+		0x04, 0x08, 0x49, 0x2f, 0x0a, 0x28, 0x61, 0x29,
+		0x5c, 0x31, 0x01, 0x06, 0x3a, 0x06, 0x45, 0x46,
+	}
+
+	if _, err := Load(bufio.NewReader(bytes.NewReader(stream))); err == nil {
+		t.Fatal("expected an error without RawRegexps")
+	}
+
+	arg := &LoadArg{RawRegexps: true}
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	want := RRegexp{Source: `(a)\1`, Options: 1}
+	if data != want {
+		t.Errorf("data: got %#v, want %#v", data, want)
+	}
+}
+
+func TestLoadFromReader(t *testing.T) {
+	// [1, "Hi"]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x49, 0x22,
+		0x07, 0x48, 0x69, 0x06, 0x3a, 0x06, 0x45, 0x54,
+	}
+	want := makeSlice(1, "Hi")
+
+	for _, r := range []io.Reader{
+		bytes.NewReader(stream),
+		iotest.OneByteReader(bytes.NewReader(stream)),
+		bufio.NewReader(bytes.NewReader(stream)),
+	} {
+		data, err := Load(r)
+		if err != nil {
+			t.Fatalf("%T: unexpected error: '%q'", r, err)
+		}
+		if !reflect.DeepEqual(data, want) {
+			t.Errorf("%T: got %v, want %v", r, data, want)
+		}
+	}
+}
+
+func TestLoadWithBigInts(t *testing.T) {
+	// [1073741824, 1]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x6c, 0x2b, 0x07, 0x00,
+		0x00, 0x00, 0x40, 0x69, 0x06,
+	}
+
+	arg := &LoadArg{BigInts: true}
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	arr := data.([]interface{})
+	if n, ok := arr[0].(*big.Int); !ok || n.Cmp(big.NewInt(1<<30)) != 0 {
+		t.Errorf("data[0]: got %v, want *big.Int 1073741824", arr[0])
+	}
+	// Fixnums aren't affected.
+	if arr[1] != 1 {
+		t.Errorf("data[1]: got %v, want 1", arr[1])
+	}
+}
+
+func TestLoadLongBignum(t *testing.T) {
+	// 2**2000 takes 126 words, more than a one byte fixnum can count.
+	stream := []byte{0x04, 0x08, 0x6c, 0x2b, 0x01, 0x7e}
+	words := make([]byte, 252)
+	words[250] = 0x01
+	stream = append(stream, words...)
+
+	data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	want := new(big.Int).Lsh(big.NewInt(1), 2000)
+	if n, ok := data.(*big.Int); !ok || n.Cmp(want) != 0 {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+}
+
+func makeSlice(args ...interface{}) []interface{} {
+	s := make([]interface{}, len(args))
+	for i, arg := range args {
+		s[i] = arg
+	}
 
 	return s
 }
@@ -734,3 +1756,627 @@ func makeSlice(args ...interface{}) []interface{} {
 func equalRegexps(x, y *regexp.Regexp) bool {
 	return x.String() == y.String()
 }
+
+func TestLoadWithJSONCompatKeys(t *testing.T) {
+	// {1=>"a", :b=>2, 1.5=>3, 1.0e20=>4, nil=>5, true=>6, 100.0=>7}
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x0c, 0x69, 0x06, 0x49, 0x22,
+		0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x3a,
+		0x06, 0x62, 0x69, 0x07, 0x66, 0x08, 0x31, 0x2e,
+		0x35, 0x69, 0x08, 0x66, 0x09, 0x31, 0x65, 0x32,
+		0x30, 0x69, 0x09, 0x30, 0x69, 0x0a, 0x54, 0x69,
+		0x0b, 0x66, 0x08, 0x31, 0x65, 0x32, 0x69, 0x0c,
+	}
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data map[string]interface{}
+	}{
+		{
+			"Default keys",
+			&LoadArg{},
+			map[string]interface{}{
+				"1": "a",
+				"b": 2,
+				"":  7,
+			},
+		},
+		{
+			// {1=>"a", :b=>2, 1.5=>3, 1.0e20=>4, nil=>5, true=>6, 100.0=>7}.to_json
+			"JSON compatible keys",
+			&LoadArg{JSONCompatKeys: true},
+			map[string]interface{}{
+				"1":       "a",
+				"b":       2,
+				"1.5":     3,
+				"1.0e+20": 4,
+				"":        5,
+				"true":    6,
+				"100.0":   7,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestRubyFloatString(t *testing.T) {
+	cases := []struct {
+		f float64
+		s string
+	}{
+		{0, "0.0"},
+		{math.Copysign(0, -1), "-0.0"},
+		{1, "1.0"},
+		{-3.14, "-3.14"},
+		{0.1, "0.1"},
+		{0.0001, "0.0001"},
+		{0.00001, "1.0e-05"},
+		{1e15, "1000000000000000.0"},
+		{1e16, "1.0e+16"},
+		{1.5e100, "1.5e+100"},
+		{10.999999999999998, "10.999999999999998"},
+		{math.Inf(1), "Infinity"},
+		{math.Inf(-1), "-Infinity"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, c := range cases {
+		if s := rubyFloatString(c.f); s != c.s {
+			t.Errorf("rubyFloatString(%v): got %q, want %q", c.f, s, c.s)
+		}
+	}
+}
+
+func TestLoadBase64(t *testing.T) {
+	cases := []struct {
+		desc    string
+		payload string
+		data    interface{}
+	}{
+		// Marshal.dump("Hi")
+		{"Standard alphabet", "BAhJIgdIaQY6BkVU", "Hi"},
+		{"Line breaks", "BAhJIgdI\naQY6BkVU\n", "Hi"},
+
+		// Marshal.dump([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 250])
+		{
+			"Standard alphabet with padding",
+			"BAhbEmkGaQdpCGkJaQppC2kMaQ1pDmkPaRBpEWkB+g==",
+			makeSlice(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 250),
+		},
+		{
+			"Standard alphabet without padding",
+			"BAhbEmkGaQdpCGkJaQppC2kMaQ1pDmkPaRBpEWkB+g",
+			makeSlice(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 250),
+		},
+		{
+			"URL-safe alphabet with padding",
+			"BAhbEmkGaQdpCGkJaQppC2kMaQ1pDmkPaRBpEWkB-g==",
+			makeSlice(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 250),
+		},
+		{
+			"URL-safe alphabet without padding",
+			"BAhbEmkGaQdpCGkJaQppC2kMaQ1pDmkPaRBpEWkB-g",
+			makeSlice(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 250),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := LoadBase64(c.payload)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+
+	t.Run("Invalid base64", func(t *testing.T) {
+		_, err := LoadBase64("BAh*")
+		var b64err base64.CorruptInputError
+		if !errors.As(err, &b64err) {
+			t.Errorf("expected a base64 error, got %v", err)
+		}
+	})
+
+	t.Run("Invalid marshal data", func(t *testing.T) {
+		// "\x04\x01T"
+		_, err := LoadBase64("BAFU")
+		var b64err base64.CorruptInputError
+		if err == nil || errors.As(err, &b64err) {
+			t.Errorf("expected a marshal error, got %v", err)
+		}
+	})
+}
+
+func TestLoadWithClassAliases(t *testing.T) {
+	// [OldFoo.new, OldFoo.new, Bar.new]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x08, 0x75, 0x3a, 0x0b, 0x4f,
+		0x6c, 0x64, 0x46, 0x6f, 0x6f, 0x06, 0x00, 0x75,
+		0x3b, 0x00, 0x06, 0x01, 0x75, 0x3a, 0x08, 0x42,
+		0x61, 0x72, 0x06, 0x02,
+	}
+	arg := &LoadArg{
+		ClassAliases: map[string]string{"OldFoo": "Foo"},
+	}
+
+	data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+
+	want := makeSlice(
+		UserDef{"Foo", []byte{0x00}, nil},
+		UserDef{"Foo", []byte{0x01}, nil},
+		UserDef{"Bar", []byte{0x02}, nil},
+	)
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+}
+
+func TestLoadExpectedSymbol(t *testing.T) {
+	// A user-defined object with a fixnum where the class name should be.
+	stream := []byte{0x04, 0x08, 0x75, 0x69, 0x06, 0x06, 0x00}
+
+	_, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if !errors.Is(err, ErrExpectedSymbol) {
+		t.Errorf("error: got %v, want %v", err, ErrExpectedSymbol)
+	}
+}
+
+func TestLoadWithMaxBytes(t *testing.T) {
+	// "Hello! World?"
+	stream := []byte{
+		0x04, 0x08, 0x49, 0x22, 0x12, 0x48, 0x65, 0x6C,
+		0x6C, 0x6F, 0x21, 0x20, 0x57, 0x6F, 0x72, 0x6C,
+		0x64, 0x3F, 0x06, 0x3A, 0x06, 0x45, 0x54,
+	}
+
+	cases := []struct {
+		desc     string
+		maxBytes int64
+		err      error
+	}{
+		{"No budget", 0, nil},
+		{"Budget of the exact size", int64(len(stream)), nil},
+		{"Budget one byte short", int64(len(stream) - 1), ErrBudgetExceeded},
+		{"Budget smaller than the string", 10, ErrBudgetExceeded},
+		{"Budget smaller than the header", 1, ErrBudgetExceeded},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			arg := &LoadArg{MaxBytes: c.maxBytes}
+			_, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+			if !errors.Is(err, c.err) {
+				t.Errorf("error: got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestLoadNaN(t *testing.T) {
+	// Marshal.dump(Float::NAN)
+	stream := []byte{0x04, 0x08, 0x66, 0x08, 0x6e, 0x61, 0x6e}
+
+	data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if f, ok := data.(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("data: got %v, want NaN", data)
+	}
+}
+
+func TestLoadWithOnString(t *testing.T) {
+	type span struct {
+		value          string
+		offset, length int
+	}
+
+	cases := []struct {
+		desc   string
+		stream []byte
+		spans  []span
+	}{
+		{
+			// ["ab", "x".b, {"k"=>"v"}]
+			"Strings in containers",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x49, 0x22, 0x07, 0x61,
+				0x62, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x22, 0x06,
+				0x78, 0x7b, 0x06, 0x49, 0x22, 0x06, 0x6b, 0x06,
+				0x3b, 0x00, 0x54, 0x49, 0x22, 0x06, 0x76, 0x06,
+				0x3b, 0x00, 0x54,
+			},
+			[]span{{"ab", 7, 2}, {"x", 16, 1}, {"k", 22, 1}, {"v", 30, 1}},
+		},
+		{
+			// "a".b * 200
+			"String with a two byte length",
+			append(
+				[]byte{0x04, 0x08, 0x22, 0x01, 0xc8},
+				bytes.Repeat([]byte{0x61}, 200)...,
+			),
+			[]span{{strings.Repeat("a", 200), 5, 200}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			var spans []span
+			arg := &LoadArg{
+				OnString: func(value string, offset, length int) {
+					spans = append(spans, span{value, offset, length})
+				},
+			}
+
+			_, err := LoadWith(bufio.NewReader(bytes.NewReader(c.stream)), arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(spans, c.spans) {
+				t.Errorf("spans: got %v, want %v", spans, c.spans)
+			}
+			for _, s := range spans {
+				if got := string(c.stream[s.offset : s.offset+s.length]); got != s.value {
+					t.Errorf("span of %q points at %q", s.value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadWithKeepKeys(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		data   KeyedHash
+	}{
+		{
+			"Integer key",
+			// {5=>"x"}
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x69, 0x0a, 0x49, 0x22,
+				0x06, 0x78, 0x06, 0x3a, 0x06, 0x45, 0x54,
+			},
+			KeyedHash{
+				Values: map[string]interface{}{"5": "x"},
+				Keys:   map[string]interface{}{"5": 5},
+			},
+		},
+		{
+			"String key",
+			// {"5"=>"x"}
+			[]byte{
+				0x04, 0x08, 0x7b, 0x06, 0x49, 0x22, 0x06, 0x35,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x49, 0x22, 0x06,
+				0x78, 0x06, 0x3b, 0x00, 0x54,
+			},
+			KeyedHash{
+				Values: map[string]interface{}{"5": "x"},
+				Keys:   map[string]interface{}{"5": "5"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			arg := &LoadArg{KeepKeys: true}
+			data, err := LoadWith(bufio.NewReader(bytes.NewReader(c.stream)), arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestRStructMember(t *testing.T) {
+	s := RStruct{"Point", []StructMember{{"x", 1}, {"y", nil}}}
+
+	if v, ok := s.Member("x"); !ok || v != 1 {
+		t.Errorf("member x: got %v, %v, want %v, %v", v, ok, 1, true)
+	}
+	if v, ok := s.Member("y"); !ok || v != nil {
+		t.Errorf("member y: got %v, %v, want %v, %v", v, ok, nil, true)
+	}
+	if v, ok := s.Member("z"); ok {
+		t.Errorf("member z: got %v, %v, want %v, %v", v, ok, nil, false)
+	}
+}
+
+func TestLoadCircularReferences(t *testing.T) {
+	load := func(stream []byte) interface{} {
+		data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+		if err != nil {
+			t.Fatalf("unexpected error: '%q'", err)
+		}
+		return data
+	}
+
+	t.Run("Hash containing itself", func(t *testing.T) {
+		// h = {}; h[:self] = h
+		h := load([]byte{
+			0x04, 0x08, 0x7b, 0x06, 0x3a, 0x09, 0x73, 0x65,
+			0x6c, 0x66, 0x40, 0x00,
+		}).(map[string]interface{})
+
+		self, ok := h["self"].(map[string]interface{})
+		if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(h).Pointer() {
+			t.Errorf("h[:self] is not h: %v", h["self"])
+		}
+	})
+
+	t.Run("Array containing itself", func(t *testing.T) {
+		// a = [1]; a << a
+		a := load([]byte{
+			0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x40, 0x00,
+		}).([]interface{})
+
+		self, ok := a[1].([]interface{})
+		if !ok || &self[0] != &a[0] {
+			t.Errorf("a[1] is not a: %v", a[1])
+		}
+	})
+
+	t.Run("Object referencing itself through an array", func(t *testing.T) {
+		// o = Object.new; o.instance_variable_set(:@all, [o])
+		o := load([]byte{
+			0x04, 0x08, 0x6f, 0x3a, 0x0b, 0x4f, 0x62, 0x6a,
+			0x65, 0x63, 0x74, 0x06, 0x3a, 0x09, 0x40, 0x61,
+			0x6c, 0x6c, 0x5b, 0x06, 0x40, 0x00,
+		}).(RObject)
+
+		all := o.Ivars["@all"].([]interface{})
+		self, ok := all[0].(RObject)
+		if !ok || reflect.ValueOf(self.Ivars).Pointer() != reflect.ValueOf(o.Ivars).Pointer() {
+			t.Errorf("@all[0] is not o: %v", all[0])
+		}
+	})
+}
+
+func TestDisallowUnknownTypes(t *testing.T) {
+	// [1, <'Z'>]
+	stream := []byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x5a}
+	want := &UnsupportedTypeError{TypeByte: 'Z', Offset: 6}
+
+	arg := &LoadArg{DisallowUnknownTypes: true}
+	_, err := LoadWith(bytes.NewReader(stream), arg)
+	var typeErr *UnsupportedTypeError
+	if !errors.As(err, &typeErr) || *typeErr != *want {
+		t.Errorf("LoadWith: got %v, want %v", err, want)
+	}
+
+	// Decoders are strict unless told otherwise.
+	var v interface{}
+	err = NewDecoder(bytes.NewReader(stream)).Decode(&v)
+	if !errors.As(err, &typeErr) || *typeErr != *want {
+		t.Errorf("Decode: got %v, want %v", err, want)
+	}
+	if _, err = new(Pool).Load(stream); !errors.As(err, &typeErr) {
+		t.Errorf("Pool.Load: got %v, want %v", err, want)
+	}
+}
+
+func TestOnUnknownType(t *testing.T) {
+	// [1, <'Z'>]
+	stream := []byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x5a}
+
+	var seen []int
+	arg := &LoadArg{OnUnknownType: func(b byte, offset int) {
+		if b != 'Z' {
+			t.Errorf("got type byte %q, want 'Z'", b)
+		}
+		seen = append(seen, offset)
+	}}
+	data, err := LoadWith(bytes.NewReader(stream), arg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := makeSlice(1, nil); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+	if !reflect.DeepEqual(seen, []int{6}) {
+		t.Errorf("offsets: got %v, want [6]", seen)
+	}
+}
+
+func TestLengthLimits(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		arg    LoadArg
+		err    error
+	}{
+		{
+			"String over the default limit",
+			[]byte{0x04, 0x08, 0x22, 0x04, 0x00, 0x00, 0x00, 0x40, 0x61},
+			LoadArg{},
+			ErrLengthExceeded,
+		},
+		{
+			"String under the default limit",
+			[]byte{0x04, 0x08, 0x22, 0x04, 0x00, 0x00, 0x00, 0x08, 0x61},
+			LoadArg{},
+			ErrTruncated,
+		},
+		{
+			"Array under the default limit",
+			[]byte{0x04, 0x08, 0x5b, 0x03, 0x00, 0x00, 0x10, 0x30},
+			LoadArg{},
+			ErrTruncated,
+		},
+		{
+			"String over MaxStringLen",
+			[]byte{0x04, 0x08, 0x22, 0x08, 0x61, 0x62, 0x63},
+			LoadArg{MaxStringLen: 2},
+			ErrLengthExceeded,
+		},
+		{
+			"String with no limit",
+			[]byte{0x04, 0x08, 0x22, 0x04, 0x00, 0x00, 0x00, 0x40, 0x61},
+			LoadArg{MaxStringLen: -1},
+			ErrTruncated,
+		},
+		{
+			"Array over MaxCollectionLen",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x69, 0x07},
+			LoadArg{MaxCollectionLen: 1},
+			ErrLengthExceeded,
+		},
+		{
+			"Symbol over MaxStringLen",
+			[]byte{0x04, 0x08, 0x3a, 0x08, 0x61, 0x62, 0x63},
+			LoadArg{MaxStringLen: 2},
+			ErrLengthExceeded,
+		},
+		{
+			"Object over MaxCollectionLen",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x06, 0x41, 0x07, 0x3a,
+				0x07, 0x40, 0x61, 0x30, 0x3a, 0x07, 0x40, 0x62,
+				0x30,
+			},
+			LoadArg{MaxCollectionLen: 1},
+			ErrLengthExceeded,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			arg := c.arg
+			_, err := LoadWith(bytes.NewReader(c.stream), &arg)
+			if !errors.Is(err, c.err) {
+				t.Errorf("LoadWith: got %v, want %v", err, c.err)
+			}
+			arg = c.arg
+			if err = Validate(bufio.NewReader(bytes.NewReader(c.stream)), &arg); !errors.Is(err, c.err) {
+				t.Errorf("Validate: got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestLoadGrowsBigArrays(t *testing.T) {
+	// a = Array.new(5000, true); a[4999] = a
+	stream := []byte{0x04, 0x08, 0x5b, 0x02, 0x88, 0x13}
+	for i := 0; i < 4999; i++ {
+		stream = append(stream, 0x54)
+	}
+	stream = append(stream, 0x40, 0x00)
+
+	data, err := Load(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, ok := data.([]interface{})
+	if !ok || len(a) != 5000 {
+		t.Fatalf("got %T of %d, want 5000 elements", data, len(a))
+	}
+	if a[4998] != true {
+		t.Errorf("got %v, want true", a[4998])
+	}
+	// The link sees the elements read before it.
+	if inner, ok := a[4999].([]interface{}); !ok || len(inner) != 4999 {
+		t.Errorf("got %T of %d, want the first 4999 elements", a[4999], len(inner))
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// nested(n) is n arrays around nil, like [[nil]] for 2.
+	nested := func(n int) []byte {
+		stream := []byte{0x04, 0x08}
+		for i := 0; i < n; i++ {
+			stream = append(stream, 0x5b, 0x06)
+		}
+		return append(stream, 0x30)
+	}
+
+	cases := []struct {
+		desc   string
+		stream []byte
+		arg    LoadArg
+		err    error
+	}{
+		{"Within MaxDepth", nested(2), LoadArg{MaxDepth: 3}, nil},
+		{"Over MaxDepth", nested(3), LoadArg{MaxDepth: 3}, ErrDepthExceeded},
+		{"Over the default", nested(DefaultMaxDepth), LoadArg{}, ErrDepthExceeded},
+		{"No limit", nested(DefaultMaxDepth), LoadArg{MaxDepth: -1}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			for _, doc := range []bool{false, true} {
+				arg := c.arg
+				arg.Document = doc
+				if _, err := LoadBytesWith(c.stream, &arg); !errors.Is(err, c.err) {
+					t.Errorf("document %v: got %v, want %v", doc, err, c.err)
+				}
+			}
+			arg := c.arg
+			if err := Validate(bufio.NewReader(bytes.NewReader(c.stream)), &arg); !errors.Is(err, c.err) {
+				t.Errorf("Validate: got %v, want %v", err, c.err)
+			}
+
+			d := NewDecoder(bytes.NewReader(c.stream))
+			d.LoadArg = c.arg
+			var err error
+			for err == nil {
+				_, err = d.Token()
+			}
+			if c.err == nil && err != io.EOF || c.err != nil && !errors.Is(err, c.err) {
+				t.Errorf("Token: got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestDisallowTrailingData(t *testing.T) {
+	// 1, then the start of a second dump.
+	stream := []byte{0x04, 0x08, 0x69, 0x06, 0x04, 0x08}
+
+	arg := &LoadArg{DisallowTrailingData: true}
+	_, err := LoadBytesWith(stream, arg)
+	want := &DecodeError{Offset: 4, Err: ErrTrailingData}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("LoadBytesWith: got %v, want %v", err, want)
+	}
+	var n int
+	if err = UnmarshalWith(stream, &n, arg); !errors.Is(err, ErrTrailingData) {
+		t.Errorf("UnmarshalWith: got %v, want %v", err, ErrTrailingData)
+	}
+	if err = UnmarshalWith(stream[:4], &n, arg); err != nil || n != 1 {
+		t.Errorf("UnmarshalWith: got %v and %v, want 1", n, err)
+	}
+
+	// Without the option, the rest is left alone.
+	if v, err := LoadBytes(stream); err != nil || v != 1 {
+		t.Errorf("LoadBytes: got %v and %v, want 1", v, err)
+	}
+
+	d := NewDecoder(bytes.NewReader(stream))
+	d.DisallowTrailingData = true
+	if err = d.Decode(&n); !errors.Is(err, ErrTrailingData) {
+		t.Errorf("Decode: got %v, want %v", err, ErrTrailingData)
+	}
+}
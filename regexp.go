@@ -0,0 +1,262 @@
+package rbmarshal
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The option bits of a marshaled regexp, as in Regexp#options.
+const (
+	regexpIgnoreCase = 1
+	regexpExtended   = 2
+	regexpMultiline  = 4
+
+	// Set on regexps with a source that isn't ASCII, which are bound to
+	// its encoding.
+	regexpFixedEncoding = 16
+)
+
+// Ruby's POSIX bracket classes match Unicode characters, while those of Go
+// only match ASCII. The negated forms, like [:^alpha:], are left to Go.
+var posixClasses = map[string]string{
+	"alpha": `\p{L}\p{M}`,
+	"alnum": `\p{L}\p{M}\p{Nd}`,
+	"digit": `\p{Nd}`,
+	"lower": `\p{Ll}`,
+	"upper": `\p{Lu}`,
+	"space": `\s\p{Z}`,
+	"word":  `\p{L}\p{M}\p{Nd}\p{Pc}`,
+}
+
+// translateRegexp rewrites the source of a Ruby regexp in the syntax of Go's
+// regexp package, so that it matches the same inputs. In Ruby ^ and $ always
+// match at line boundaries, and the m option is what Go calls s.
+//
+// Anything RE2 doesn't support, like backreferences or lookbehind, is left as
+// is and fails to compile.
+func translateRegexp(src string, options byte) string {
+	var sb strings.Builder
+
+	flags := "m"
+	if options&regexpIgnoreCase != 0 {
+		flags = "i" + flags
+	}
+	if options&regexpMultiline != 0 {
+		flags += "s"
+	}
+	sb.WriteString("(?" + flags + ")")
+
+	extended := options&regexpExtended != 0
+	// Whether we're inside a bracket class, and where it started, because
+	// a ] right after [ or [^ is a literal.
+	inClass := false
+	classStart := 0
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == '\\' && i+1 < len(src):
+			_, size := utf8.DecodeRuneInString(src[i+1:])
+			esc := src[i+1 : i+1+size]
+			i += 1 + size
+
+			switch esc {
+			case "Z":
+				// The end of the string, or before a final newline.
+				if !inClass {
+					sb.WriteString(`(?:\n?\z)`)
+					continue
+				}
+			case "h":
+				if inClass {
+					sb.WriteString(`0-9a-fA-F`)
+				} else {
+					sb.WriteString(`[0-9a-fA-F]`)
+				}
+				continue
+			case "H":
+				if !inClass {
+					sb.WriteString(`[^0-9a-fA-F]`)
+					continue
+				}
+			case " ":
+				// Go only allows escaping punctuation.
+				sb.WriteString(`\x20`)
+				continue
+			}
+			sb.WriteString(`\` + esc)
+			continue
+
+		case inClass:
+			if c == ']' && i > classStart {
+				inClass = false
+			} else if strings.HasPrefix(src[i:], "[:") {
+				if end := strings.Index(src[i:], ":]"); end > 0 {
+					if class, ok := posixClasses[src[i+2:i+end]]; ok {
+						sb.WriteString(class)
+						i += end + 2
+						continue
+					}
+				}
+			}
+
+		case c == '[':
+			inClass = true
+			classStart = i + 1
+			if strings.HasPrefix(src[i+1:], "^") {
+				classStart++
+			}
+
+		case c == '(' && strings.HasPrefix(src[i:], "(?<") &&
+			!strings.HasPrefix(src[i:], "(?<=") &&
+			!strings.HasPrefix(src[i:], "(?<!"):
+			// Named groups need the P before Go 1.22.
+			sb.WriteString("(?P<")
+			i += 3
+			continue
+
+		case c == '(' && strings.HasPrefix(src[i:], "(?#"):
+			// Comment groups run to the next closing parenthesis.
+			if end := strings.IndexByte(src[i:], ')'); end > 0 {
+				i += end + 1
+				continue
+			}
+
+		case c == '(' && strings.HasPrefix(src[i:], "(?"):
+			// Inline options, like (?m) or (?i-m:...).
+			if n := inlineOptionsLen(src[i+2:]); n > 0 {
+				sb.WriteString("(?" + strings.Replace(src[i+2:i+2+n], "m", "s", -1))
+				i += 2 + n
+				continue
+			}
+
+		case extended && c == '#':
+			if end := strings.IndexByte(src[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(src)
+			}
+			continue
+
+		case extended && c < utf8.RuneSelf && unicode.IsSpace(rune(c)):
+			i++
+			continue
+		}
+
+		sb.WriteByte(c)
+		i++
+	}
+
+	return sb.String()
+}
+
+// inlineOptionsLen returns how many bytes of s are inline options, like
+// "i-m" in (?i-m:...), or 0 if s doesn't start with any.
+func inlineOptionsLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'i', 'm', '-':
+		case ':', ')':
+			return i
+		default:
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// untranslateRegexp turns the source of a Go regexp back into a Ruby one and
+// its option bits, undoing what translateRegexp does. Go's m flag is dropped,
+// since ^ and $ always match at line boundaries in Ruby, and s becomes m.
+func untranslateRegexp(src string) (string, byte) {
+	var options byte
+	if n := goInlineOptionsLen(src); n > 0 && src[n+2] == ')' {
+		for _, c := range src[2 : n+2] {
+			switch c {
+			case 'i':
+				options |= regexpIgnoreCase
+			case 's':
+				options |= regexpMultiline
+			}
+		}
+		src = src[n+3:]
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(src); {
+		switch {
+		case src[i] == '\\' && i+1 < len(src):
+			sb.WriteString(src[i : i+2])
+			i += 2
+			continue
+		case strings.HasPrefix(src[i:], `(?:\n?\z)`):
+			sb.WriteString(`\Z`)
+			i += len(`(?:\n?\z)`)
+			continue
+		case strings.HasPrefix(src[i:], "(?P<"):
+			sb.WriteString("(?<")
+			i += 4
+			continue
+		case strings.HasPrefix(src[i:], "(?"):
+			if n := goInlineOptionsLen(src[i:]); n > 0 {
+				opts := rubyInlineOptions(src[i+2 : i+2+n])
+				end := src[i+2+n]
+				i += 3 + n
+				if opts != "" || end == ':' {
+					sb.WriteString("(?" + opts + string(end))
+				}
+				continue
+			}
+		}
+		sb.WriteByte(src[i])
+		i++
+	}
+	src = sb.String()
+
+	for i := 0; i < len(src); i++ {
+		if src[i] >= utf8.RuneSelf {
+			options |= regexpFixedEncoding
+			break
+		}
+	}
+
+	return src, options
+}
+
+// goInlineOptionsLen returns how many bytes of flags follow the "(?" that s
+// starts with, like "i-s" in (?i-s:...), or 0 if s doesn't start with any.
+func goInlineOptionsLen(s string) int {
+	if !strings.HasPrefix(s, "(?") {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		switch s[i] {
+		case 'i', 'm', 's', 'U', '-':
+		case ':', ')':
+			return i - 2
+		default:
+			return 0
+		}
+	}
+
+	return 0
+}
+
+// rubyInlineOptions rewrites Go inline flags as Ruby ones, dropping those
+// Ruby has no use for.
+func rubyInlineOptions(flags string) string {
+	var sb strings.Builder
+	for _, c := range flags {
+		switch c {
+		case 'i', '-':
+			sb.WriteRune(c)
+		case 's':
+			sb.WriteByte('m')
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "-")
+}
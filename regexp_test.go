@@ -0,0 +1,111 @@
+package rbmarshal
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestTranslateRegexp(t *testing.T) {
+	cases := []struct {
+		desc    string
+		src     string
+		options byte
+		want    string
+	}{
+		{"Anchors", `\Afoo\z`, 0, `(?m)\Afoo\z`},
+		{"End before a final newline", `foo\Z`, 0, `(?m)foo(?:\n?\z)`},
+		{"Hex digits", `\h+[\h_]\H`, 0, `(?m)[0-9a-fA-F]+[0-9a-fA-F_][^0-9a-fA-F]`},
+		{"POSIX class", `[[:alpha:]-]`, 0, `(?m)[\p{L}\p{M}-]`},
+		{"Negated POSIX class", `[[:^alpha:]]`, 0, `(?m)[[:^alpha:]]`},
+		{"Named group", `(?<year>\d+)(?<=a)`, 0, `(?m)(?P<year>\d+)(?<=a)`},
+		{"Comment group", `a(?# note)b`, 0, `(?m)ab`},
+		{"Inline options", `(?mi:a)(?-m)`, 0, `(?m)(?si:a)(?-s)`},
+		{"Escaped space", `a\ b`, 0, `(?m)a\x20b`},
+		{"Literal bracket in a class", `[]#]`, regexpExtended, `(?m)[]#]`},
+		{
+			"Extended",
+			"\\d+ # digits\n  [a ]\\ \\#x",
+			regexpExtended,
+			`(?m)\d+[a ]\x20\#x`,
+		},
+		{"All options", "a", regexpIgnoreCase | regexpMultiline, "(?ims)a"},
+		{"Encoding flags", "a", 16 | regexpIgnoreCase, "(?im)a"},
+	}
+
+	for _, c := range cases {
+		if got := translateRegexp(c.src, c.options); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestTranslateRegexpMatches(t *testing.T) {
+	cases := []struct {
+		src     string
+		options byte
+		input   string
+		want    bool
+	}{
+		{`^b`, 0, "a\nb", true},
+		{`a.b`, 0, "a\nb", false},
+		{`a.b`, regexpMultiline, "a\nb", true},
+		{`a\Z`, 0, "a\n", true},
+		{`a\z`, 0, "a\n", false},
+		{`[[:alpha:]]+`, 0, "é", true},
+		{"a b # c", regexpExtended, "ab", true},
+	}
+
+	for _, c := range cases {
+		x := regexp.MustCompile(translateRegexp(c.src, c.options))
+		if got := x.MatchString(c.input); got != c.want {
+			t.Errorf("/%s/ =~ %q: got %v, want %v", c.src, c.input, got, c.want)
+		}
+	}
+}
+
+func TestUntranslateRegexp(t *testing.T) {
+	cases := []struct {
+		desc    string
+		src     string
+		want    string
+		options byte
+	}{
+		{"Anchors", `(?m)\Afoo\z`, `\Afoo\z`, 0},
+		{"End before a final newline", `(?m)foo(?:\n?\z)`, `foo\Z`, 0},
+		{"Named group", `(?m)(?P<year>\d+)`, `(?<year>\d+)`, 0},
+		{"Inline options", `(?m)(?si:a)(?-s)`, `(?mi:a)(?-m)`, 0},
+		{"Inline multi-line", `(?m:a)(?m)b`, `(?:a)b`, 0},
+		{"Escaped parenthesis", `\(?P<a>`, `\(?P<a>`, 0},
+		{"All options", "(?ims)a", "a", regexpIgnoreCase | regexpMultiline},
+		{"No flags", "a", "a", 0},
+		{"Non-ASCII", "é", "é", regexpFixedEncoding},
+	}
+
+	for _, c := range cases {
+		got, options := untranslateRegexp(c.src)
+		if got != c.want || options != c.options {
+			t.Errorf("%s: got %q and %d, want %q and %d", c.desc, got, options, c.want, c.options)
+		}
+	}
+}
+
+func TestUntranslateRegexpRoundTrip(t *testing.T) {
+	cases := []struct {
+		src     string
+		options byte
+	}{
+		{`\Afoo\z`, 0},
+		{`^a$`, regexpIgnoreCase},
+		{`a.b`, regexpMultiline},
+		{`foo\Z`, regexpIgnoreCase | regexpMultiline},
+		{`(?<year>\d+)-(?<month>\d+)`, 0},
+		{`(?mi:a)(?-m)b`, 0},
+	}
+
+	for _, c := range cases {
+		src, options := untranslateRegexp(translateRegexp(c.src, c.options))
+		if src != c.src || options != c.options {
+			t.Errorf("/%s/%d: got /%s/%d", c.src, c.options, src, options)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package rbmarshal
+
+import "sync"
+
+// UserDefDecoder turns a user-defined object, typically the output of the
+// _dump method of its class, into a Go value.
+type UserDefDecoder func(UserDef) (interface{}, error)
+
+var userDefDecoders = struct {
+	sync.RWMutex
+	m map[string]UserDefDecoder
+}{m: make(map[string]UserDefDecoder)}
+
+// RegisterUserDef makes every load decode user-defined objects of class with
+// fn, unless LoadArg.UserDefDecoders says otherwise. Registering nil removes
+// the decoder of class.
+func RegisterUserDef(class string, fn UserDefDecoder) {
+	userDefDecoders.Lock()
+	defer userDefDecoders.Unlock()
+
+	if fn == nil {
+		delete(userDefDecoders.m, class)
+		return
+	}
+	userDefDecoders.m[class] = fn
+}
+
+// ObjectDecoder turns a plain object, such as one of a class that a gem
+// defines, into a Go value.
+type ObjectDecoder func(RObject) (interface{}, error)
+
+var objectDecoders = struct {
+	sync.RWMutex
+	m map[string]ObjectDecoder
+}{m: make(map[string]ObjectDecoder)}
+
+// RegisterClass makes every load decode plain objects of class with fn,
+// unless LoadArg.ObjectDecoders says otherwise. It takes precedence over the
+// decoding of the core classes that Load knows. Registering nil removes the
+// decoder of class.
+func RegisterClass(class string, fn ObjectDecoder) {
+	objectDecoders.Lock()
+	defer objectDecoders.Unlock()
+
+	if fn == nil {
+		delete(objectDecoders.m, class)
+		return
+	}
+	objectDecoders.m[class] = fn
+}
+
+// DataDecoder turns an object of a C extension class, dumped with
+// _dump_data, into a Go value.
+type DataDecoder func(RData) (interface{}, error)
+
+var dataDecoders = struct {
+	sync.RWMutex
+	m map[string]DataDecoder
+}{m: make(map[string]DataDecoder)}
+
+// RegisterData makes every load decode data objects of class with fn.
+// Registering nil removes the decoder of class, and such objects decode to
+// RData.
+func RegisterData(class string, fn DataDecoder) {
+	dataDecoders.Lock()
+	defer dataDecoders.Unlock()
+
+	if fn == nil {
+		delete(dataDecoders.m, class)
+		return
+	}
+	dataDecoders.m[class] = fn
+}
+
+// builtinObjects decode the core classes that are dumped as plain objects.
+var builtinObjects = map[string]func(RObject) (interface{}, error){
+	"IPAddr":    decodeIPAddr,
+	"Pathname":  decodePathname,
+	"Range":     decodeRange,
+	"Set":       decodeSet,
+	"SortedSet": decodeSet,
+}
+
+func init() {
+	for class := range uriDefaultPorts {
+		builtinObjects[class] = decodeURI
+	}
+}
+
+// builtinUserMarshals decode the core classes that dump themselves with
+// marshal_dump.
+var builtinUserMarshals = map[string]func(UserMarshal) (interface{}, error){
+	"Complex":    decodeComplex,
+	"Date":       decodeDate,
+	"DateTime":   decodeDate,
+	"OpenStruct": decodeOpenStruct,
+	"Rational":   decodeRational,
+}
+
+// builtinUserDefs decode the core classes that dump themselves with _dump.
+// Decoders registered for the same classes take precedence over them.
+var builtinUserDefs = map[string]UserDefDecoder{
+	"BigDecimal": decodeBigDecimal,
+	"Encoding":   decodeEncoding,
+	"Time":       decodeTime,
+}
+
+func userDefDecoder(class string, arg *LoadArg) (UserDefDecoder, bool) {
+	if fn, ok := arg.UserDefDecoders[class]; ok {
+		return fn, true
+	}
+
+	userDefDecoders.RLock()
+	fn, ok := userDefDecoders.m[class]
+	userDefDecoders.RUnlock()
+	if ok {
+		return fn, true
+	}
+
+	fn, ok = builtinUserDefs[class]
+	return fn, ok
+}
+
+// loadUserDef hands u to the decoder of its class, if there is one.
+func loadUserDef(u UserDef, arg *LoadArg) (interface{}, error) {
+	fn, ok := userDefDecoder(u.Class, arg)
+	if !ok {
+		return u, nil
+	}
+
+	return fn(u)
+}
+
+// loadUserMarshal decodes u if it is of a core class that is known, and leaves
+// it as it is otherwise.
+func loadUserMarshal(u UserMarshal) (interface{}, error) {
+	fn, ok := builtinUserMarshals[u.Class]
+	if !ok {
+		return u, nil
+	}
+
+	return fn(u)
+}
+
+// loadData hands d to the decoder registered for its class, if there is one.
+func loadData(d RData) (interface{}, error) {
+	dataDecoders.RLock()
+	fn, ok := dataDecoders.m[d.Class]
+	dataDecoders.RUnlock()
+	if !ok {
+		return d, nil
+	}
+
+	return fn(d)
+}
+
+// loadObject hands o to the decoder of its class, if there is one, or else
+// decodes it if it is of a core class that is known, or an exception. It
+// leaves o as it is otherwise.
+func loadObject(o RObject, arg *LoadArg) (interface{}, error) {
+	if fn, ok := arg.ObjectDecoders[o.Class]; ok {
+		return fn(o)
+	}
+
+	objectDecoders.RLock()
+	fn, ok := objectDecoders.m[o.Class]
+	objectDecoders.RUnlock()
+	if ok {
+		return fn(o)
+	}
+
+	if fn, ok := builtinObjects[o.Class]; ok {
+		return fn(o)
+	}
+	if isException(o) {
+		return decodeException(o)
+	}
+
+	return o, nil
+}
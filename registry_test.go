@@ -0,0 +1,169 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUserDefDecoders(t *testing.T) {
+	// f = Foo.new; [f, Bar.new, f]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x08, 0x75, 0x3a, 0x08, 0x46,
+		0x6f, 0x6f, 0x07, 0x00, 0xff, 0x75, 0x3a, 0x08,
+		0x42, 0x61, 0x72, 0x06, 0x01, 0x40, 0x06,
+	}
+
+	hexDecoder := func(u UserDef) (interface{}, error) {
+		return hex.EncodeToString(u.Data), nil
+	}
+	failing := errors.New("cannot load Bar")
+
+	RegisterUserDef("Foo", hexDecoder)
+	defer RegisterUserDef("Foo", nil)
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		err  error
+		data interface{}
+	}{
+		{
+			"Registered decoder",
+			&LoadArg{},
+			nil,
+			makeSlice("00ff", UserDef{"Bar", []byte{0x01}, nil}, "00ff"),
+		},
+		{
+			"Decoders of LoadArg",
+			&LoadArg{
+				UserDefDecoders: map[string]UserDefDecoder{
+					"Foo": func(u UserDef) (interface{}, error) {
+						return len(u.Data), nil
+					},
+					"Bar": hexDecoder,
+				},
+			},
+			nil,
+			makeSlice(2, "01", 2),
+		},
+		{
+			"Failing decoder",
+			&LoadArg{
+				UserDefDecoders: map[string]UserDefDecoder{
+					"Bar": func(u UserDef) (interface{}, error) {
+						return nil, failing
+					},
+				},
+			},
+			failing,
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), c.arg)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("error: got %v, want %v", err, c.err)
+			}
+			if c.err == nil && !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
+
+func TestDataDecoders(t *testing.T) {
+	// d = Foo data object dumping [1]; [d, d]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x07, 0x64, 0x3a, 0x08, 0x46,
+		0x6f, 0x6f, 0x5b, 0x06, 0x69, 0x06, 0x40, 0x06,
+	}
+
+	data, err := Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	d := RData{"Foo", makeSlice(1)}
+	if want := makeSlice(d, d); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+
+	RegisterData("Foo", func(d RData) (interface{}, error) {
+		return len(d.Data.([]interface{})), nil
+	})
+	defer RegisterData("Foo", nil)
+
+	data, err = Load(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if want := makeSlice(1, 1); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+}
+
+func TestObjectDecoders(t *testing.T) {
+	// m = Money.new(150, "USD"); [m, Point.new, m]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x08, 0x6f, 0x3a, 0x0a, 0x4d,
+		0x6f, 0x6e, 0x65, 0x79, 0x07, 0x3a, 0x0b, 0x40,
+		0x63, 0x65, 0x6e, 0x74, 0x73, 0x69, 0x01, 0x96,
+		0x3a, 0x0e, 0x40, 0x63, 0x75, 0x72, 0x72, 0x65,
+		0x6e, 0x63, 0x79, 0x49, 0x22, 0x08, 0x55, 0x53,
+		0x44, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x6f, 0x3a,
+		0x0a, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x00, 0x40,
+		0x06,
+	}
+
+	type money struct {
+		Cents    int
+		Currency string
+	}
+	RegisterClass("Money", func(o RObject) (interface{}, error) {
+		return money{o.Ivars["@cents"].(int), o.Ivars["@currency"].(string)}, nil
+	})
+	defer RegisterClass("Money", nil)
+
+	m := money{150, "USD"}
+	point := RObject{"Point", map[string]interface{}{}}
+
+	data, err := Load(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := makeSlice(m, point, m); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+
+	// Those of a decoder come first.
+	d := NewDecoder(bytes.NewReader(stream))
+	d.RegisterClass("Money", func(o RObject) (interface{}, error) {
+		return o.Ivars["@cents"], nil
+	})
+	d.RegisterClass("Point", func(o RObject) (interface{}, error) {
+		return "point", nil
+	})
+	d.RegisterClass("Point", nil)
+	if err = d.Decode(&data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := makeSlice(150, point, 150); !reflect.DeepEqual(data, want) {
+		t.Errorf("data: got %v, want %v", data, want)
+	}
+
+	// A failing decoder fails the load.
+	failing := errors.New("cannot load Money")
+	arg := &LoadArg{ObjectDecoders: map[string]ObjectDecoder{
+		"Money": func(o RObject) (interface{}, error) {
+			return nil, failing
+		},
+	}}
+	if _, err = LoadWith(bytes.NewReader(stream), arg); !errors.Is(err, failing) {
+		t.Errorf("error: got %v, want %v", err, failing)
+	}
+}
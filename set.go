@@ -0,0 +1,31 @@
+package rbmarshal
+
+import "fmt"
+
+// RSet is a Set or a SortedSet, with its members in the order they were
+// added. SortedSet members come in that order too, as Go can't sort values
+// of arbitrary types the way Ruby does.
+type RSet struct {
+	Class   string
+	Members []interface{}
+}
+
+var setClasses = map[string]bool{"Set": true, "SortedSet": true}
+
+// setMembers are the keys of the hash a Set keeps its members in, in order.
+// Set always maps its members to true, so the values aren't needed.
+type setMembers []interface{}
+
+func decodeSet(o RObject) (interface{}, error) {
+	h := o.Ivars["@hash"]
+	if hd, ok := h.(HashWithDefault); ok {
+		h = hd.Hash
+	}
+
+	members, ok := h.(setMembers)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s ivars %v", o.Class, o.Ivars)
+	}
+
+	return RSet{Class: o.Class, Members: []interface{}(members)}, nil
+}
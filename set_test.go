@@ -0,0 +1,80 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSet(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		data   interface{}
+	}{
+		{
+			// Set[1, "a", [2]]
+			"Set",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x08, 0x53, 0x65, 0x74,
+				0x06, 0x3a, 0x0a, 0x40, 0x68, 0x61, 0x73, 0x68,
+				0x7d, 0x08, 0x69, 0x06, 0x54, 0x49, 0x22, 0x06,
+				0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x54, 0x5b,
+				0x06, 0x69, 0x07, 0x54, 0x46,
+			},
+			RSet{"Set", makeSlice(1, "a", makeSlice(2))},
+		},
+		{
+			// Set.new, from a Ruby whose Set had no default
+			"Empty set",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x08, 0x53, 0x65, 0x74,
+				0x06, 0x3a, 0x0a, 0x40, 0x68, 0x61, 0x73, 0x68,
+				0x7b, 0x00,
+			},
+			RSet{"Set", makeSlice()},
+		},
+		{
+			// SortedSet[3, 1]
+			"Sorted set",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0e, 0x53, 0x6f, 0x72,
+				0x74, 0x65, 0x64, 0x53, 0x65, 0x74, 0x07, 0x3a,
+				0x0a, 0x40, 0x68, 0x61, 0x73, 0x68, 0x7d, 0x07,
+				0x69, 0x08, 0x54, 0x69, 0x06, 0x54, 0x46, 0x3a,
+				0x0a, 0x40, 0x6b, 0x65, 0x79, 0x73, 0x30,
+			},
+			RSet{"SortedSet", makeSlice(3, 1)},
+		},
+		{
+			// s = Set[{"a"=>1}]; [s, s]
+			"Set of hashes with a link",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x6f, 0x3a, 0x08, 0x53,
+				0x65, 0x74, 0x06, 0x3a, 0x0a, 0x40, 0x68, 0x61,
+				0x73, 0x68, 0x7d, 0x06, 0x7b, 0x06, 0x49, 0x22,
+				0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x69,
+				0x06, 0x54, 0x46, 0x40, 0x06,
+			},
+			makeSlice(
+				RSet{"Set", makeSlice(map[string]interface{}{"a": 1})},
+				RSet{"Set", makeSlice(map[string]interface{}{"a": 1})},
+			),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := Load(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %v, want %v", data, c.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+# Captures the compatibility fixtures of rbmarshal with the Ruby that runs it.
+# Every case is dumped to <engine>-<version>/<name>.bin next to this script,
+# along with RUBY_DESCRIPTION in VERSION, so that one run per implementation
+# gives a directory of its own:
+#
+#   ruby testdata/compat/generate.rb
+#   jruby testdata/compat/generate.rb
+#   truffleruby testdata/compat/generate.rb
+#
+# The names and values must match compatCases in compat_test.go.
+
+CompatPoint = Struct.new(:x, :y)
+
+class CompatObject
+  def initialize
+    @name = "obj"
+    @count = 3
+  end
+end
+
+shared = "x"
+
+CASES = {
+  "fixnum_zero" => 0,
+  "fixnum_small" => 122,
+  "fixnum_one_byte" => 123,
+  "fixnum_negative" => -124,
+  "fixnum_two_bytes" => 256,
+  "fixnum_max" => 2**30 - 1,
+  "fixnum_min" => -(2**30),
+  "bignum_2_30" => 2**30,
+  "bignum_2_64" => 2**64,
+  "bignum_negative" => -(2**64) - 1,
+  "bignum_2_100" => 2**100,
+  "float_one_and_a_half" => 1.5,
+  "float_third" => 1.0 / 3,
+  "float_big" => 1e100,
+  "float_tiny" => 2.0**-1074,
+  "float_negative_zero" => -0.0,
+  "float_infinity" => Float::INFINITY,
+  "float_negative_infinity" => -Float::INFINITY,
+  "float_nan" => Float::NAN,
+  "string_utf8" => "héllo",
+  "string_binary" => "abc".b,
+  "symbol" => :sym,
+  "symbols_repeated" => [:a, :a],
+  "string_shared" => [shared, shared],
+  "array_nested" => [1, [2, [3]]],
+  "hash_mixed_keys" => { a: 1, "b" => 2 },
+  "struct" => CompatPoint.new(1, 2),
+  "object" => CompatObject.new,
+  "regexp" => /a+b/i,
+  "time_nsec" => Time.at(1_700_000_000, 123_456_789, :nsec).utc,
+}
+
+dir = File.join(__dir__, "#{RUBY_ENGINE}-#{RUBY_ENGINE_VERSION}")
+Dir.mkdir(dir) unless Dir.exist?(dir)
+File.write(File.join(dir, "VERSION"), RUBY_DESCRIPTION + "\n")
+CASES.each do |name, value|
+  File.binwrite(File.join(dir, "#{name}.bin"), Marshal.dump(value))
+end
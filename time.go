@@ -0,0 +1,140 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// decodeTime turns the output of Time#_dump into a time.Time. The eight bytes
+// of data hold the time in UTC, packed into two little-endian words, and the
+// ivars hold what doesn't fit there: the UTC offset and the name of the zone,
+// the nanoseconds below a microsecond and years past 65535+1900.
+//
+// Dumps of Ruby 1.8 and older hold the seconds and the microseconds since the
+// epoch instead, and those load in the local time zone, like Ruby does.
+func decodeTime(u UserDef) (interface{}, error) {
+	if len(u.Data) != 8 {
+		return nil, fmt.Errorf("invalid Time data length %d", len(u.Data))
+	}
+
+	p := binary.LittleEndian.Uint32(u.Data[:4])
+	s := binary.LittleEndian.Uint32(u.Data[4:])
+	if p&(1<<31) == 0 {
+		return time.Unix(int64(p), int64(s)*1000), nil
+	}
+
+	utc := p&(1<<30) != 0
+	year := int(p>>14&0xffff) + 1900
+	if y, ok := intOf(u.Ivars["year"]); ok {
+		year = y
+	}
+	month := time.Month(p>>10&0xf + 1)
+	day := int(p >> 5 & 0x1f)
+	hour := int(p & 0x1f)
+	min := int(s >> 26 & 0x3f)
+	sec := int(s >> 20 & 0x3f)
+	nsec := int(s&0xfffff) * 1000
+
+	num, numOK := intOf(u.Ivars["nano_num"])
+	den, denOK := intOf(u.Ivars["nano_den"])
+	if numOK && denOK && den != 0 {
+		nsec += num / den
+	} else if submicro, ok := stringValue(u.Ivars["submicro"]); ok {
+		nsec += submicroNsec(submicro)
+	}
+
+	t := time.Date(year, month, day, hour, min, sec, nsec, time.UTC)
+	if utc {
+		return t, nil
+	}
+
+	offset, ok := intOf(u.Ivars["offset"])
+	if !ok {
+		return t.Local(), nil
+	}
+	zone, _ := stringValue(u.Ivars["zone"])
+
+	return t.In(time.FixedZone(zone, offset)), nil
+}
+
+// submicroNsec decodes the nanoseconds that Ruby 1.9.1 stored as up to three
+// packed BCD digits, the way marshal_load of Time does.
+func submicroNsec(submicro string) int {
+	digits := make([]byte, 0, 3)
+	if len(submicro) > 0 {
+		digits = append(digits, submicro[0]>>4, submicro[0]&0xf)
+	}
+	if len(submicro) > 1 {
+		digits = append(digits, submicro[1]>>4)
+	}
+
+	nsec := 0
+	scale := 100
+	for _, digit := range digits {
+		if digit > 9 {
+			break
+		}
+		nsec += int(digit) * scale
+		scale /= 10
+	}
+
+	return nsec
+}
+
+// dumpTime writes t the way Time#_dump does. A time in time.UTC is a UTC time
+// in Ruby, and any other one keeps its offset and the name of its zone.
+func dumpTime(w *bufio.Writer, arg *dumpArg, t time.Time) error {
+	u := t.UTC()
+
+	var ivars []dumpIvar
+	year := u.Year()
+	if year < 1900 || year > 1900+0xffff {
+		ivars = append(ivars, dumpIvar{"year", year})
+		if year < 1900 {
+			year = 1900
+		} else {
+			year = 1900 + 0xffff
+		}
+	}
+
+	p := uint32(1)<<31 |
+		uint32(year-1900)<<14 |
+		uint32(u.Month()-1)<<10 |
+		uint32(u.Day())<<5 |
+		uint32(u.Hour())
+	if t.Location() == time.UTC {
+		p |= 1 << 30
+	}
+	s := uint32(u.Minute())<<26 |
+		uint32(u.Second())<<20 |
+		uint32(u.Nanosecond()/1000)
+
+	var data [8]byte
+	binary.LittleEndian.PutUint32(data[:4], p)
+	binary.LittleEndian.PutUint32(data[4:], s)
+
+	// Ruby 1.9.1 only reads the nanoseconds from submicro, packed BCD.
+	if nsec := u.Nanosecond() % 1000; nsec != 0 {
+		submicro := []byte{byte(nsec/100<<4 | nsec/10%10), byte(nsec % 10 << 4)}
+		if submicro[1] == 0 {
+			submicro = submicro[:1]
+		}
+		ivars = append(ivars,
+			dumpIvar{"nano_num", nsec},
+			dumpIvar{"nano_den", 1},
+			dumpIvar{"submicro", submicro},
+		)
+	}
+
+	zone, offset := t.Zone()
+	if t.Location() != time.UTC {
+		ivars = append(ivars, dumpIvar{"offset", offset})
+	}
+	if zone != "" {
+		ivars = append(ivars, dumpIvar{"zone", RString{zone, "US-ASCII", nil}})
+	}
+
+	return dumpUserDef(w, arg, "Time", data[:], ivars)
+}
@@ -0,0 +1,175 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLoadTime(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+
+	cases := []struct {
+		desc   string
+		stream []byte
+		arg    *LoadArg
+		time   time.Time
+		zone   string
+	}{
+		{
+			// Time.at(0).utc
+			"UTC",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+				0x6d, 0x65, 0x0d, 0x20, 0x80, 0x11, 0xc0, 0x00,
+				0x00, 0x00, 0x00, 0x06, 0x3a, 0x09, 0x7a, 0x6f,
+				0x6e, 0x65, 0x49, 0x22, 0x08, 0x55, 0x54, 0x43,
+				0x06, 0x3a, 0x06, 0x45, 0x46,
+			},
+			&LoadArg{},
+			time.Unix(0, 0),
+			"UTC",
+		},
+		{
+			// Time.new(2020, 5, 17, 12, 34, 56.123456789r, "+09:00"),
+			// in the JST zone
+			"Offset and nanoseconds",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+				0x6d, 0x65, 0x0d, 0x23, 0x12, 0x1e, 0x80, 0x40,
+				0xe2, 0x81, 0x8b, 0x09, 0x3a, 0x0b, 0x6f, 0x66,
+				0x66, 0x73, 0x65, 0x74, 0x69, 0x02, 0x90, 0x7e,
+				0x3a, 0x09, 0x7a, 0x6f, 0x6e, 0x65, 0x49, 0x22,
+				0x08, 0x4a, 0x53, 0x54, 0x06, 0x3a, 0x06, 0x45,
+				0x46, 0x3a, 0x0d, 0x6e, 0x61, 0x6e, 0x6f, 0x5f,
+				0x6e, 0x75, 0x6d, 0x69, 0x02, 0x15, 0x03, 0x3a,
+				0x0d, 0x6e, 0x61, 0x6e, 0x6f, 0x5f, 0x64, 0x65,
+				0x6e, 0x69, 0x06,
+			},
+			&LoadArg{},
+			time.Date(2020, 5, 17, 12, 34, 56, 123456789, jst),
+			"JST",
+		},
+		{
+			// The same, with the zone name as an RString.
+			"Offset and nanoseconds with string encodings",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+				0x6d, 0x65, 0x0d, 0x23, 0x12, 0x1e, 0x80, 0x40,
+				0xe2, 0x81, 0x8b, 0x09, 0x3a, 0x0b, 0x6f, 0x66,
+				0x66, 0x73, 0x65, 0x74, 0x69, 0x02, 0x90, 0x7e,
+				0x3a, 0x09, 0x7a, 0x6f, 0x6e, 0x65, 0x49, 0x22,
+				0x08, 0x4a, 0x53, 0x54, 0x06, 0x3a, 0x06, 0x45,
+				0x46, 0x3a, 0x0d, 0x6e, 0x61, 0x6e, 0x6f, 0x5f,
+				0x6e, 0x75, 0x6d, 0x69, 0x02, 0x15, 0x03, 0x3a,
+				0x0d, 0x6e, 0x61, 0x6e, 0x6f, 0x5f, 0x64, 0x65,
+				0x6e, 0x69, 0x06,
+			},
+			&LoadArg{StringEncodings: true},
+			time.Date(2020, 5, 17, 12, 34, 56, 123456789, jst),
+			"JST",
+		},
+		{
+			// Ruby 1.9.1 dumped the nanoseconds as packed BCD.
+			"Submicro",
+			[]byte{
+				0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+				0x6d, 0x65, 0x0d, 0x20, 0x80, 0x11, 0xc0, 0x01,
+				0x00, 0x00, 0x00, 0x06, 0x3a, 0x0d, 0x73, 0x75,
+				0x62, 0x6d, 0x69, 0x63, 0x72, 0x6f, 0x22, 0x07,
+				0x78, 0x90,
+			},
+			&LoadArg{},
+			time.Unix(0, 1789),
+			"UTC",
+		},
+		{
+			// Ruby 1.8 dumped the seconds since the epoch.
+			"Old format",
+			[]byte{
+				0x04, 0x08, 0x75, 0x3a, 0x09, 0x54, 0x69, 0x6d,
+				0x65, 0x0d, 0x00, 0xe1, 0xf5, 0x05, 0x40, 0x42,
+				0x0f, 0x00,
+			},
+			&LoadArg{},
+			time.Unix(100000001, 0),
+			time.Unix(100000001, 0).Format("MST"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(c.stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			tm, ok := data.(time.Time)
+			if !ok {
+				t.Fatalf("data: got %v, want a time.Time", data)
+			}
+			if !tm.Equal(c.time) {
+				t.Errorf("time: got %v, want %v", tm, c.time)
+			}
+			if zone, _ := tm.Zone(); zone != c.zone {
+				t.Errorf("zone: got %q, want %q", zone, c.zone)
+			}
+		})
+	}
+}
+
+func TestDumpTime(t *testing.T) {
+	// Time.utc(2020, 5, 17, 12, 30, 45)
+	want := []byte{
+		0x04, 0x08, 0x49, 0x75, 0x3a, 0x09, 0x54, 0x69,
+		0x6d, 0x65, 0x0d, 0x2c, 0x12, 0x1e, 0xc0, 0x00,
+		0x00, 0xd0, 0x7a, 0x06, 0x3a, 0x09, 0x7a, 0x6f,
+		0x6e, 0x65, 0x49, 0x22, 0x08, 0x55, 0x54, 0x43,
+		0x06, 0x3a, 0x06, 0x45, 0x46,
+	}
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, time.Date(2020, 5, 17, 12, 30, 45, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("stream: got %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestDumpTimeRoundTrip(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	times := []time.Time{
+		time.Date(2020, 5, 17, 12, 30, 45, 123456789, time.UTC),
+		time.Date(2020, 5, 17, 12, 30, 45, 123456000, cet),
+		time.Date(1999, 12, 31, 23, 59, 59, 500, time.FixedZone("", -5*3600)),
+		time.Date(1850, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(70000, 1, 1, 0, 0, 0, 10, cet),
+	}
+
+	for _, tm := range times {
+		var buf bytes.Buffer
+		if err := Dump(&buf, makeSlice(&tm, &tm)); err != nil {
+			t.Fatalf("%v: unexpected error: '%q'", tm, err)
+		}
+
+		data, err := Load(bufio.NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%v: unexpected error: '%q'", tm, err)
+		}
+		for _, v := range data.([]interface{}) {
+			got, ok := v.(time.Time)
+			if !ok || !got.Equal(tm) {
+				t.Errorf("%v: read back %v", tm, v)
+				continue
+			}
+			name, offset := got.Zone()
+			wantName, wantOffset := tm.Zone()
+			if name != wantName || offset != wantOffset {
+				t.Errorf("%v: read back zone %s %d", tm, name, offset)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package rbmarshal
+
+import (
+	"errors"
+	"io"
+)
+
+// A Token is a piece of Marshal data as Decoder.Token returns it. Arrays,
+// hashes and plain objects are streamed: they show up as an ArrayStart,
+// HashStart or ObjectStart, then the tokens of what they hold, then an End.
+// Any other value is a token of its own, decoded the way Load does, such as
+// nil, a bool, an int, a *big.Int, a float64, a string or a Symbol.
+type Token interface{}
+
+// ArrayStart starts an array of Len elements.
+type ArrayStart struct {
+	Len int
+}
+
+// HashStart starts a hash of Len pairs, each one a key followed by a value.
+// A hash with a default value, like Hash.new(0), has the default after its
+// pairs.
+type HashStart struct {
+	Len     int
+	Default bool
+}
+
+// ObjectStart starts a plain object of class Class with Len instance
+// variables, each one a name, like "@foo", followed by a value. Objects of
+// the core classes that Load knows are streamed as they were marshaled too.
+type ObjectStart struct {
+	Class string
+	Len   int
+}
+
+// End ends the array, hash or object that was started last.
+type End struct{}
+
+// A tokenFrame is an array, hash or object being streamed.
+type tokenFrame struct {
+	t    byte
+	left int // tokens of the values still to come
+}
+
+// Token returns the next token of the stream, so that huge dumps can be
+// processed without building them in memory. At the end of the stream Token
+// returns io.EOF. Token and Decode can be mixed: inside an array, a hash or an
+// object, Decode reads the next value whole.
+//
+// Streamed values aren't kept, so links to them can't be followed and fail.
+func (d *Decoder) Token() (tok Token, err error) {
+	defer recoverPanic(&err, &d.LoadArg)
+
+	tok, err = d.token()
+	if err == io.EOF && !d.inDump {
+		// The stream ended between dumps.
+		return nil, err
+	}
+
+	return tok, decodeError(err, &d.LoadArg)
+}
+
+func (d *Decoder) token() (Token, error) {
+	arg := &d.LoadArg
+	if d.atEnd() {
+		d.frames = d.frames[:len(d.frames)-1]
+		d.valueDone()
+		return End{}, nil
+	}
+
+	if !d.inDump {
+		if _, err := d.r.Peek(1); err != nil {
+			return nil, err
+		}
+		d.nextDump()
+		resetLoadArg(arg)
+		d.inDump = true
+		if err := validateVersion(d.r, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.atName() {
+		return d.readIvarName()
+	}
+
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case typeArray, typeHash, typeHashDef, typeObject:
+		return d.startToken(b[0])
+	}
+
+	arg.depth = len(d.frames)
+	v, err := read(d.r, arg)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil && b[0] == typeObjlink {
+		return nil, errors.New("cannot stream a link to an array, a hash or an object")
+	}
+	d.valueDone()
+
+	return v, nil
+}
+
+func (d *Decoder) startToken(t byte) (Token, error) {
+	arg := &d.LoadArg
+	if _, err := readByte(d.r, arg); err != nil {
+		return nil, err
+	}
+
+	var class string
+	if t == typeObject {
+		var err error
+		if class, err = readClassName(d.r, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	size, err := readCollectionLen(d.r, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Streamed containers count towards MaxDepth like the values read
+	// whole inside them.
+	arg.depth = len(d.frames)
+	if err = enterValue(arg); err != nil {
+		return nil, err
+	}
+
+	// Streamed values aren't kept, but they still occupy a slot in the
+	// object table.
+	arg.Objects = append(arg.Objects, nil)
+
+	f := tokenFrame{t: t, left: size}
+	if t != typeArray {
+		f.left *= 2
+	}
+	if t == typeHashDef {
+		f.left++
+	}
+	d.frames = append(d.frames, f)
+
+	switch t {
+	case typeArray:
+		return ArrayStart{Len: size}, nil
+	case typeObject:
+		return ObjectStart{Class: class, Len: size}, nil
+	default:
+		return HashStart{Len: size, Default: t == typeHashDef}, nil
+	}
+}
+
+// decodeValue reads the next value whole, in the middle of a dump that is
+// being streamed. Errors come back as a DecodeError.
+func (d *Decoder) decodeValue() (v interface{}, err error) {
+	defer recoverPanic(&err, &d.LoadArg)
+
+	if d.atEnd() {
+		return nil, decodeError(errNoValueLeft, &d.LoadArg)
+	}
+	if d.atName() {
+		name, err := d.readIvarName()
+		return name, decodeError(err, &d.LoadArg)
+	}
+
+	d.depth = len(d.frames)
+	v, err = read(d.r, &d.LoadArg)
+	if err != nil {
+		return nil, decodeError(err, &d.LoadArg)
+	}
+	d.valueDone()
+
+	return v, nil
+}
+
+// skipValue is like decodeValue but reads past the value.
+func (d *Decoder) skipValue() error {
+	if d.atEnd() {
+		return errNoValueLeft
+	}
+	if d.atName() {
+		_, err := d.readIvarName()
+		return err
+	}
+
+	d.depth = len(d.frames)
+	if err := skipValue(d.r, &d.LoadArg); err != nil {
+		return err
+	}
+	d.valueDone()
+
+	return nil
+}
+
+var errNoValueLeft = errors.New("no value is left before the end of the container")
+
+// atEnd tells whether the next token is the End of a container.
+func (d *Decoder) atEnd() bool {
+	n := len(d.frames)
+	return n > 0 && d.frames[n-1].left == 0
+}
+
+// atName tells whether the next token is the name of an ivar.
+func (d *Decoder) atName() bool {
+	n := len(d.frames)
+	return n > 0 && d.frames[n-1].t == typeObject && d.frames[n-1].left%2 == 0
+}
+
+// Ivar names stay strings, as in an RObject.
+func (d *Decoder) readIvarName() (string, error) {
+	name, err := readName(d.r, &d.LoadArg)
+	if err != nil {
+		return "", err
+	}
+	d.frames[len(d.frames)-1].left--
+
+	return name, nil
+}
+
+// valueDone records that a whole value was read, which is either the next one
+// of the innermost frame or the whole dump.
+func (d *Decoder) valueDone() {
+	if n := len(d.frames); n > 0 {
+		d.frames[n-1].left--
+		return
+	}
+	d.inDump = false
+}
@@ -0,0 +1,172 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// tokens reads every token of stream.
+func tokens(stream []byte) ([]Token, error) {
+	d := NewDecoder(bytes.NewReader(stream))
+	var toks []Token
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return toks, nil
+		}
+		if err != nil {
+			return toks, err
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func TestDecoderToken(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		toks   []Token
+	}{
+		{
+			"Scalar",
+			[]byte{0x04, 0x08, 0x69, 0x01, 0x7b},
+			[]Token{123},
+		},
+		{
+			// [1, {a: "x"}, Point.new(1, [2])]
+			"Nested",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x69, 0x06, 0x7b, 0x06,
+				0x3a, 0x06, 0x61, 0x49, 0x22, 0x06, 0x78, 0x06,
+				0x3a, 0x06, 0x45, 0x54, 0x6f, 0x3a, 0x0a, 0x50,
+				0x6f, 0x69, 0x6e, 0x74, 0x07, 0x3a, 0x07, 0x40,
+				0x78, 0x69, 0x06, 0x3a, 0x07, 0x40, 0x79, 0x5b,
+				0x06, 0x69, 0x07,
+			},
+			[]Token{
+				ArrayStart{3},
+				1,
+				HashStart{Len: 1},
+				Symbol("a"), "x",
+				End{},
+				ObjectStart{"Point", 2},
+				"@x", 1,
+				"@y", ArrayStart{1}, 2, End{},
+				End{},
+				End{},
+			},
+		},
+		{
+			// Hash.new(0)
+			"Hash with a default",
+			[]byte{0x04, 0x08, 0x7d, 0x00, 0x69, 0x00},
+			[]Token{HashStart{Len: 0, Default: true}, 0, End{}},
+		},
+		{
+			// s = "x"; [s, s]
+			"Link to a string",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x78,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06,
+			},
+			[]Token{ArrayStart{2}, "x", "x", End{}},
+		},
+		{
+			"Two dumps",
+			[]byte{
+				0x04, 0x08, 0x5b, 0x00,
+				0x04, 0x08, 0x5b, 0x06, 0x54,
+			},
+			[]Token{ArrayStart{0}, End{}, ArrayStart{1}, true, End{}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			toks, err := tokens(c.stream)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(toks, c.toks) {
+				t.Errorf("got %#v, want %#v", toks, c.toks)
+			}
+		})
+	}
+}
+
+func TestDecoderTokenErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		err    error
+	}{
+		{
+			"Truncated array",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06},
+			&DecodeError{Offset: 6, Err: io.ErrUnexpectedEOF},
+		},
+		{
+			"Truncated header",
+			[]byte{0x04},
+			&DecodeError{Offset: 1, Err: io.ErrUnexpectedEOF},
+		},
+		{
+			// a = []; [a, a]
+			"Link to a streamed array",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x5b, 0x00, 0x40, 0x06},
+			&DecodeError{
+				Offset: 8,
+				Err:    errors.New("cannot stream a link to an array, a hash or an object"),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			_, err := tokens(c.stream)
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestDecoderTokenMixedWithDecode(t *testing.T) {
+	// [{a: 1}, [2, 3]], then 4.
+	d := NewDecoder(bytes.NewReader([]byte{
+		0x04, 0x08, 0x5b, 0x07, 0x7b, 0x06, 0x3a, 0x06,
+		0x61, 0x69, 0x06, 0x5b, 0x07, 0x69, 0x07, 0x69,
+		0x08, 0x04, 0x08, 0x69, 0x09,
+	}))
+
+	tok, err := d.Token()
+	if err != nil || tok != (ArrayStart{2}) {
+		t.Fatalf("got %v and %v, want ArrayStart{2}", tok, err)
+	}
+
+	var h struct{ A int }
+	if err = d.Decode(&h); err != nil || h.A != 1 {
+		t.Fatalf("got %v and %v, want {1}", h, err)
+	}
+	var a []int
+	if err = d.Decode(&a); err != nil || !reflect.DeepEqual(a, []int{2, 3}) {
+		t.Fatalf("got %v and %v, want [2 3]", a, err)
+	}
+	var n int
+	if err = d.Decode(&n); err == nil {
+		t.Fatal("decoding past the end of the array didn't fail")
+	}
+
+	if tok, err = d.Token(); err != nil || tok != (End{}) {
+		t.Fatalf("got %v and %v, want End{}", tok, err)
+	}
+	if err = d.Decode(&n); err != nil || n != 4 {
+		t.Fatalf("got %v and %v, want 4", n, err)
+	}
+	if _, err = d.Token(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,48 @@
+package rbmarshal
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// rubyEncodings are the names Ruby gives encodings that the IANA registry
+// either doesn't know or knows under another name.
+var rubyEncodings = map[string]encoding.Encoding{
+	"Windows-31J": japanese.ShiftJIS,
+	"CP932":       japanese.ShiftJIS,
+	"SJIS":        japanese.ShiftJIS,
+	"eucJP":       japanese.EUCJP,
+	"CP51932":     japanese.EUCJP,
+	"macRoman":    charmap.Macintosh,
+	"CP1250":      charmap.Windows1250,
+	"CP1251":      charmap.Windows1251,
+	"CP1252":      charmap.Windows1252,
+}
+
+// transcode converts str from the encoding Ruby calls enc to UTF-8. Binary and
+// US-ASCII strings need no conversion.
+func transcode(str, enc string) (string, error) {
+	switch enc {
+	case "ASCII-8BIT", "US-ASCII", "UTF-8":
+		return str, nil
+	}
+
+	e, ok := rubyEncodings[enc]
+	if !ok {
+		var err error
+		if e, err = ianaindex.IANA.Encoding(enc); err != nil || e == nil {
+			return "", fmt.Errorf("cannot transcode from %s", enc)
+		}
+	}
+
+	s, err := e.NewDecoder().String(str)
+	if err != nil {
+		return "", fmt.Errorf("cannot transcode from %s: %w", enc, err)
+	}
+
+	return s, nil
+}
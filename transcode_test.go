@@ -0,0 +1,86 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLoadWithTranscodeToUTF8(t *testing.T) {
+	// ["日本".encode("Shift_JIS"), "Привет".encode("Windows-1251"), "é",
+	//  "\xff".b]
+	stream := []byte{
+		0x04, 0x08, 0x5b, 0x09, 0x49, 0x22, 0x09, 0x93,
+		0xfa, 0x96, 0x7b, 0x06, 0x3a, 0x0d, 0x65, 0x6e,
+		0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x22, 0x0e,
+		0x53, 0x68, 0x69, 0x66, 0x74, 0x5f, 0x4a, 0x49,
+		0x53, 0x49, 0x22, 0x0b, 0xcf, 0xf0, 0xe8, 0xe2,
+		0xe5, 0xf2, 0x06, 0x3b, 0x05, 0x22, 0x11, 0x57,
+		0x69, 0x6e, 0x64, 0x6f, 0x77, 0x73, 0x2d, 0x31,
+		0x32, 0x35, 0x31, 0x49, 0x22, 0x07, 0xc3, 0xa9,
+		0x06, 0x3a, 0x06, 0x45, 0x54, 0x22, 0x06, 0xff,
+	}
+
+	cases := []struct {
+		desc string
+		arg  *LoadArg
+		data interface{}
+	}{
+		{
+			"Default",
+			&LoadArg{},
+			makeSlice(
+				"\x93\xfa\x96\x7b",
+				"\xcf\xf0\xe8\xe2\xe5\xf2",
+				"é",
+				"\xff",
+			),
+		},
+		{
+			"Transcoded",
+			&LoadArg{TranscodeToUTF8: true},
+			makeSlice("日本", "Привет", "é", "\xff"),
+		},
+		{
+			"Transcoded with string encodings",
+			&LoadArg{TranscodeToUTF8: true, StringEncodings: true},
+			makeSlice(
+				RString{"日本", "UTF-8", nil},
+				RString{"Привет", "UTF-8", nil},
+				RString{"é", "UTF-8", nil},
+				RString{"\xff", "ASCII-8BIT", nil},
+			),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			buf := bufio.NewReader(bytes.NewReader(stream))
+
+			data, err := LoadWith(buf, c.arg)
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(data, c.data) {
+				t.Errorf("data: got %q, want %q", data, c.data)
+			}
+		})
+	}
+
+	t.Run("Unknown encoding", func(t *testing.T) {
+		stream := []byte{
+			0x04, 0x08, 0x49, 0x22, 0x06, 0x61, 0x06, 0x3a,
+			0x0d, 0x65, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e,
+			0x67, 0x22, 0x0f, 0x45, 0x42, 0x43, 0x44, 0x49,
+			0x43, 0x2d, 0x58, 0x59, 0x5a,
+		}
+
+		arg := &LoadArg{TranscodeToUTF8: true}
+		_, err := LoadWith(bufio.NewReader(bytes.NewReader(stream)), arg)
+		want := "cannot transcode from EBCDIC-XYZ at offset 29"
+		if err == nil || err.Error() != want {
+			t.Errorf("error: got %v, want %q", err, want)
+		}
+	})
+}
@@ -0,0 +1,564 @@
+package rbmarshal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal decodes the dump in data and stores the result in the value
+// pointed to by v, the way encoding/json does. Hashes, objects and structs
+// fill the fields of Go structs, matched by the name in their tag, like
+// `rbmarshal:"first_name"`, or else by their name in snake case, which
+// UnmarshalWith can change with LoadArg.FieldMatch. A hash key
+// matches whether it is a symbol or a string, and an object ivar matches with
+// its leading @, which the tag may include as well. Fields tagged "-" are left
+// alone, and those of embedded structs are filled as if they were fields of
+// the outer one.
+//
+// Arrays fill slices and Go arrays, hashes fill maps, and pointers are
+// allocated as needed. Numbers are converted to the numeric type of the
+// destination, failing if they don't fit, and strings and symbols fill
+// strings and byte slices. Values that implement Unmarshaler decode
+// themselves, interface{} gets the value as Load returns it, and RawValue the
+// dump of the value, to be decoded later. A value that refers back to itself,
+// like an object with an ivar set to the object, fills a pointer, a map or a
+// slice that refers back to itself too.
+func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalWith(data, v, new(LoadArg))
+}
+
+// UnmarshalWith is like Unmarshal but decodes according to the options set on
+// arg, such as FieldMatch.
+func UnmarshalWith(data []byte, v interface{}, arg *LoadArg) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal expects a non-nil pointer")
+	}
+
+	if hasRawValue(rv.Type(), map[reflect.Type]bool{}) {
+		doc := *arg
+		doc.Document = true
+		n, err := LoadBytesWith(data, &doc)
+		if err != nil {
+			return err
+		}
+		return assignNode(rv.Elem(), n.(*Node), arg)
+	}
+
+	obj, err := LoadBytesWith(data, arg)
+	if err != nil {
+		return err
+	}
+
+	return assign(rv.Elem(), obj, arg)
+}
+
+// FieldMatch is how the fields of a Go struct are matched with the hash keys,
+// ivars and struct members that fill them. A field is known by the name in
+// its tag, if it has one, and by its own name otherwise.
+type FieldMatch int
+
+const (
+	// SnakeCaseFields matches the name in the tag exactly, and the name of
+	// a field in snake case, so that FirstName is filled from first_name.
+	SnakeCaseFields FieldMatch = iota
+
+	// ExactFields matches a field by its name as it is.
+	ExactFields
+
+	// FoldFields matches any name that differs from that of a field only
+	// in case and underscores, so that FirstName is filled from
+	// first_name, firstName or FIRSTNAME. An exact match wins over others.
+	FoldFields
+)
+
+// convert stores src in dst when it can't be assigned as it is.
+func convert(dst reflect.Value, src interface{}, arg *LoadArg) error {
+	switch v := src.(type) {
+	case UClass:
+		return assign(dst, v.Value, arg)
+	case Extended:
+		return assign(dst, v.Value, arg)
+	case WithIvars:
+		return assign(dst, v.Value, arg)
+	case HashWithDefault:
+		return assign(dst, v.Hash, arg)
+	}
+
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return convertInt(dst, src)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return convertUint(dst, src)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(src)
+		if !ok {
+			break
+		}
+		if dst.OverflowFloat(f) {
+			return fmt.Errorf("%v overflows %v", src, dst.Type())
+		}
+		dst.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		if b, ok := src.(bool); ok {
+			dst.SetBool(b)
+			return nil
+		}
+	case reflect.String:
+		if s, ok := textValue(src); ok {
+			dst.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := textValue(src); ok {
+				dst.SetBytes([]byte(s))
+				return nil
+			}
+		}
+		if a, ok := src.([]interface{}); ok {
+			s := reflect.MakeSlice(dst.Type(), len(a), len(a))
+			seen, leave := enterConvert(arg, src, s)
+			if seen.IsValid() {
+				dst.Set(seen)
+				return nil
+			}
+			defer leave()
+			for i, e := range a {
+				if err := assign(s.Index(i), e, arg); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if a, ok := src.([]interface{}); ok {
+			if len(a) != dst.Len() {
+				return fmt.Errorf("cannot decode an array of %d into %v", len(a), dst.Type())
+			}
+			for i, e := range a {
+				if err := assign(dst.Index(i), e, arg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if pairs, ok := hashPairs(src); ok {
+			return convertMap(dst, src, pairs, arg)
+		}
+	case reflect.Struct:
+		if fields, ok := fieldSource(src); ok {
+			set := func(f reflect.Value, v interface{}) error {
+				return assign(f, v, arg)
+			}
+			return convertStruct(dst, fields, set, arg.FieldMatch)
+		}
+	case reflect.Ptr:
+		p := reflect.New(dst.Type().Elem())
+		seen, leave := enterConvert(arg, src, p)
+		if seen.IsValid() {
+			dst.Set(seen)
+			return nil
+		}
+		defer leave()
+		if err := assign(p.Elem(), src, arg); err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	}
+
+	return fmt.Errorf("cannot decode %T into %v", src, dst.Type())
+}
+
+// A convertKey stands for a source value, by the map or the array behind it,
+// being converted into a destination of some type.
+type convertKey struct {
+	src reflect.Type
+	ptr uintptr
+	len int
+	dst reflect.Type
+}
+
+// enterConvert records that src is being converted into v, a pointer, a map
+// or a slice that is filled afterwards, until leave is called. If src is
+// being converted into a value of the same type already, further up a cycle,
+// that value is returned instead, and v is useless.
+func enterConvert(arg *LoadArg, src interface{}, v reflect.Value) (seen reflect.Value, leave func()) {
+	key := convertKey{src: reflect.TypeOf(src), dst: v.Type()}
+	var contents reflect.Value
+	switch s := src.(type) {
+	case RObject:
+		contents = reflect.ValueOf(s.Ivars)
+	case RStruct:
+		contents = reflect.ValueOf(s.Members)
+	case KeyedHash:
+		contents = reflect.ValueOf(s.Values)
+	case []interface{}, map[string]interface{}, map[interface{}]interface{}, *OrderedHash, *Node:
+		contents = reflect.ValueOf(s)
+	}
+
+	switch contents.Kind() {
+	case reflect.Map, reflect.Ptr:
+		if contents.IsNil() {
+			return reflect.Value{}, func() {}
+		}
+		key.ptr = contents.Pointer()
+	case reflect.Slice:
+		// Empty slices may all share the same pointer.
+		if contents.Len() == 0 {
+			return reflect.Value{}, func() {}
+		}
+		key.ptr, key.len = contents.Pointer(), contents.Len()
+	default:
+		return reflect.Value{}, func() {}
+	}
+
+	if seen, ok := arg.converting[key]; ok {
+		return seen, nil
+	}
+	if arg.converting == nil {
+		arg.converting = make(map[convertKey]reflect.Value)
+	}
+	arg.converting[key] = v
+
+	return reflect.Value{}, func() { delete(arg.converting, key) }
+}
+
+func convertInt(dst reflect.Value, src interface{}) error {
+	if num, ok := src.(Number); ok {
+		b, err := num.BigInt()
+		if err != nil {
+			return err
+		}
+		src = b
+	}
+
+	var n int64
+	switch v := src.(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case *big.Int:
+		if !v.IsInt64() {
+			return fmt.Errorf("%v overflows %v", v, dst.Type())
+		}
+		n = v.Int64()
+	default:
+		return fmt.Errorf("cannot decode %T into %v", src, dst.Type())
+	}
+
+	if dst.OverflowInt(n) {
+		return fmt.Errorf("%v overflows %v", n, dst.Type())
+	}
+	dst.SetInt(n)
+
+	return nil
+}
+
+func convertUint(dst reflect.Value, src interface{}) error {
+	if num, ok := src.(Number); ok {
+		b, err := num.BigInt()
+		if err != nil {
+			return err
+		}
+		src = b
+	}
+
+	var n uint64
+	switch v := src.(type) {
+	case int:
+		if v < 0 {
+			return fmt.Errorf("%v overflows %v", v, dst.Type())
+		}
+		n = uint64(v)
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("%v overflows %v", v, dst.Type())
+		}
+		n = uint64(v)
+	case *big.Int:
+		if !v.IsUint64() {
+			return fmt.Errorf("%v overflows %v", v, dst.Type())
+		}
+		n = v.Uint64()
+	default:
+		return fmt.Errorf("cannot decode %T into %v", src, dst.Type())
+	}
+
+	if dst.OverflowUint(n) {
+		return fmt.Errorf("%v overflows %v", n, dst.Type())
+	}
+	dst.SetUint(n)
+
+	return nil
+}
+
+// textValue returns the text of a decoded string or symbol.
+func textValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case Symbol:
+		return string(v), true
+	case RString:
+		return v.Value, true
+	case []byte:
+		return string(v), true
+	case Number:
+		return string(v), true
+	}
+
+	return "", false
+}
+
+// hashPairs returns the pairs of any of the forms a hash decodes to.
+func hashPairs(v interface{}) ([]HashPair, bool) {
+	var pairs []HashPair
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			pairs = append(pairs, HashPair{k, e})
+		}
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			pairs = append(pairs, HashPair{k, e})
+		}
+	case KeyedHash:
+		for k, e := range v.Values {
+			pairs = append(pairs, HashPair{v.Keys[k], e})
+		}
+	case *OrderedHash:
+		pairs = v.Pairs
+	default:
+		return nil, false
+	}
+
+	return pairs, true
+}
+
+func convertMap(dst reflect.Value, src interface{}, pairs []HashPair, arg *LoadArg) error {
+	t := dst.Type()
+	m := reflect.MakeMapWithSize(t, len(pairs))
+	seen, leave := enterConvert(arg, src, m)
+	if seen.IsValid() {
+		dst.Set(seen)
+		return nil
+	}
+	defer leave()
+	for _, p := range pairs {
+		k := reflect.New(t.Key()).Elem()
+		if err := assignKey(k, p.Key, arg); err != nil {
+			return err
+		}
+		e := reflect.New(t.Elem()).Elem()
+		if err := assign(e, p.Value, arg); err != nil {
+			return err
+		}
+		m.SetMapIndex(k, e)
+	}
+	dst.Set(m)
+
+	return nil
+}
+
+// assignKey stores a hash key in k. Keys that were stringified on decoding
+// can fill numeric keys again.
+func assignKey(k reflect.Value, key interface{}, arg *LoadArg) error {
+	s, ok := key.(string)
+	if !ok {
+		return assign(k, key, arg)
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || k.OverflowInt(n) {
+			return fmt.Errorf("cannot decode key %q into %v", s, k.Type())
+		}
+		k.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || k.OverflowUint(n) {
+			return fmt.Errorf("cannot decode key %q into %v", s, k.Type())
+		}
+		k.SetUint(n)
+		return nil
+	}
+
+	return assign(k, key, arg)
+}
+
+// A fieldSet holds the values that can fill the fields of a struct. get
+// looks one up by its exact name, and each calls fn with every name and value
+// until fn returns true.
+type fieldSet struct {
+	get  func(name string) (interface{}, bool)
+	each func(fn func(name string, v interface{}) bool)
+}
+
+// lookup finds the value of the field known as name.
+func (s fieldSet) lookup(name string, fold bool) (interface{}, bool) {
+	if v, ok := s.get(name); ok || !fold {
+		return v, ok
+	}
+
+	var found interface{}
+	var ok bool
+	s.each(func(key string, v interface{}) bool {
+		if foldName(key) == foldName(name) {
+			found, ok = v, true
+		}
+		return ok
+	})
+
+	return found, ok
+}
+
+// foldName is how FoldFields compares names.
+func foldName(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+}
+
+// fieldSource returns the fieldSet of the values that can fill a struct.
+// Ivars are named without their @.
+func fieldSource(v interface{}) (fieldSet, bool) {
+	switch v := v.(type) {
+	case RObject:
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				e, ok := v.Ivars["@"+name]
+				return e, ok
+			},
+			each: func(fn func(string, interface{}) bool) {
+				for k, e := range v.Ivars {
+					if fn(strings.TrimPrefix(k, "@"), e) {
+						return
+					}
+				}
+			},
+		}, true
+	case RStruct:
+		return fieldSet{
+			get: v.Member,
+			each: func(fn func(string, interface{}) bool) {
+				for _, m := range v.Members {
+					if fn(m.Name, m.Value) {
+						return
+					}
+				}
+			},
+		}, true
+	case map[string]interface{}:
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				e, ok := v[name]
+				return e, ok
+			},
+			each: func(fn func(string, interface{}) bool) {
+				for k, e := range v {
+					if fn(k, e) {
+						return
+					}
+				}
+			},
+		}, true
+	case KeyedHash:
+		return fieldSource(v.Values)
+	case map[interface{}]interface{}:
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				if e, ok := v[Symbol(name)]; ok {
+					return e, true
+				}
+				e, ok := v[name]
+				return e, ok
+			},
+			each: func(fn func(string, interface{}) bool) {
+				for k, e := range v {
+					if name, ok := keyName(k); ok && fn(name, e) {
+						return
+					}
+				}
+			},
+		}, true
+	case *OrderedHash:
+		return fieldSet{
+			get: func(name string) (interface{}, bool) {
+				if e, ok := v.Get(Symbol(name)); ok {
+					return e, true
+				}
+				return v.Get(name)
+			},
+			each: func(fn func(string, interface{}) bool) {
+				for _, p := range v.Pairs {
+					if name, ok := keyName(p.Key); ok && fn(name, p.Value) {
+						return
+					}
+				}
+			},
+		}, true
+	}
+
+	return fieldSet{}, false
+}
+
+// keyName returns the name of a hash key that is a symbol or a string.
+func keyName(key interface{}) (string, bool) {
+	switch k := key.(type) {
+	case Symbol:
+		return string(k), true
+	case string:
+		return k, true
+	}
+
+	return "", false
+}
+
+// convertStruct fills the fields of dst with the values found in fields,
+// passing each one to set.
+func convertStruct(dst reflect.Value, fields fieldSet, set func(reflect.Value, interface{}) error, match FieldMatch) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("rbmarshal")
+		if tag == "-" || f.Name == "_" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			if err := convertStruct(dst.Field(i), fields, set, match); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.TrimPrefix(tag, "@")
+		if name == "" {
+			name = f.Name
+			if match == SnakeCaseFields {
+				name = snakeCase(f.Name)
+			}
+		}
+		v, ok := fields.lookup(name, match == FoldFields)
+		if !ok {
+			continue
+		}
+		if err := set(dst.Field(i), v); err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package rbmarshal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type unmarshalAddress struct {
+	City string
+}
+
+type unmarshalUser struct {
+	Name    string
+	Age     uint8
+	Tags    []string
+	Address *unmarshalAddress
+	Score   float32
+	Admin   bool
+	Nick    *string
+	Ignored string `rbmarshal:"-"`
+}
+
+type unmarshalRecord struct {
+	ID        int
+	CreatedAt int64
+}
+
+type unmarshalAccount struct {
+	FirstName string `rbmarshal:"@first_name"`
+	unmarshalRecord
+}
+
+type unmarshalPoint struct {
+	X, Y float64
+}
+
+func TestUnmarshal(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		v      interface{}
+		want   interface{}
+	}{
+		{
+			// {name: "Ann", age: 30, tags: ["a", "b"], address: {"city"=>"Kyiv"},
+			//  score: 1.5, admin: true, nick: nil}
+			"Hash into a struct",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x0c, 0x3a, 0x09, 0x6e, 0x61,
+				0x6d, 0x65, 0x49, 0x22, 0x08, 0x41, 0x6e, 0x6e,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x3a, 0x08, 0x61,
+				0x67, 0x65, 0x69, 0x23, 0x3a, 0x09, 0x74, 0x61,
+				0x67, 0x73, 0x5b, 0x07, 0x49, 0x22, 0x06, 0x61,
+				0x06, 0x3b, 0x06, 0x54, 0x49, 0x22, 0x06, 0x62,
+				0x06, 0x3b, 0x06, 0x54, 0x3a, 0x0c, 0x61, 0x64,
+				0x64, 0x72, 0x65, 0x73, 0x73, 0x7b, 0x06, 0x49,
+				0x22, 0x09, 0x63, 0x69, 0x74, 0x79, 0x06, 0x3b,
+				0x06, 0x54, 0x49, 0x22, 0x09, 0x4b, 0x79, 0x69,
+				0x76, 0x06, 0x3b, 0x06, 0x54, 0x3a, 0x0a, 0x73,
+				0x63, 0x6f, 0x72, 0x65, 0x66, 0x08, 0x31, 0x2e,
+				0x35, 0x3a, 0x0a, 0x61, 0x64, 0x6d, 0x69, 0x6e,
+				0x54, 0x3a, 0x09, 0x6e, 0x69, 0x63, 0x6b, 0x30,
+			},
+			&unmarshalUser{Ignored: "kept"},
+			&unmarshalUser{
+				Name:    "Ann",
+				Age:     30,
+				Tags:    []string{"a", "b"},
+				Address: &unmarshalAddress{City: "Kyiv"},
+				Score:   1.5,
+				Admin:   true,
+				Ignored: "kept",
+			},
+		},
+		{
+			// User.new with @first_name = "Ann", @id = 7, @created_at = 1
+			"Object into a struct",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x09, 0x55, 0x73, 0x65,
+				0x72, 0x08, 0x3a, 0x10, 0x40, 0x66, 0x69, 0x72,
+				0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x49,
+				0x22, 0x08, 0x41, 0x6e, 0x6e, 0x06, 0x3a, 0x06,
+				0x45, 0x54, 0x3a, 0x08, 0x40, 0x69, 0x64, 0x69,
+				0x0c, 0x3a, 0x10, 0x40, 0x63, 0x72, 0x65, 0x61,
+				0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x69, 0x06,
+			},
+			&unmarshalAccount{},
+			&unmarshalAccount{"Ann", unmarshalRecord{ID: 7, CreatedAt: 1}},
+		},
+		{
+			// Point = Struct.new(:x, :y); Point.new(1, 2)
+			"Struct into a struct",
+			[]byte{
+				0x04, 0x08, 0x53, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x06, 0x78, 0x69, 0x06,
+				0x3a, 0x06, 0x79, 0x69, 0x07,
+			},
+			new(unmarshalPoint),
+			&unmarshalPoint{1, 2},
+		},
+		{
+			// {1=>"a", 2=>"b"}
+			"Hash into a map",
+			[]byte{
+				0x04, 0x08, 0x7b, 0x07, 0x69, 0x06, 0x49, 0x22,
+				0x06, 0x61, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x69,
+				0x07, 0x49, 0x22, 0x06, 0x62, 0x06, 0x3b, 0x00,
+				0x54,
+			},
+			new(map[int]string),
+			&map[int]string{1: "a", 2: "b"},
+		},
+		{
+			// [1, 2]
+			"Array into a Go array",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x69, 0x06, 0x69, 0x07},
+			new([2]int16),
+			&[2]int16{1, 2},
+		},
+		{
+			// :sym
+			"Symbol into bytes",
+			[]byte{0x04, 0x08, 0x3a, 0x08, 0x73, 0x79, 0x6d},
+			new([]byte),
+			&[]byte{'s', 'y', 'm'},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			if err := Unmarshal(c.stream, c.v); err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			if !reflect.DeepEqual(c.v, c.want) {
+				t.Errorf("got %+v, want %+v", c.v, c.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		v      interface{}
+		err    string
+	}{
+		{
+			// 300
+			"Overflow",
+			[]byte{0x04, 0x08, 0x69, 0x02, 0x2c, 0x01},
+			new(int8),
+			"300 overflows int8",
+		},
+		{
+			// -1
+			"Negative into unsigned",
+			[]byte{0x04, 0x08, 0x69, 0xfa},
+			new(uint),
+			"-1 overflows uint",
+		},
+		{
+			// "a".b
+			"Mismatch",
+			[]byte{0x04, 0x08, 0x22, 0x06, 0x61},
+			new(int),
+			"cannot decode string into int",
+		},
+		{
+			// Point = Struct.new(:x, :y); Point.new(1, 2)
+			"Mismatched field",
+			[]byte{
+				0x04, 0x08, 0x53, 0x3a, 0x0a, 0x50, 0x6f, 0x69,
+				0x6e, 0x74, 0x07, 0x3a, 0x06, 0x78, 0x69, 0x06,
+				0x3a, 0x06, 0x79, 0x69, 0x07,
+			},
+			new(struct{ X bool }),
+			"field X: cannot decode int into bool",
+		},
+		{
+			// true
+			"Not a pointer",
+			[]byte{0x04, 0x08, 0x54},
+			false,
+			"Unmarshal expects a non-nil pointer",
+		},
+	}
+
+	for _, c := range cases {
+		err := Unmarshal(c.stream, c.v)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: got error %v, want %q", c.desc, err, c.err)
+		}
+	}
+}
+
+func TestUnmarshalFieldMatch(t *testing.T) {
+	// {first_name: "a", "lastName" => "b", AGE: 3}
+	data := []byte{
+		0x04, 0x08, 0x7b, 0x08, 0x3a, 0x0f, 0x66, 0x69,
+		0x72, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65,
+		0x49, 0x22, 0x06, 0x61, 0x06, 0x3a, 0x06, 0x45,
+		0x54, 0x49, 0x22, 0x0d, 0x6c, 0x61, 0x73, 0x74,
+		0x4e, 0x61, 0x6d, 0x65, 0x06, 0x3b, 0x06, 0x54,
+		0x49, 0x22, 0x06, 0x62, 0x06, 0x3b, 0x06, 0x54,
+		0x3a, 0x08, 0x41, 0x47, 0x45, 0x69, 0x08,
+	}
+
+	type person struct {
+		FirstName string
+		LastName  string
+		AGE       int
+		Nick      string `rbmarshal:"first_name"`
+	}
+
+	cases := []struct {
+		match FieldMatch
+		want  person
+	}{
+		{SnakeCaseFields, person{FirstName: "a", Nick: "a"}},
+		{ExactFields, person{AGE: 3, Nick: "a"}},
+		{FoldFields, person{"a", "b", 3, "a"}},
+	}
+
+	for _, c := range cases {
+		var p person
+		if err := UnmarshalWith(data, &p, &LoadArg{FieldMatch: c.match}); err != nil {
+			t.Fatalf("%d: unexpected error: %v", c.match, err)
+		}
+		if p != c.want {
+			t.Errorf("%d: got %+v, want %+v", c.match, p, c.want)
+		}
+	}
+
+	// Decoders match fields the same way.
+	d := NewDecoder(bytes.NewReader(data))
+	d.FieldMatch = FoldFields
+	var p person
+	if err := d.Decode(&p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (person{"a", "b", 3, "a"}); p != want {
+		t.Errorf("Decode: got %+v, want %+v", p, want)
+	}
+}
+
+func TestFieldSetLookup(t *testing.T) {
+	fields, _ := fieldSource(map[string]interface{}{"user_id": 1, "UserID": 2})
+
+	if v, ok := fields.lookup("UserID", true); !ok || v != 2 {
+		t.Errorf("exact match: got %v and %v, want 2", v, ok)
+	}
+	if v, ok := fields.lookup("userid", true); !ok || (v != 1 && v != 2) {
+		t.Errorf("folded match: got %v and %v, want 1 or 2", v, ok)
+	}
+	if _, ok := fields.lookup("userid", false); ok {
+		t.Error("unfolded lookup matched a folded name")
+	}
+}
+
+type unmarshalNode struct {
+	Me *unmarshalNode `rbmarshal:"@me"`
+}
+
+type unmarshalRawNode struct {
+	Me  *unmarshalRawNode `rbmarshal:"@me"`
+	Raw RawValue          `rbmarshal:"@raw"`
+}
+
+type unmarshalList []unmarshalList
+
+type unmarshalTree map[string]unmarshalTree
+
+func TestUnmarshalCycles(t *testing.T) {
+	// o = Foo.new; o.instance_variable_set(:@me, o); o
+	object := []byte{
+		0x04, 0x08, 0x6f, 0x3a, 0x08, 0x46, 0x6f, 0x6f,
+		0x06, 0x3a, 0x08, 0x40, 0x6d, 0x65, 0x40, 0x00,
+	}
+
+	var node unmarshalNode
+	if err := Unmarshal(object, &node); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if node.Me == nil || node.Me.Me != node.Me {
+		t.Errorf("Unmarshal: got %+v, want a pointer to itself", node.Me)
+	}
+
+	node = unmarshalNode{}
+	if err := NewDecoder(bytes.NewReader(object)).Decode(&node); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if node.Me == nil || node.Me.Me != node.Me {
+		t.Errorf("Decode: got %+v, want a pointer to itself", node.Me)
+	}
+
+	var raw unmarshalRawNode
+	if err := Unmarshal(object, &raw); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if raw.Me == nil || raw.Me.Me != raw.Me {
+		t.Errorf("RawValue: got %+v, want a pointer to itself", raw.Me)
+	}
+
+	// a = []; a << a
+	var list unmarshalList
+	if err := Unmarshal([]byte{0x04, 0x08, 0x5b, 0x06, 0x40, 0x00}, &list); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if len(list) != 1 || len(list[0]) != 1 || &list[0][0] != &list[0] {
+		t.Errorf("slice: got a list of %d, want one that holds itself", len(list))
+	}
+
+	// h = {}; h["self"] = h
+	var tree unmarshalTree
+	stream := []byte{
+		0x04, 0x08, 0x7b, 0x06, 0x49, 0x22, 0x09, 0x73,
+		0x65, 0x6c, 0x66, 0x06, 0x3a, 0x06, 0x45, 0x54,
+		0x40, 0x00,
+	}
+	if err := Unmarshal(stream, &tree); err != nil {
+		t.Fatalf("unexpected error: '%q'", err)
+	}
+	if self, ok := tree["self"]; !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(tree).Pointer() {
+		t.Errorf("map: got %d keys, want one that holds the map itself", len(tree))
+	}
+}
@@ -0,0 +1,84 @@
+package rbmarshal
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// The default ports of the URI classes of the standard library. URI#to_s
+// leaves out a port that is the default of its class.
+var uriDefaultPorts = map[string]int{
+	"URI::Generic": 0,
+	"URI::File":    0,
+	"URI::FTP":     21,
+	"URI::HTTP":    80,
+	"URI::HTTPS":   443,
+	"URI::LDAP":    389,
+	"URI::LDAPS":   636,
+	"URI::MailTo":  0,
+	"URI::WS":      80,
+	"URI::WSS":     443,
+}
+
+// URIs are dumped as objects with one ivar per component, already escaped.
+// They decode to *url.URL, by putting the components back together the way
+// URI::Generic#to_s does.
+func decodeURI(o RObject) (interface{}, error) {
+	c := make(map[string]string)
+	for _, name := range []string{
+		"@scheme", "@opaque", "@user", "@password",
+		"@host", "@path", "@query", "@fragment",
+	} {
+		v := o.Ivars[name]
+		if v == nil {
+			continue
+		}
+		str, ok := stringValue(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s ivars %v", o.Class, o.Ivars)
+		}
+		c[name] = str
+	}
+	has := func(name string) bool {
+		_, ok := c[name]
+		return ok
+	}
+
+	var s string
+	if has("@scheme") {
+		s += c["@scheme"] + ":"
+	}
+	if has("@opaque") {
+		s += c["@opaque"]
+	} else {
+		if has("@host") || c["@scheme"] == "file" || c["@scheme"] == "postgres" {
+			s += "//"
+		}
+		if has("@user") {
+			s += c["@user"]
+			if has("@password") {
+				s += ":" + c["@password"]
+			}
+			s += "@"
+		}
+		s += c["@host"]
+		if port, ok := intOf(o.Ivars["@port"]); ok && port != uriDefaultPorts[o.Class] {
+			s += ":" + strconv.Itoa(port)
+		}
+		s += c["@path"]
+		if has("@query") {
+			s += "?" + c["@query"]
+		}
+	}
+	if has("@fragment") {
+		s += "#" + c["@fragment"]
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", o.Class, s, err)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,115 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+func TestLoadURI(t *testing.T) {
+	cases := []struct {
+		desc   string
+		stream []byte
+		want   string
+	}{
+		{
+			// URI("https://user:pw@example.com/a%20b?q=1#top")
+			"Every component",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0f, 0x55, 0x52, 0x49,
+				0x3a, 0x3a, 0x48, 0x54, 0x54, 0x50, 0x53, 0x0f,
+				0x3a, 0x0c, 0x40, 0x73, 0x63, 0x68, 0x65, 0x6d,
+				0x65, 0x49, 0x22, 0x0a, 0x68, 0x74, 0x74, 0x70,
+				0x73, 0x06, 0x3a, 0x06, 0x45, 0x54, 0x3a, 0x0a,
+				0x40, 0x75, 0x73, 0x65, 0x72, 0x49, 0x22, 0x09,
+				0x75, 0x73, 0x65, 0x72, 0x06, 0x3b, 0x07, 0x54,
+				0x3a, 0x0e, 0x40, 0x70, 0x61, 0x73, 0x73, 0x77,
+				0x6f, 0x72, 0x64, 0x49, 0x22, 0x07, 0x70, 0x77,
+				0x06, 0x3b, 0x07, 0x54, 0x3a, 0x0a, 0x40, 0x68,
+				0x6f, 0x73, 0x74, 0x49, 0x22, 0x10, 0x65, 0x78,
+				0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f,
+				0x6d, 0x06, 0x3b, 0x07, 0x54, 0x3a, 0x0a, 0x40,
+				0x70, 0x6f, 0x72, 0x74, 0x69, 0x02, 0xbb, 0x01,
+				0x3a, 0x0a, 0x40, 0x70, 0x61, 0x74, 0x68, 0x49,
+				0x22, 0x0b, 0x2f, 0x61, 0x25, 0x32, 0x30, 0x62,
+				0x06, 0x3b, 0x07, 0x54, 0x3a, 0x0b, 0x40, 0x71,
+				0x75, 0x65, 0x72, 0x79, 0x49, 0x22, 0x08, 0x71,
+				0x3d, 0x31, 0x06, 0x3b, 0x07, 0x54, 0x3a, 0x0c,
+				0x40, 0x6f, 0x70, 0x61, 0x71, 0x75, 0x65, 0x30,
+				0x3a, 0x0e, 0x40, 0x66, 0x72, 0x61, 0x67, 0x6d,
+				0x65, 0x6e, 0x74, 0x49, 0x22, 0x08, 0x74, 0x6f,
+				0x70, 0x06, 0x3b, 0x07, 0x54, 0x3a, 0x0c, 0x40,
+				0x70, 0x61, 0x72, 0x73, 0x65, 0x72, 0x30,
+			},
+			"https://user:pw@example.com/a%20b?q=1#top",
+		},
+		{
+			// URI("http://localhost:3000")
+			"Port other than the default",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x0e, 0x55, 0x52, 0x49,
+				0x3a, 0x3a, 0x48, 0x54, 0x54, 0x50, 0x0f, 0x3a,
+				0x0c, 0x40, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x65,
+				0x49, 0x22, 0x09, 0x68, 0x74, 0x74, 0x70, 0x06,
+				0x3a, 0x06, 0x45, 0x54, 0x3a, 0x0a, 0x40, 0x75,
+				0x73, 0x65, 0x72, 0x30, 0x3a, 0x0e, 0x40, 0x70,
+				0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x30,
+				0x3a, 0x0a, 0x40, 0x68, 0x6f, 0x73, 0x74, 0x49,
+				0x22, 0x0e, 0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x68,
+				0x6f, 0x73, 0x74, 0x06, 0x3b, 0x07, 0x54, 0x3a,
+				0x0a, 0x40, 0x70, 0x6f, 0x72, 0x74, 0x69, 0x02,
+				0xb8, 0x0b, 0x3a, 0x0a, 0x40, 0x70, 0x61, 0x74,
+				0x68, 0x49, 0x22, 0x00, 0x06, 0x3b, 0x07, 0x54,
+				0x3a, 0x0b, 0x40, 0x71, 0x75, 0x65, 0x72, 0x79,
+				0x30, 0x3a, 0x0c, 0x40, 0x6f, 0x70, 0x61, 0x71,
+				0x75, 0x65, 0x30, 0x3a, 0x0e, 0x40, 0x66, 0x72,
+				0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x30, 0x3a,
+				0x0c, 0x40, 0x70, 0x61, 0x72, 0x73, 0x65, 0x72,
+				0x30,
+			},
+			"http://localhost:3000",
+		},
+		{
+			// URI("mailto:a@example.com")
+			"Opaque",
+			[]byte{
+				0x04, 0x08, 0x6f, 0x3a, 0x10, 0x55, 0x52, 0x49,
+				0x3a, 0x3a, 0x4d, 0x61, 0x69, 0x6c, 0x54, 0x6f,
+				0x0f, 0x3a, 0x0c, 0x40, 0x73, 0x63, 0x68, 0x65,
+				0x6d, 0x65, 0x49, 0x22, 0x0b, 0x6d, 0x61, 0x69,
+				0x6c, 0x74, 0x6f, 0x06, 0x3a, 0x06, 0x45, 0x54,
+				0x3a, 0x0a, 0x40, 0x75, 0x73, 0x65, 0x72, 0x30,
+				0x3a, 0x0e, 0x40, 0x70, 0x61, 0x73, 0x73, 0x77,
+				0x6f, 0x72, 0x64, 0x30, 0x3a, 0x0a, 0x40, 0x68,
+				0x6f, 0x73, 0x74, 0x30, 0x3a, 0x0a, 0x40, 0x70,
+				0x6f, 0x72, 0x74, 0x30, 0x3a, 0x0a, 0x40, 0x70,
+				0x61, 0x74, 0x68, 0x30, 0x3a, 0x0b, 0x40, 0x71,
+				0x75, 0x65, 0x72, 0x79, 0x30, 0x3a, 0x0c, 0x40,
+				0x6f, 0x70, 0x61, 0x71, 0x75, 0x65, 0x49, 0x22,
+				0x12, 0x61, 0x40, 0x65, 0x78, 0x61, 0x6d, 0x70,
+				0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x06, 0x3b,
+				0x07, 0x54, 0x3a, 0x0e, 0x40, 0x66, 0x72, 0x61,
+				0x67, 0x6d, 0x65, 0x6e, 0x74, 0x30, 0x3a, 0x0c,
+				0x40, 0x70, 0x61, 0x72, 0x73, 0x65, 0x72, 0x30,
+			},
+			"mailto:a@example.com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := Load(bufio.NewReader(bytes.NewReader(c.stream)))
+			if err != nil {
+				t.Fatalf("unexpected error: '%q'", err)
+			}
+			u, ok := data.(*url.URL)
+			if !ok {
+				t.Fatalf("data: got %T, want *url.URL", data)
+			}
+			if u.String() != c.want {
+				t.Errorf("data: got %v, want %v", u, c.want)
+			}
+		})
+	}
+}
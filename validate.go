@@ -0,0 +1,224 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Validate checks that r holds exactly one well-formed dump, without building
+// any of the values in it. It is cheaper than Load when only the validity of
+// the data matters, such as for a pre-flight check of an upload. The limits
+// set on arg apply as they would for LoadWith.
+func Validate(r *bufio.Reader, arg *LoadArg) (err error) {
+	defer recoverPanic(&err, arg)
+
+	resetLoadArg(arg)
+	if err := validateVersion(r, arg); err != nil {
+		return decodeError(err, arg)
+	}
+
+	if err := skipValue(r, arg); err != nil {
+		return decodeError(err, arg)
+	}
+
+	return checkTrailing(r, arg)
+}
+
+// checkTrailing fails with ErrTrailingData unless r is at its end, where a
+// single dump must stop.
+func checkTrailing(r byteReader, arg *LoadArg) error {
+	_, err := r.Peek(1)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return decodeError(ErrTrailingData, arg)
+}
+
+// skipValue reads past the next value in the stream. Symbols and objects are
+// still accounted for in arg, so that the links pointing to them can be
+// checked, but nothing else is kept.
+func skipValue(r byteReader, arg *LoadArg) error {
+	if err := enterValue(arg); err != nil {
+		return err
+	}
+	defer leaveValue(arg)
+
+	offset := arg.offset
+	t, err := readByte(r, arg)
+	if err != nil {
+		return err
+	}
+
+	switch t {
+	case typeNil, typeTrue, typeFalse:
+		return nil
+	case typeFixnum:
+		_, err = readFixnum(r, arg)
+		return err
+	case typeSymbol:
+		_, err = readSymbol(r, arg)
+		return err
+	case typeSymlink:
+		return skipLink(r, arg, len(arg.Symbols), ErrBadSymlink)
+	case typeObjlink:
+		return skipLink(r, arg, len(arg.Objects), ErrBadLink)
+	case typeIvar:
+		if err = skipValue(r, arg); err != nil {
+			return err
+		}
+
+		return skipPairs(r, arg, readName)
+	case typeExtended, typeUclass:
+		if _, err = readName(r, arg); err != nil {
+			return err
+		}
+
+		return skipValue(r, arg)
+	case typeUserdef:
+		if _, err = readName(r, arg); err != nil {
+			return err
+		}
+		if err = skipBytes(r, arg); err != nil {
+			return err
+		}
+		arg.Objects = append(arg.Objects, nil)
+
+		return nil
+	}
+
+	// Everything else takes a slot in the object table before its
+	// contents are read.
+	arg.Objects = append(arg.Objects, nil)
+
+	switch t {
+	case typeString, typeFloat, typeClass, typeModule, typeModuleOld:
+		return skipBytes(r, arg)
+	case typeRegexp:
+		if err = skipBytes(r, arg); err != nil {
+			return err
+		}
+
+		// The options.
+		_, err = readByte(r, arg)
+		return err
+	case typeBignum:
+		// The sign, then the length in 16 bit words.
+		if _, err = readByte(r, arg); err != nil {
+			return err
+		}
+		size, err := readStringLen(r, arg)
+		if err != nil {
+			return err
+		}
+
+		return discard(r, arg, 2*size)
+	case typeArray:
+		size, err := readCollectionLen(r, arg)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < size; i++ {
+			if err = skipValue(r, arg); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case typeHash, typeHashDef:
+		if err = skipPairs(r, arg, skipValueName); err != nil {
+			return err
+		}
+
+		if t == typeHashDef {
+			return skipValue(r, arg)
+		}
+
+		return nil
+	case typeObject, typeStruct:
+		if _, err = readName(r, arg); err != nil {
+			return err
+		}
+
+		return skipPairs(r, arg, readName)
+	case typeData, typeUsrmarshal:
+		if _, err = readName(r, arg); err != nil {
+			return err
+		}
+
+		return skipValue(r, arg)
+	default:
+		return &UnsupportedTypeError{TypeByte: t, Offset: offset}
+	}
+}
+
+// skipPairs reads past a count of pairs, each made of a key read with key and
+// a value.
+func skipPairs(
+	r byteReader,
+	arg *LoadArg,
+	key func(byteReader, *LoadArg) (string, error),
+) error {
+	size, err := readCollectionLen(r, arg)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < size; i++ {
+		if _, err = key(r, arg); err != nil {
+			return err
+		}
+		if err = skipValue(r, arg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipValueName lets skipValue stand in for the key reader of skipPairs.
+func skipValueName(r byteReader, arg *LoadArg) (string, error) {
+	return "", skipValue(r, arg)
+}
+
+func skipLink(r byteReader, arg *LoadArg, size int, bad error) error {
+	i, err := readFixnum(r, arg)
+	if err != nil {
+		return err
+	}
+	if i < 0 || i >= size {
+		return fmt.Errorf("%w %d", bad, i)
+	}
+
+	return nil
+}
+
+// skipBytes reads past a length-prefixed byte string.
+func skipBytes(r byteReader, arg *LoadArg) error {
+	size, err := readStringLen(r, arg)
+	if err != nil {
+		return err
+	}
+
+	return discard(r, arg, size)
+}
+
+// discard reads past n bytes without keeping them.
+func discard(r byteReader, arg *LoadArg, n int) error {
+	if err := checkBudget(arg, n); err != nil {
+		return err
+	}
+
+	d, err := r.Discard(n)
+	arg.offset += int64(d)
+	if err == io.EOF && d > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package rbmarshal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	// {foo: 1, bar: "baz", array: [1, 2, //], hash: { bingo: 1.2, bango: 3.4, bongo: ["hi"] }}
+	valid := []byte{
+		0x04, 0x08, 0x7b, 0x09, 0x3a, 0x08, 0x66, 0x6f,
+		0x6f, 0x69, 0x06, 0x3a, 0x08, 0x62, 0x61, 0x72,
+		0x49, 0x22, 0x08, 0x62, 0x61, 0x7a, 0x06, 0x3a,
+		0x06, 0x45, 0x54, 0x3a, 0x0a, 0x61, 0x72, 0x72,
+		0x61, 0x79, 0x5b, 0x08, 0x69, 0x06, 0x69, 0x07,
+		0x49, 0x2f, 0x00, 0x00, 0x06, 0x3b, 0x07, 0x46,
+		0x3a, 0x09, 0x68, 0x61, 0x73, 0x68, 0x7b, 0x08,
+		0x3a, 0x0a, 0x62, 0x69, 0x6e, 0x67, 0x6f, 0x66,
+		0x08, 0x31, 0x2e, 0x32, 0x3a, 0x0a, 0x62, 0x61,
+		0x6e, 0x67, 0x6f, 0x66, 0x08, 0x33, 0x2e, 0x34,
+		0x3a, 0x0a, 0x62, 0x6f, 0x6e, 0x67, 0x6f, 0x5b,
+		0x06, 0x49, 0x22, 0x07, 0x68, 0x69, 0x06, 0x3b,
+		0x07, 0x54,
+	}
+
+	cases := []struct {
+		desc   string
+		stream []byte
+		arg    *LoadArg
+		err    error
+	}{
+		{
+			"Valid dump",
+			valid,
+			&LoadArg{},
+			nil,
+		},
+		{
+			"Valid dump with object links",
+			// s = "x"; [s, s, Point.new]
+			[]byte{
+				0x04, 0x08, 0x5b, 0x08, 0x49, 0x22, 0x06, 0x78,
+				0x06, 0x3a, 0x06, 0x45, 0x54, 0x40, 0x06, 0x6f,
+				0x3a, 0x0a, 0x50, 0x6f, 0x69, 0x6e, 0x74, 0x00,
+			},
+			&LoadArg{},
+			nil,
+		},
+		{
+			"Truncated dump",
+			valid[:len(valid)-5],
+			&LoadArg{},
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"Truncated string",
+			[]byte{0x04, 0x08, 0x22, 0x0a, 0x61},
+			&LoadArg{},
+			io.ErrUnexpectedEOF,
+		},
+		{
+			"Trailing garbage",
+			append(append([]byte{}, valid...), 0x00),
+			&LoadArg{},
+			ErrTrailingData,
+		},
+		{
+			"Symlink out of range",
+			[]byte{0x04, 0x08, 0x5b, 0x07, 0x3a, 0x06, 0x61, 0x3b, 0x06},
+			&LoadArg{},
+			ErrBadSymlink,
+		},
+		{
+			"Object link out of range",
+			[]byte{0x04, 0x08, 0x5b, 0x06, 0x40, 0x06},
+			&LoadArg{},
+			ErrBadLink,
+		},
+		{
+			"Unsupported type byte",
+			[]byte{0x04, 0x08, 0x5b, 0x06, 0x5a},
+			&LoadArg{},
+			ErrUnsupportedType,
+		},
+		{
+			"Byte budget",
+			valid,
+			&LoadArg{MaxBytes: 50},
+			ErrBudgetExceeded,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			err := Validate(bufio.NewReader(bytes.NewReader(c.stream)), c.arg)
+			switch {
+			case c.err == nil && err != nil:
+				t.Fatalf("unexpected error: '%q'", err)
+			case c.err != nil && err == nil:
+				t.Fatalf("expected error %q, got nothing", c.err)
+			case c.err != nil && !errors.Is(err, c.err) && err.Error() != c.err.Error():
+				t.Errorf("error: got %q, want %q", err, c.err)
+			}
+		})
+	}
+}